@@ -7,8 +7,12 @@ curl -Lo ./kubectl "https://dl.k8s.io/release/${K8S_VERSION}/bin/linux/amd64/kub
 # Install kind
 curl -Lo ./kind "https://github.com/kubernetes-sigs/kind/releases/download/${KIND_VERSION}/kind-linux-amd64" && chmod +x ./kind && sudo mv ./kind /usr/local/bin/
 
-# Create a single node cluster
-kind create cluster --image "kindest/node:${K8S_VERSION}"
+# Create a single node cluster, configured by the file $KIND_CONFIG in this directory if set (e.g. for dual-stack)
+KIND_ARGS=""
+if [ -n "${KIND_CONFIG}" ]; then
+  KIND_ARGS="--config $(dirname "$0")/${KIND_CONFIG}"
+fi
+kind create cluster --image "kindest/node:${K8S_VERSION}" ${KIND_ARGS}
 
 # Wait for cluster to be ready
 kubectl wait --for=condition=Ready nodes --all --timeout=60s >/dev/null 2>&1
@@ -19,9 +23,16 @@ kubectl scale -n kube-system deployment/coredns --replicas=1
 # Patch CoreDNS deployment to use local coredns image
 kubectl patch deployment coredns -n kube-system -p "$(cat ~/go/src/${CIRCLE_PROJECT_USERNAME}/ci/build/kubernetes/coredns_deployment_patch.yaml)"
 
-# Patch CoreDNS clusterRoles to allow list/watch of EndpointSlice.
-# Remove this once EndpointSlice is part of the default CoreDNS clusterRoles.
+# Patch CoreDNS clusterRoles to allow list/watch of EndpointSlice, and of the ServiceImport of multicluster tests.
+# Remove the EndpointSlice rule once it is part of the default CoreDNS clusterRoles.
 kubectl patch clusterroles system:coredns -n kube-system -p "$(cat ~/go/src/${CIRCLE_PROJECT_USERNAME}/ci/build/kubernetes/coredns_clusterroles_patch.yaml)"
 
 # Deploy test objects
 kubectl create -f ~/go/src/${CIRCLE_PROJECT_USERNAME}/ci/build/kubernetes/dns-test.yaml
+
+# Wait for the test deployments and pods to be ready, except the "unready" deployments which never are
+kubectl -n test-1 wait --for=condition=Available deployment/de-1-b deployment/de-c --timeout=120s
+kubectl -n test-2 wait --for=condition=Available deployment/de-c --timeout=120s
+kubectl -n test-3 wait --for=condition=Ready pod --all --timeout=120s
+kubectl -n test-4 wait --for=condition=Available deployment/de-1-b deployment/de-c --timeout=120s
+kubectl -n test-5 wait --for=condition=Ready pod --all --timeout=120s
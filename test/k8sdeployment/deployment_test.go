@@ -159,28 +159,5 @@ func TestKubernetesDeploymentMetrics(t *testing.T) {
 
 func TestKubernetesDeploymentDNSQueries(t *testing.T) {
 	// Verify dns query test strict cases
-	testCases := deploymentDNSCases
-	namespace := "test-1"
-	err := kubernetes.StartClientPod(namespace)
-	if err != nil {
-		t.Fatalf("failed to start client pod: %s", err)
-	}
-	for _, tc := range testCases {
-		t.Run(fmt.Sprintf("%s %s", tc.Qname, dns.TypeToString[tc.Qtype]), func(t *testing.T) {
-			res, err := kubernetes.DoIntegrationTest(tc, namespace)
-			if err != nil {
-				t.Error(err.Error())
-			}
-			if res == nil {
-				t.Fatalf("got no response")
-			}
-			test.CNAMEOrder(res)
-			if err := test.SortAndCheck(res, tc); err != nil {
-				t.Error(err)
-			}
-			if t.Failed() {
-				t.Errorf("coredns log: %s", kubernetes.CorednsLogs())
-			}
-		})
-	}
+	kubernetes.DoIntegrationTests(t, deploymentDNSCases, "test-1")
 }
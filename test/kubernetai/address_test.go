@@ -1,7 +1,6 @@
 package kubernetai
 
 import (
-	"fmt"
 	"testing"
 
 	"github.com/coredns/ci/test/kubernetes"
@@ -73,25 +72,5 @@ func TestKubernetai(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Could not load corefile: %s", err)
 	}
-	testCases := dnsTestCases
-	namespace := "test-1"
-	err = kubernetes.StartClientPod(namespace)
-	if err != nil {
-		t.Fatalf("failed to start client pod: %s", err)
-	}
-	for _, tc := range testCases {
-		t.Run(fmt.Sprintf("%s %s", tc.Qname, dns.TypeToString[tc.Qtype]), func(t *testing.T) {
-			res, err := kubernetes.DoIntegrationTest(tc, namespace)
-			if err != nil {
-				t.Error(err.Error())
-			}
-			test.CNAMEOrder(res)
-			if err := test.SortAndCheck(res, tc); err != nil {
-				t.Error(err)
-			}
-			if t.Failed() {
-				t.Errorf("coredns log: %s", kubernetes.CorednsLogs())
-			}
-		})
-	}
+	kubernetes.DoIntegrationTests(t, dnsTestCases, "test-1")
 }
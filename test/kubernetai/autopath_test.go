@@ -120,6 +120,7 @@ internal.		IN	SOA	sns.internal. noc.internal. 2015082541 7200 3600 1209600 3600
 	}
 	testCases := autopathTests
 	namespace := "test-1"
+	kubernetes.CollectArtifacts(t, namespace)
 	err = kubernetes.StartClientPod(namespace)
 	if err != nil {
 		t.Fatalf("failed to start client pod: %s", err)
@@ -131,20 +132,7 @@ internal.		IN	SOA	sns.internal. noc.internal. 2015082541 7200 3600 1209600 3600
 
 	for _, tc := range testCases {
 		t.Run(fmt.Sprintf("%s %s", tc.Qname, dns.TypeToString[tc.Qtype]), func(t *testing.T) {
-			res, err := kubernetes.DoIntegrationTest(tc, namespace)
-			if err != nil {
-				t.Fatal(err.Error())
-			}
-			if res == nil {
-				t.Fatal("unexpected nil response")
-			}
-			test.CNAMEOrder(res)
-			if err := test.SortAndCheck(res, tc); err != nil {
-				t.Error(err)
-			}
-			if t.Failed() {
-				t.Errorf("coredns log: %s", kubernetes.CorednsLogs())
-			}
+			kubernetes.DoIntegrationTestCase(t, tc, namespace)
 		})
 	}
 }
@@ -185,12 +185,13 @@ cluster.local.		5	IN	SOA	ns.dns.cluster.local. hostmaster.cluster.local. 1726499
 				t.Fatalf("Could not load corefile: %s", err)
 			}
 			namespace := "test-1"
+			kubernetes.CollectArtifacts(t, namespace)
 			err = kubernetes.StartClientPod(namespace)
 			if err != nil {
 				t.Fatalf("failed to start client pod: %s", err)
 			}
 
-			res, err := kubernetes.DoIntegrationTest(tc.dig, namespace)
+			res, err := kubernetes.DoIntegrationTest(t, tc.dig, namespace)
 			if err != nil {
 				t.Error(err.Error())
 			}
@@ -1,9 +1,9 @@
 package kubernetes
 
 import (
-	"fmt"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/coredns/coredns/plugin/test"
 
@@ -81,20 +81,29 @@ var dnsTestCasesA = []test.Case{
 	},
 }
 
-var newObjectTests = []test.Case{
+// newObjectRetry allows for the time it takes CoreDNS to see newly created objects.
+var newObjectRetry = RetryPolicy{Transport: 2, Assertion: 5, Interval: time.Second}
+
+var newObjectTests = []Case{
 	{
-		Qname: "new-svc.test-1.svc.cluster.local.", Qtype: dns.TypeA,
-		Rcode: dns.RcodeSuccess,
-		Answer: []dns.RR{
-			test.A("new-svc.test-1.svc.cluster.local.      5    IN      A       10.96.0.222"),
+		Case: test.Case{
+			Qname: "new-svc.test-1.svc.cluster.local.", Qtype: dns.TypeA,
+			Rcode: dns.RcodeSuccess,
+			Answer: []dns.RR{
+				test.A("new-svc.test-1.svc.cluster.local.      5    IN      A       10.96.0.222"),
+			},
 		},
+		Retry: newObjectRetry,
 	},
 	{
-		Qname: "172-17-0-222.new-svc.test-1.svc.cluster.local.", Qtype: dns.TypeA,
-		Rcode: dns.RcodeSuccess,
-		Answer: []dns.RR{
-			test.A("172-17-0-222.new-svc.test-1.svc.cluster.local.      5    IN      A       172.17.0.222"),
+		Case: test.Case{
+			Qname: "172-17-0-222.new-svc.test-1.svc.cluster.local.", Qtype: dns.TypeA,
+			Rcode: dns.RcodeSuccess,
+			Answer: []dns.RR{
+				test.A("172-17-0-222.new-svc.test-1.svc.cluster.local.      5    IN      A       172.17.0.222"),
+			},
 		},
+		Retry: newObjectRetry,
 	},
 }
 
@@ -130,13 +139,16 @@ func TestKubernetesA(t *testing.T) {
 	defer upstream.Stop()
 	defer rmFunc()
 
+	// new objects are created in a namespace of their own, so they don't affect other tests
+	newNamespace := NewTestNamespace(t)
+
 	corefile := `    .:53 {
         health
         ready
         errors
         log
         kubernetes cluster.local 10.in-addr.arpa {
-			namespaces test-1
+			namespaces test-1 ` + newNamespace + `
 		}
 		forward . ` + udp + `
     }
@@ -146,31 +158,9 @@ func TestKubernetesA(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Could not load corefile: %s", err)
 	}
-	testCases := dnsTestCasesA
-	namespace := "test-1"
-	err = StartClientPod(namespace)
-	if err != nil {
-		t.Fatalf("failed to start client pod: %s", err)
-	}
-	for _, tc := range testCases {
-		t.Run(fmt.Sprintf("%s %s", tc.Qname, dns.TypeToString[tc.Qtype]), func(t *testing.T) {
-			res, err := DoIntegrationTest(tc, namespace)
-			if err != nil {
-				t.Error(err.Error())
-			}
-			if res != nil {
-				test.CNAMEOrder(res)
-				if err := test.SortAndCheck(res, tc); err != nil {
-					t.Error(err)
-				}
-			}
-			if t.Failed() {
-				t.Errorf("coredns log: %s", CorednsLogs())
-			}
-		})
-	}
+	DoIntegrationTests(t, dnsTestCasesA, "test-1")
 
-	newObjectsFile, rmFunc, err := test.TempFile(os.TempDir(), newObjects)
+	newObjectsFile, rmFunc, err := test.TempFile(os.TempDir(), Namespaced(newObjects, "test-1", newNamespace))
 	defer rmFunc()
 	if err != nil {
 		t.Fatalf("could not create file to add service/endpoint: %s", err)
@@ -181,24 +171,10 @@ func TestKubernetesA(t *testing.T) {
 		t.Fatalf("could not add service/endpoint via kubectl: %s", err)
 	}
 
-	for _, tc := range newObjectTests {
-		t.Run("New Object "+tc.Qname, func(t *testing.T) {
-			res, err := DoIntegrationTest(tc, namespace)
-			if err != nil {
-				t.Error(err.Error())
-			}
-			test.CNAMEOrder(res)
-			if err := test.SortAndCheck(res, tc); err != nil {
-				t.Error(err)
-			}
-			if t.Failed() {
-				t.Errorf("coredns log: %s", CorednsLogs())
-			}
+	for _, c := range newObjectTests {
+		c.Case = NamespacedCases([]test.Case{c.Case}, "test-1", newNamespace)[0]
+		t.Run("New Object "+c.Qname, func(t *testing.T) {
+			DoIntegrationCase(t, c, "test-1")
 		})
 	}
-
-	_, err = Kubectl("-n test-1 delete service new-svc")
-	if err != nil {
-		t.Fatalf("could not add service/endpoint via kubectl: %s", err)
-	}
 }
@@ -0,0 +1,187 @@
+package kubernetes
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+// Artifacts collects the queries made by a test, and on failure writes them together with the state of
+// the cluster to an artifact directory, so failures can be diagnosed after the cluster is torn down.
+//
+// The directory is created below $ARTIFACTS_DIR, or below the system temp directory if it is not set.
+type Artifacts struct {
+	t         *testing.T
+	namespace string
+	queries   []artifactQuery
+	mu        sync.Mutex
+}
+
+type artifactQuery struct {
+	when     time.Time
+	tc       test.Case
+	response *dns.Msg
+	err      error
+}
+
+var (
+	activeArtifacts   = map[string]*Artifacts{}
+	activeArtifactsMu sync.Mutex
+)
+
+// CollectArtifacts registers an artifact collector for t, which is written when t has failed at cleanup.
+// Queries made with DoIntegrationTest or DoIntegrationCase by t or its subtests while the collector is
+// registered are recorded automatically.
+// namespace is the test namespace whose objects are included in addition to kube-system.
+func CollectArtifacts(t *testing.T, namespace string) *Artifacts {
+	a := &Artifacts{t: t, namespace: namespace}
+
+	activeArtifactsMu.Lock()
+	activeArtifacts[t.Name()] = a
+	activeArtifactsMu.Unlock()
+
+	t.Cleanup(func() {
+		activeArtifactsMu.Lock()
+		if activeArtifacts[t.Name()] == a {
+			delete(activeArtifacts, t.Name())
+		}
+		activeArtifactsMu.Unlock()
+
+		if !t.Failed() {
+			return
+		}
+		dir, err := a.Write()
+		if err != nil {
+			t.Logf("could not write artifacts: %s", err)
+			return
+		}
+		t.Logf("artifacts written to %s", dir)
+	})
+	return a
+}
+
+// recordQuery records a query made by t in the artifact collector of t, or else of the closest test t is a
+// subtest of, so tests running in parallel only record their own queries.
+func recordQuery(t *testing.T, tc test.Case, response *dns.Msg, err error) {
+	activeArtifactsMu.Lock()
+	defer activeArtifactsMu.Unlock()
+	name := t.Name()
+	for {
+		if a, ok := activeArtifacts[name]; ok {
+			a.Query(tc, response, err)
+			return
+		}
+		i := strings.LastIndex(name, "/")
+		if i < 0 {
+			return
+		}
+		name = name[:i]
+	}
+}
+
+// Query records a query made by the test and its outcome.
+func (a *Artifacts) Query(tc test.Case, response *dns.Msg, err error) {
+	a.mu.Lock()
+	a.queries = append(a.queries, artifactQuery{when: time.Now(), tc: tc, response: response, err: err})
+	a.mu.Unlock()
+}
+
+// Write writes the artifact directory and returns its path.
+func (a *Artifacts) Write() (string, error) {
+	root := os.Getenv("ARTIFACTS_DIR")
+	if root == "" {
+		root = filepath.Join(os.TempDir(), "coredns-ci-artifacts")
+	}
+	dir := filepath.Join(root, artifactDirName(a.t.Name()))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	files := map[string]string{"queries.txt": a.queriesString()}
+
+	pods, _ := Kubectl("-n kube-system get pods -l " + CoreDNSLabel + " -o jsonpath='{.items[*].metadata.name}'")
+	for _, pod := range strings.Fields(pods) {
+		files["logs/"+pod+".log"] = kubectlOutput("-n kube-system logs " + pod)
+		files["logs/"+pod+".previous.log"] = kubectlOutput("-n kube-system logs --previous " + pod)
+	}
+
+	files["config/Corefile"] = kubectlOutput("-n kube-system get configmap coredns -o jsonpath='{.data.Corefile}'")
+	files["config/Zonefile"] = kubectlOutput("-n kube-system get configmap coredns -o jsonpath='{.data.Zonefile}'")
+
+	namespaces := []string{"kube-system"}
+	if a.namespace != "" && a.namespace != "kube-system" {
+		namespaces = append(namespaces, a.namespace)
+	}
+	for _, ns := range namespaces {
+		for _, kind := range []string{"pods", "deployments", "events"} {
+			files[ns+"/"+kind+".yaml"] = kubectlOutput("-n " + ns + " get " + kind + " -o yaml")
+		}
+	}
+
+	ips, err := CoreDNSPodIPs()
+	if err != nil {
+		files["metrics.txt"] = err.Error()
+	}
+	for _, ip := range ips {
+		mf, err := scrapePodMetrics(ip)
+		if err != nil {
+			files["metrics-"+ip+".txt"] = err.Error()
+			continue
+		}
+		files["metrics-"+ip+".txt"] = string(mf)
+	}
+
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return dir, err
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			return dir, err
+		}
+	}
+	return dir, nil
+}
+
+// queriesString returns the recorded queries with expected and actual responses in a readable form.
+func (a *Artifacts) queriesString() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var b strings.Builder
+	for i, q := range a.queries {
+		fmt.Fprintf(&b, "=== Query %d at %s: %s %s\n", i, q.when.Format(time.RFC3339Nano), q.tc.Qname, dns.TypeToString[q.tc.Qtype])
+		fmt.Fprintf(&b, "--- Expected\n%s\n", reportMessage(q.tc.Rcode, q.tc.Answer, q.tc.Ns, q.tc.Extra))
+		if q.err != nil {
+			fmt.Fprintf(&b, "--- Error\n%s\n", q.err)
+		}
+		if q.response != nil {
+			fmt.Fprintf(&b, "--- Response\n%s\n", q.response)
+		}
+	}
+	return b.String()
+}
+
+// kubectlOutput returns the output of a kubectl command, or the error if the command failed.
+func kubectlOutput(args string) string {
+	out, err := Kubectl(args)
+	if err != nil {
+		return err.Error()
+	}
+	return out
+}
+
+var unsafePathChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// artifactDirName returns a directory name for a test name, which may contain subtest separators and spaces.
+func artifactDirName(name string) string {
+	return unsafePathChars.ReplaceAllString(name, "_")
+}
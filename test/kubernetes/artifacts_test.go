@@ -0,0 +1,73 @@
+package kubernetes
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+func TestArtifactsQueries(t *testing.T) {
+	a := &Artifacts{t: t}
+	tc := test.Case{
+		Qname: "svc-1-a.test-1.svc.cluster.local.", Qtype: dns.TypeA,
+		Rcode: dns.RcodeSuccess,
+		Answer: []dns.RR{
+			test.A("svc-1-a.test-1.svc.cluster.local.      5    IN      A       10.96.0.100"),
+		},
+	}
+	res := tc.Msg()
+	res.Rcode = dns.RcodeNameError
+	a.Query(tc, res, nil)
+	a.Query(tc, nil, errors.New("failed to execute query"))
+
+	out := a.queriesString()
+	for _, want := range []string{
+		"=== Query 0 at ",
+		"=== Query 1 at ",
+		"rcode: NOERROR",
+		"svc-1-a.test-1.svc.cluster.local.\t5\tIN\tA\t10.96.0.100",
+		"status: NXDOMAIN",
+		"--- Error\nfailed to execute query",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected queries to contain %q, got:\n%s", want, out)
+		}
+	}
+}
+
+func TestArtifactDirName(t *testing.T) {
+	got := artifactDirName("TestKubernetesA/svc-1-a.test-1.svc.cluster.local. A")
+	want := "TestKubernetesA_svc-1-a.test-1.svc.cluster.local._A"
+	if got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestRecordQuery(t *testing.T) {
+	tc := test.Case{Qname: "svc-1-a.test-1.svc.cluster.local.", Qtype: dns.TypeA}
+	// a parallel test records its own queries and those of its subtests, but not those of the other one
+	want := []int{2, 1}
+	collectors := make([]*Artifacts, len(want))
+	t.Cleanup(func() {
+		for i, a := range collectors {
+			if got := len(a.queries); got != want[i] {
+				t.Errorf("expected %d queries recorded for test %d, got %d", want[i], i, got)
+			}
+		}
+	})
+	for i := range want {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			t.Parallel()
+			collectors[i] = CollectArtifacts(t, "")
+			recordQuery(t, tc, nil, nil)
+			if i == 0 {
+				t.Run("subtest", func(t *testing.T) { recordQuery(t, tc, nil, nil) })
+			}
+		})
+	}
+}
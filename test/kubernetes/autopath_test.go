@@ -1,7 +1,6 @@
 package kubernetes
 
 import (
-	"fmt"
 	"testing"
 
 	"github.com/coredns/coredns/plugin/test"
@@ -95,27 +94,5 @@ internal.		IN	SOA	sns.internal. noc.internal. 2015082541 7200 3600 1209600 3600
 	if err != nil {
 		t.Fatalf("Could not load corefile/zonefile: %s", err)
 	}
-	testCases := autopathTests
-	namespace := "test-1"
-	err = StartClientPod(namespace)
-	if err != nil {
-		t.Fatalf("failed to start client pod: %s", err)
-	}
-	for _, tc := range testCases {
-		t.Run(fmt.Sprintf("%s %s", tc.Qname, dns.TypeToString[tc.Qtype]), func(t *testing.T) {
-			res, err := DoIntegrationTest(tc, namespace)
-			if err != nil {
-				t.Error(err.Error())
-			}
-			if res != nil {
-				test.CNAMEOrder(res)
-				if err := test.SortAndCheck(res, tc); err != nil {
-					t.Error(err)
-				}
-			}
-			if t.Failed() {
-				t.Errorf("coredns log: %s", CorednsLogs())
-			}
-		})
-	}
+	DoIntegrationTests(t, autopathTests, "test-1")
 }
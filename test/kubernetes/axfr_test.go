@@ -84,13 +84,14 @@ _c-port._udp.headless-svc.test-4.svc.cluster.local. 5 IN SRV 0 50 1234 headless-
 		t.Fatalf("Could not load corefile: %s", err)
 	}
 	namespace := "test-1"
+	CollectArtifacts(t, namespace)
 	err = StartClientPod(namespace)
 	if err != nil {
 		t.Fatalf("failed to start client pod: %s", err)
 	}
 	for _, tc := range testCases {
 		t.Run(fmt.Sprintf("%s %s", tc.Qname, dns.TypeToString[tc.Qtype]), func(t *testing.T) {
-			res, err := DoIntegrationTest(tc, namespace)
+			res, err := DoIntegrationTest(t, tc, namespace)
 			if err != nil {
 				t.Error(err.Error())
 			}
@@ -153,13 +154,14 @@ cluster.local.		5	IN	SOA	ns.dns.cluster.local. hostmaster.cluster.local. 1726233
 		t.Fatalf("Could not load corefile: %s", err)
 	}
 	namespace := "test-1"
+	CollectArtifacts(t, namespace)
 	err = StartClientPod(namespace)
 	if err != nil {
 		t.Fatalf("failed to start client pod: %s", err)
 	}
 	for _, tc := range testCases {
 		t.Run(fmt.Sprintf("%s %s", tc.Qname, dns.TypeToString[tc.Qtype]), func(t *testing.T) {
-			res, err := DoIntegrationTest(tc, namespace)
+			res, err := DoIntegrationTest(t, tc, namespace)
 			if err != nil {
 				t.Error(err.Error())
 			}
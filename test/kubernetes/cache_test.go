@@ -0,0 +1,274 @@
+package kubernetes
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/coredns/ci/test/kubernetes/fakeapi"
+	"github.com/coredns/ci/test/kubernetes/fixture"
+
+	"github.com/miekg/dns"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// cacheServices are the services the cache cases query. Each subtest queries a service of its own, as some
+// delete theirs.
+var cacheServices = []fixture.Service{
+	{Name: "countdown", ClusterIPs: []string{"10.96.0.44"}, Ports: []fixture.Port{{Name: "http", Port: 80}}},
+	{Name: "deleted", ClusterIPs: []string{"10.96.0.45"}, Ports: []fixture.Port{{Name: "http", Port: 80}}},
+	{Name: "recreated", ClusterIPs: []string{"10.96.0.46"}, Ports: []fixture.Port{{Name: "http", Port: 80}}},
+	{Name: "prefetched", ClusterIPs: []string{"10.96.0.47"}, Ports: []fixture.Port{{Name: "http", Port: 80}}},
+	{Name: "disabled", ClusterIPs: []string{"10.96.0.48"}, Ports: []fixture.Port{{Name: "http", Port: 80}}},
+}
+
+// cacheDeltas are the changes of the cache metrics of the server block of port 53 during a subtest.
+type cacheDeltas struct {
+	success, denial, misses, prefetch, stale float64
+}
+
+func cacheDeltasSince(t *testing.T, before Metrics) cacheDeltas {
+	after := ScrapeMetricFamilies(t)
+	server := map[string]string{"server": "dns://:53"}
+	hits := func(typ string) map[string]string { return map[string]string{"server": "dns://:53", "type": typ} }
+	return cacheDeltas{
+		success:  after.Delta(before, "coredns_cache_hits_total", hits("success")),
+		denial:   after.Delta(before, "coredns_cache_hits_total", hits("denial")),
+		misses:   after.Delta(before, "coredns_cache_misses_total", server),
+		prefetch: after.Delta(before, "coredns_cache_prefetch_total", server),
+		stale:    after.Delta(before, "coredns_cache_served_stale_total", server),
+	}
+}
+
+// loadCacheCorefile loads CoreDNS with the kubernetes plugin for namespace, with options, and the cache
+// plugin with args, and waits until it answers on udp. CoreDNS is restarted, so the cache is empty and
+// the metrics start from zero.
+func loadCacheCorefile(t *testing.T, udp, namespace, options, args string) {
+	corefile := `    .:53 {
+        health
+        ready
+        errors
+        log
+        prometheus :9153
+        kubernetes cluster.local {
+            namespaces ` + namespace + options + `
+        }
+        cache ` + args + `
+    }
+`
+	if err := LoadCorefile(corefile); err != nil {
+		t.Fatalf("Could not load corefile: %s", err)
+	}
+	if err := WaitForServer(udp, nil, exposeTimeout); err != nil {
+		t.Fatalf("coredns not reachable on node port %s: %s", udp, err)
+	}
+}
+
+// cacheQuery queries CoreDNS on udp for qname, and checks that the answer is the A record ip, or NXDOMAIN if
+// ip is empty, with a TTL between min and max. The TTL of NXDOMAIN is that of the SOA record.
+func cacheQuery(t *testing.T, udp, qname, ip string, min, max uint32) {
+	t.Helper()
+	m := new(dns.Msg)
+	m.SetQuestion(qname, dns.TypeA)
+	r, err := Exchange(m, udp, nil)
+	if err != nil {
+		t.Fatalf("could not query %s: %s", qname, err)
+	}
+
+	var ttl uint32
+	switch {
+	case ip == "":
+		if r.Rcode != dns.RcodeNameError || len(r.Ns) != 1 {
+			t.Fatalf("expected NXDOMAIN with a SOA record for %s, got %s", qname, r)
+		}
+		ttl = r.Ns[0].Header().Ttl
+	default:
+		if r.Rcode != dns.RcodeSuccess || len(r.Answer) != 1 {
+			t.Fatalf("expected an A record for %s, got %s", qname, r)
+		}
+		a, ok := r.Answer[0].(*dns.A)
+		if !ok || a.A.String() != ip {
+			t.Fatalf("expected %s A %s, got %s", qname, ip, r.Answer[0])
+		}
+		ttl = a.Hdr.Ttl
+	}
+	if ttl < min || ttl > max {
+		t.Errorf("expected the TTL of %s to be between %d and %d, got %d", qname, min, max, ttl)
+	}
+}
+
+// sleepUntil sleeps until d has passed since start.
+func sleepUntil(start time.Time, d time.Duration) {
+	time.Sleep(time.Until(start.Add(d)))
+}
+
+// TestKubernetesCache checks how the cache plugin in front of the kubernetes plugin delays the changes of
+// services: cached answers count down their TTL and outlive the deletion of their service, NXDOMAIN is cached
+// for the denial TTL after a service is created again, popular answers are prefetched, and expired answers
+// are served stale, and refreshed from the objects CoreDNS had before the API server became unavailable. The
+// hits and misses are checked in the metrics of the cache plugin.
+func TestKubernetesCache(t *testing.T) {
+	namespace := NewTestNamespace(t)
+	ApplyFixtures(t, &fixture.Set{Namespaces: []fixture.Namespace{{Name: namespace, Services: cacheServices}}})
+	client, err := NewClient()
+	if err != nil {
+		t.Fatalf("could not create kubernetes client: %s", err)
+	}
+	udp, _ := ExposeCoreDNS(t)
+	name := func(svc string) string { return svc + "." + namespace + ".svc.cluster.local." }
+
+	t.Run("ttl countdown", func(t *testing.T) {
+		loadCacheCorefile(t, udp, namespace, "\n            ttl 30", "30")
+		before := ScrapeMetricFamilies(t)
+
+		start := time.Now()
+		cacheQuery(t, udp, name("countdown"), "10.96.0.44", 30, 30)
+		sleepUntil(start, 3*time.Second)
+		cacheQuery(t, udp, name("countdown"), "10.96.0.44", 25, 27)
+		sleepUntil(start, 6*time.Second)
+		cacheQuery(t, udp, name("countdown"), "10.96.0.44", 22, 24)
+
+		if got, want := cacheDeltasSince(t, before), (cacheDeltas{success: 2, misses: 1}); got != want {
+			t.Errorf("expected cache metrics to change by %+v, got %+v", want, got)
+		}
+	})
+
+	t.Run("deleted service", func(t *testing.T) {
+		loadCacheCorefile(t, udp, namespace, "\n            ttl 10", "30")
+		before := ScrapeMetricFamilies(t)
+
+		start := time.Now()
+		cacheQuery(t, udp, name("deleted"), "10.96.0.45", 10, 10)
+		if err := client.CoreV1().Services(namespace).Delete(context.TODO(), "deleted", meta.DeleteOptions{}); err != nil {
+			t.Fatalf("could not delete service: %s", err)
+		}
+		// the kubernetes plugin has seen the deletion, but the answer is cached until its TTL expires
+		sleepUntil(start, 3*time.Second)
+		cacheQuery(t, udp, name("deleted"), "10.96.0.45", 6, 7)
+		sleepUntil(start, 11*time.Second)
+		cacheQuery(t, udp, name("deleted"), "", 10, 10)
+
+		if got, want := cacheDeltasSince(t, before), (cacheDeltas{success: 1, misses: 2}); got != want {
+			t.Errorf("expected cache metrics to change by %+v, got %+v", want, got)
+		}
+	})
+
+	t.Run("negative cache", func(t *testing.T) {
+		// NXDOMAIN is cached for 20 seconds, the SOA minimum of the kubernetes plugin is its TTL of 30
+		loadCacheCorefile(t, udp, namespace, "\n            ttl 30", "30 {\n            denial 9984 20\n        }")
+		if err := client.CoreV1().Services(namespace).Delete(context.TODO(), "recreated", meta.DeleteOptions{}); err != nil {
+			t.Fatalf("could not delete service: %s", err)
+		}
+		time.Sleep(2 * time.Second)
+		before := ScrapeMetricFamilies(t)
+
+		start := time.Now()
+		cacheQuery(t, udp, name("recreated"), "", 20, 20)
+		// the service is created again, with another ClusterIP, as that of the deleted one may not be free yet
+		ApplyFixtures(t, &fixture.Set{Namespaces: []fixture.Namespace{{Name: namespace, Services: []fixture.Service{
+			{Name: "recreated", ClusterIPs: []string{"10.96.0.49"}, Ports: []fixture.Port{{Name: "http", Port: 80}}},
+		}}}})
+		time.Sleep(2 * time.Second)
+		if elapsed := time.Since(start); elapsed > 15*time.Second {
+			t.Fatalf("recreating the service took %s, longer than the denial TTL allows to check", elapsed)
+		}
+		cacheQuery(t, udp, name("recreated"), "", 1, 18)
+		sleepUntil(start, 21*time.Second)
+		cacheQuery(t, udp, name("recreated"), "10.96.0.49", 30, 30)
+
+		if got, want := cacheDeltasSince(t, before), (cacheDeltas{denial: 1, misses: 2}); got != want {
+			t.Errorf("expected cache metrics to change by %+v, got %+v", want, got)
+		}
+	})
+
+	t.Run("prefetch", func(t *testing.T) {
+		// an answer queried twice is prefetched once half of its TTL of 10 seconds has passed
+		loadCacheCorefile(t, udp, namespace, "\n            ttl 10", "30 {\n            prefetch 2 1m 50%\n        }")
+		before := ScrapeMetricFamilies(t)
+
+		start := time.Now()
+		for i := 0; i < 3; i++ {
+			cacheQuery(t, udp, name("prefetched"), "10.96.0.47", 9, 10)
+		}
+		sleepUntil(start, 6*time.Second)
+		cacheQuery(t, udp, name("prefetched"), "10.96.0.47", 3, 4)
+		// after the original answer expired, the prefetched one is still cached
+		sleepUntil(start, 11*time.Second)
+		cacheQuery(t, udp, name("prefetched"), "10.96.0.47", 4, 6)
+
+		got := cacheDeltasSince(t, before)
+		if got.misses != 1 || got.success != 4 || got.prefetch < 1 {
+			t.Errorf("expected 1 miss, 4 hits and a prefetch, got %+v", got)
+		}
+	})
+
+	for _, tc := range []struct {
+		name string
+		args string
+		want cacheDeltas
+	}{
+		{"disable success", "30 {\n            disable success cluster.local\n        }", cacheDeltas{denial: 1, misses: 3}},
+		{"disable denial", "30 {\n            disable denial\n        }", cacheDeltas{success: 1, misses: 3}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			loadCacheCorefile(t, udp, namespace, "", tc.args)
+			before := ScrapeMetricFamilies(t)
+
+			for i := 0; i < 2; i++ {
+				cacheQuery(t, udp, name("disabled"), "10.96.0.48", 4, 5)
+				cacheQuery(t, udp, name("none"), "", 4, 5)
+			}
+
+			if got := cacheDeltasSince(t, before); got != tc.want {
+				t.Errorf("expected cache metrics to change by %+v, got %+v", tc.want, got)
+			}
+		})
+	}
+
+	t.Run("serve_stale", func(t *testing.T) {
+		fakeClient := fake.NewSimpleClientset()
+		set := &fixture.Set{Namespaces: []fixture.Namespace{{Name: namespace, Services: []fixture.Service{
+			{Name: "stale", ClusterIPs: []string{"10.96.0.50"}, Ports: []fixture.Port{{Name: "http", Port: 80}}},
+		}}}}
+		if err := set.Apply(context.Background(), fakeClient); err != nil {
+			t.Fatalf("could not create fake fixtures: %s", err)
+		}
+		server, err := fakeapi.Listen(fakeClient, net.JoinHostPort(locaIP().String(), "0"))
+		if err != nil {
+			t.Fatalf("could not start fake API server: %s", err)
+		}
+		defer server.Close()
+
+		loadCacheCorefile(t, udp, namespace, "\n            endpoint "+server.URL(), "30 {\n            serve_stale 1h\n        }")
+		before := ScrapeMetricFamilies(t)
+
+		start := time.Now()
+		cacheQuery(t, udp, name("stale"), "10.96.0.50", 5, 5)
+		server.Close()
+		// the API changes while CoreDNS cannot reach it: the cached service is deleted, and another one created,
+		// which CoreDNS does not know of
+		if err := fakeClient.CoreV1().Services(namespace).Delete(context.Background(), "stale", meta.DeleteOptions{}); err != nil {
+			t.Fatalf("could not delete fake service: %s", err)
+		}
+		created := &fixture.Set{Namespaces: []fixture.Namespace{{Name: namespace, Services: []fixture.Service{
+			{Name: "created", ClusterIPs: []string{"10.96.0.51"}, Ports: []fixture.Port{{Name: "http", Port: 80}}},
+		}}}}
+		if err := created.Apply(context.Background(), fakeClient); err != nil {
+			t.Fatalf("could not create fake service: %s", err)
+		}
+		cacheQuery(t, udp, name("created"), "", 4, 5)
+
+		// the expired answer is served with a TTL of 0, and refreshed in the background like a prefetch, from the
+		// objects the kubernetes plugin had before the outage, so the deleted service is still answered
+		sleepUntil(start, 6*time.Second)
+		cacheQuery(t, udp, name("stale"), "10.96.0.50", 0, 0)
+		time.Sleep(time.Second)
+		cacheQuery(t, udp, name("stale"), "10.96.0.50", 3, 5)
+
+		if got, want := cacheDeltasSince(t, before), (cacheDeltas{success: 2, misses: 2, prefetch: 1, stale: 1}); got != want {
+			t.Errorf("expected cache metrics to change by %+v, got %+v", want, got)
+		}
+	})
+}
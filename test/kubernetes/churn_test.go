@@ -0,0 +1,391 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	apps "k8s.io/api/apps/v1"
+	autoscaling "k8s.io/api/autoscaling/v1"
+	api "k8s.io/api/core/v1"
+	discovery "k8s.io/api/discovery/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// churnDuration is how long objects are churned, unless set with $CHURN_DURATION.
+	churnDuration = time.Minute
+	// churnNamespaces is the number of namespaces objects are churned in.
+	churnNamespaces = 2
+	// churnServices is the maximum number of churned services per namespace.
+	churnServices = 10
+	// churnMaxLag is how long an answer may lag behind the state of the API.
+	churnMaxLag = 5 * time.Second
+	// churnServfailBurst is the number of consecutive SERVFAIL responses that fails the test.
+	churnServfailBurst = 3
+	// churnErrorBurst is the number of consecutive failed queries that fails the test. A single failed query,
+	// such as a dropped UDP packet, does not.
+	churnErrorBurst = 3
+	// churnMaxMemoryGrowth is how much the resident memory of CoreDNS may grow while churning.
+	churnMaxMemoryGrowth = 64 << 20
+)
+
+// TestKubernetesChurn creates, scales, updates and deletes Services, Pods and EndpointSlices while validating
+// the answers of CoreDNS against the state of the API. Answers may only lag behind the API by churnMaxLag.
+func TestKubernetesChurn(t *testing.T) {
+	duration := churnDuration
+	if d := os.Getenv("CHURN_DURATION"); d != "" {
+		var err error
+		if duration, err = time.ParseDuration(d); err != nil {
+			t.Fatalf("could not parse $CHURN_DURATION: %s", err)
+		}
+	}
+
+	corefile := `    .:53 {
+        health
+        ready
+        errors
+        prometheus :9153
+        kubernetes cluster.local
+    }
+`
+	err := LoadCorefile(corefile)
+	if err != nil {
+		t.Fatalf("Could not load corefile: %s", err)
+	}
+	client, err := NewClient()
+	if err != nil {
+		t.Fatalf("could not create kubernetes client: %s", err)
+	}
+	var namespaces []string
+	for range churnNamespaces {
+		namespaces = append(namespaces, NewTestNamespace(t))
+	}
+	server, _ := ExposeCoreDNS(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), duration)
+	defer cancel()
+	state := watchChurnState(t, ctx, client, namespaces)
+	memory := residentMemory(t)
+
+	var wg sync.WaitGroup
+	for i, ns := range namespaces {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			churnServiceObjects(ctx, t, client, ns, int64(i))
+		}()
+		go func() {
+			defer wg.Done()
+			churnPods(ctx, t, client, ns, int64(i))
+		}()
+	}
+	validateChurn(ctx, t, server, state)
+	wg.Wait()
+
+	if grown := residentMemory(t) - memory; grown > churnMaxMemoryGrowth {
+		t.Errorf("resident memory of coredns grew by %d bytes, more than %d", grown, churnMaxMemoryGrowth)
+	}
+	restarted, err := HasResourceRestarted(CoreDNSLabel)
+	if err != nil {
+		t.Errorf("could not get restart count of coredns: %s", err)
+	}
+	if restarted {
+		t.Error("coredns restarted while churning")
+	}
+}
+
+// churnServiceObjects creates, updates and deletes headless and ClusterIP services with EndpointSlices in namespace
+// until ctx is done.
+func churnServiceObjects(ctx context.Context, t *testing.T, client kubernetes.Interface, namespace string, seed int64) {
+	rnd := rand.New(rand.NewSource(seed))
+	live := map[string]bool{}
+	for ctx.Err() == nil {
+		name := fmt.Sprintf("svc-%d", rnd.Intn(churnServices))
+		var err error
+		switch {
+		case !live[name]:
+			clusterIP := ""
+			if rnd.Intn(2) == 0 {
+				clusterIP = api.ClusterIPNone
+			}
+			err = createChurnService(ctx, client, namespace, name, clusterIP, churnEndpoints(rnd))
+			live[name] = true
+		case rnd.Intn(2) == 0:
+			// updates of the slices of ClusterIP services don't change their answers, but are churn all the same
+			var es *discovery.EndpointSlice
+			es, err = client.DiscoveryV1().EndpointSlices(namespace).Get(ctx, name, meta.GetOptions{})
+			if err == nil {
+				es.Endpoints = churnEndpoints(rnd)
+				_, err = client.DiscoveryV1().EndpointSlices(namespace).Update(ctx, es, meta.UpdateOptions{})
+			}
+		default:
+			err = client.CoreV1().Services(namespace).Delete(ctx, name, meta.DeleteOptions{})
+			if err == nil {
+				err = client.DiscoveryV1().EndpointSlices(namespace).Delete(ctx, name, meta.DeleteOptions{})
+			}
+			live[name] = false
+		}
+		if err != nil && ctx.Err() == nil {
+			t.Errorf("could not churn service %s/%s: %s", namespace, name, err)
+			return
+		}
+		time.Sleep(time.Duration(rnd.Intn(200)) * time.Millisecond)
+	}
+}
+
+// createChurnService creates a service and an EndpointSlice with endpoints for it.
+func createChurnService(ctx context.Context, client kubernetes.Interface, namespace, name, clusterIP string, endpoints []discovery.Endpoint) error {
+	_, err := client.CoreV1().Services(namespace).Create(ctx, &api.Service{
+		ObjectMeta: meta.ObjectMeta{Namespace: namespace, Name: name},
+		Spec:       api.ServiceSpec{ClusterIP: clusterIP, Ports: []api.ServicePort{{Name: "http", Port: 80}}},
+	}, meta.CreateOptions{})
+	if err != nil {
+		return err
+	}
+	_, err = client.DiscoveryV1().EndpointSlices(namespace).Create(ctx, &discovery.EndpointSlice{
+		ObjectMeta: meta.ObjectMeta{
+			Namespace: namespace, Name: name,
+			Labels: map[string]string{discovery.LabelServiceName: name},
+		},
+		AddressType: discovery.AddressTypeIPv4,
+		Endpoints:   endpoints,
+	}, meta.CreateOptions{})
+	return err
+}
+
+// churnEndpoints returns up to 3 endpoints, some of which may not be ready.
+func churnEndpoints(rnd *rand.Rand) []discovery.Endpoint {
+	var endpoints []discovery.Endpoint
+	for i := range rnd.Intn(4) {
+		ready := rnd.Intn(4) != 0
+		endpoints = append(endpoints, discovery.Endpoint{
+			Addresses:  []string{fmt.Sprintf("192.0.2.%d", 10*i+rnd.Intn(10))},
+			Conditions: discovery.EndpointConditions{Ready: &ready},
+		})
+	}
+	return endpoints
+}
+
+// churnPods scales a deployment behind a headless service in namespace up and down until ctx is done.
+func churnPods(ctx context.Context, t *testing.T, client kubernetes.Interface, namespace string, seed int64) {
+	rnd := rand.New(rand.NewSource(seed))
+	labels := map[string]string{"app": "churn"}
+	replicas := int32(1)
+	_, err := client.CoreV1().Services(namespace).Create(ctx, &api.Service{
+		ObjectMeta: meta.ObjectMeta{Namespace: namespace, Name: "churn-pods"},
+		Spec: api.ServiceSpec{
+			ClusterIP: api.ClusterIPNone, Selector: labels,
+			Ports: []api.ServicePort{{Name: "http", Port: 80}},
+		},
+	}, meta.CreateOptions{})
+	if err == nil {
+		_, err = client.AppsV1().Deployments(namespace).Create(ctx, &apps.Deployment{
+			ObjectMeta: meta.ObjectMeta{Namespace: namespace, Name: "churn-pods"},
+			Spec: apps.DeploymentSpec{
+				Replicas: &replicas,
+				Selector: &meta.LabelSelector{MatchLabels: labels},
+				Template: api.PodTemplateSpec{
+					ObjectMeta: meta.ObjectMeta{Labels: labels},
+					Spec: api.PodSpec{
+						TerminationGracePeriodSeconds: new(int64),
+						Containers:                    []api.Container{{Name: "pause", Image: "registry.k8s.io/pause:3.9"}},
+					},
+				},
+			},
+		}, meta.CreateOptions{})
+	}
+	for err == nil && ctx.Err() == nil {
+		var scale *autoscaling.Scale
+		scale, err = client.AppsV1().Deployments(namespace).GetScale(ctx, "churn-pods", meta.GetOptions{})
+		if err == nil {
+			scale.Spec.Replicas = int32(rnd.Intn(4))
+			_, err = client.AppsV1().Deployments(namespace).UpdateScale(ctx, "churn-pods", scale, meta.UpdateOptions{})
+		}
+		time.Sleep(time.Duration(2+rnd.Intn(3)) * time.Second)
+	}
+	if err != nil && ctx.Err() == nil {
+		t.Errorf("could not churn pods in %s: %s", namespace, err)
+	}
+}
+
+// churnState is the state of the services and EndpointSlices in the churned namespaces, as seen by an informer.
+type churnState struct {
+	sync.Mutex
+	services map[string]*api.Service             // by namespace/name
+	slices   map[string]*discovery.EndpointSlice // by namespace/name
+	changed  map[string]time.Time                // last change of the objects of a service, by namespace/name
+}
+
+// watchChurnState starts informers that maintain the state of the services and EndpointSlices in namespaces
+// until ctx is done.
+func watchChurnState(t *testing.T, ctx context.Context, client kubernetes.Interface, namespaces []string) *churnState {
+	s := &churnState{
+		services: map[string]*api.Service{},
+		slices:   map[string]*discovery.EndpointSlice{},
+		changed:  map[string]time.Time{},
+	}
+	handler := func(update func(obj meta.Object, deleted bool)) cache.ResourceEventHandler {
+		on := func(obj interface{}, deleted bool) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			o, ok := obj.(meta.Object)
+			if !ok || !slices.Contains(namespaces, o.GetNamespace()) {
+				return
+			}
+			s.Lock()
+			defer s.Unlock()
+			update(o, deleted)
+		}
+		return cache.ResourceEventHandlerFuncs{
+			AddFunc:    func(obj interface{}) { on(obj, false) },
+			UpdateFunc: func(_, obj interface{}) { on(obj, false) },
+			DeleteFunc: func(obj interface{}) { on(obj, true) },
+		}
+	}
+
+	factory := informers.NewSharedInformerFactory(client, 0)
+	factory.Core().V1().Services().Informer().AddEventHandler(handler(func(obj meta.Object, deleted bool) {
+		key := obj.GetNamespace() + "/" + obj.GetName()
+		if deleted {
+			delete(s.services, key)
+		} else {
+			s.services[key] = obj.(*api.Service)
+		}
+		s.changed[key] = time.Now()
+	}))
+	factory.Discovery().V1().EndpointSlices().Informer().AddEventHandler(handler(func(obj meta.Object, deleted bool) {
+		key := obj.GetNamespace() + "/" + obj.GetName()
+		if deleted {
+			delete(s.slices, key)
+		} else {
+			s.slices[key] = obj.(*discovery.EndpointSlice)
+		}
+		s.changed[obj.GetNamespace()+"/"+obj.GetLabels()[discovery.LabelServiceName]] = time.Now()
+	}))
+	factory.Start(ctx.Done())
+	for typ, ok := range factory.WaitForCacheSync(ctx.Done()) {
+		if !ok {
+			t.Fatalf("could not sync informer for %v", typ)
+		}
+	}
+	return s
+}
+
+// expected returns the names of the services and the rcode and A records CoreDNS is expected to answer for them.
+func (s *churnState) expected() map[string]churnAnswer {
+	s.Lock()
+	defer s.Unlock()
+	answers := map[string]churnAnswer{}
+	for key, changed := range s.changed {
+		ns, name, _ := strings.Cut(key, "/")
+		a := churnAnswer{rcode: dns.RcodeNameError, changed: changed}
+		if svc, ok := s.services[key]; ok {
+			if svc.Spec.ClusterIP != api.ClusterIPNone {
+				a.rcode, a.ips = dns.RcodeSuccess, []string{svc.Spec.ClusterIP}
+			} else {
+				for _, es := range s.slices {
+					if es.Namespace != ns || es.Labels[discovery.LabelServiceName] != name {
+						continue
+					}
+					for _, ep := range es.Endpoints {
+						if ep.Conditions.Ready == nil || *ep.Conditions.Ready {
+							a.ips = append(a.ips, ep.Addresses...)
+						}
+					}
+				}
+				if len(a.ips) > 0 {
+					a.rcode = dns.RcodeSuccess
+				}
+			}
+		}
+		slices.Sort(a.ips)
+		a.ips = slices.Compact(a.ips)
+		answers[name+"."+ns+".svc.cluster.local."] = a
+	}
+	return answers
+}
+
+// changedSince returns the last change of the objects of the service of qname.
+func (s *churnState) changedSince(qname string) time.Time {
+	labels := dns.SplitDomainName(qname)
+	s.Lock()
+	defer s.Unlock()
+	return s.changed[labels[1]+"/"+labels[0]]
+}
+
+type churnAnswer struct {
+	rcode   int
+	ips     []string
+	changed time.Time
+}
+
+// validateChurn queries the services of state until ctx is done, and fails t for answers that lag behind the
+// state by more than churnMaxLag, and for bursts of SERVFAIL responses or failed queries.
+func validateChurn(ctx context.Context, t *testing.T, server string, state *churnState) {
+	c := &dns.Client{Timeout: time.Second}
+	servfails, errs, failed, stale, queries := 0, 0, 0, 0, 0
+	for ctx.Err() == nil {
+		for qname, want := range state.expected() {
+			m := new(dns.Msg)
+			m.SetQuestion(qname, dns.TypeA)
+			res, _, err := c.Exchange(m, server)
+			queries++
+			if err != nil {
+				errs++
+				failed++
+				if errs == churnErrorBurst {
+					t.Errorf("%d consecutive failed queries, the last for %s: %s", errs, qname, err)
+				}
+				continue
+			}
+			errs = 0
+			if res.Rcode == dns.RcodeServerFailure {
+				servfails++
+				if servfails == churnServfailBurst {
+					t.Errorf("%d consecutive SERVFAIL responses, the last for %s", servfails, qname)
+				}
+				continue
+			}
+			servfails = 0
+
+			var ips []string
+			for _, rr := range res.Answer {
+				if a, ok := rr.(*dns.A); ok {
+					ips = append(ips, a.A.String())
+				}
+			}
+			slices.Sort(ips)
+			if res.Rcode == want.rcode && slices.Equal(slices.Compact(ips), want.ips) {
+				continue
+			}
+			// the answer may be for a change made after the state was read
+			if lag := time.Since(state.changedSince(qname)); lag > churnMaxLag {
+				stale++
+				t.Errorf("stale answer for %s after %s: got %s %v, want %s %v", qname, lag.Round(time.Millisecond),
+					dns.RcodeToString[res.Rcode], ips, dns.RcodeToString[want.rcode], want.ips)
+			}
+		}
+	}
+	t.Logf("validated %d answers, %d stale, %d queries failed", queries, stale, failed)
+}
+
+// residentMemory returns the resident memory of coredns in bytes, as reported in its metrics.
+func residentMemory(t *testing.T) int64 {
+	mf, ok := ScrapeMetricFamilies(t)["process_resident_memory_bytes"]
+	if !ok || len(mf.Metric) == 0 {
+		t.Fatalf("did not find process_resident_memory_bytes in scraped metrics")
+	}
+	return int64(mf.Metric[0].GetGauge().GetValue())
+}
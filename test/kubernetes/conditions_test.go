@@ -0,0 +1,255 @@
+package kubernetes
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/coredns/ci/test/kubernetes/fixture"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+	api "k8s.io/api/core/v1"
+)
+
+// conditionsNamespace is the namespace the endpoint condition cases are written for, it is replaced by a
+// namespace created for the test.
+const conditionsNamespace = "test-cond"
+
+// conditionsServices are headless services with EndpointSlices crafted with all combinations of conditions
+// the endpointslice controller sets. Only ready endpoints are published, whether they are terminating,
+// serving or hinted to another zone does not matter.
+var conditionsServices = []fixture.Service{
+	{
+		Name:  "conditions",
+		Ports: []fixture.Port{{Name: "http", Port: 80}},
+		Endpoints: []fixture.Endpoint{
+			{IP: "172.17.7.10", Hostname: "ready-1", Zone: "zone-a", ZoneHint: "zone-a"},
+			{IP: "172.17.7.11", Hostname: "ready-2", Zone: "zone-b", ZoneHint: "zone-b"},
+			{IP: "172.17.7.12", Hostname: "not-ready", NotReady: true},
+			{IP: "172.17.7.13", Hostname: "terminating-serving", NotReady: true, Terminating: true, Serving: true},
+			{IP: "172.17.7.14", Hostname: "terminating", NotReady: true, Terminating: true},
+			// terminating endpoints of services that publish addresses that are not ready remain ready
+			{IP: "172.17.7.15", Hostname: "terminating-published", Terminating: true, Serving: true},
+		},
+	},
+	{
+		Name:  "rolling",
+		Ports: []fixture.Port{{Name: "http", Port: 80}},
+		Endpoints: []fixture.Endpoint{
+			{IP: "172.17.7.20", Hostname: "pod-1"},
+			{IP: "172.17.7.21", Hostname: "pod-2"},
+		},
+	},
+}
+
+// unreadyServices extend svc-unready of the static fixtures, a headless service selecting a pod that never
+// becomes ready, with a service that publishes it anyway. Their cases are derived, as the IP of the pod
+// is only known once it has been scheduled.
+var unreadyServices = fixture.Namespace{
+	Services: []fixture.Service{
+		{Name: "svc-unready", Selector: map[string]string{"app": "app-unready"},
+			Ports: []fixture.Port{{Name: "c-port", Port: 1234, Protocol: api.ProtocolUDP}}},
+		{Name: "svc-unready-published", Selector: map[string]string{"app": "app-unready"}, PublishNotReadyAddresses: true,
+			Ports: []fixture.Port{{Name: "c-port", Port: 1234, Protocol: api.ProtocolUDP}}},
+	},
+	Pods: []fixture.Pod{{Name: "unready", Labels: map[string]string{"app": "app-unready"}, NotReady: true}},
+}
+
+var dnsTestCasesConditions = []test.Case{
+	{ // Only the ready endpoints are published, in any zone
+		Qname: "conditions.test-cond.svc.cluster.local.", Qtype: dns.TypeA,
+		Rcode: dns.RcodeSuccess,
+		Answer: []dns.RR{
+			test.A("conditions.test-cond.svc.cluster.local. 303 IN A 172.17.7.10"),
+			test.A("conditions.test-cond.svc.cluster.local. 303 IN A 172.17.7.11"),
+			test.A("conditions.test-cond.svc.cluster.local. 303 IN A 172.17.7.15"),
+		},
+	},
+	{
+		Qname: "_http._tcp.conditions.test-cond.svc.cluster.local.", Qtype: dns.TypeSRV,
+		Rcode: dns.RcodeSuccess,
+		Answer: []dns.RR{
+			test.SRV("_http._tcp.conditions.test-cond.svc.cluster.local. 303 IN SRV 0 33 80 ready-1.conditions.test-cond.svc.cluster.local."),
+			test.SRV("_http._tcp.conditions.test-cond.svc.cluster.local. 303 IN SRV 0 33 80 ready-2.conditions.test-cond.svc.cluster.local."),
+			test.SRV("_http._tcp.conditions.test-cond.svc.cluster.local. 303 IN SRV 0 33 80 terminating-published.conditions.test-cond.svc.cluster.local."),
+		},
+		Extra: []dns.RR{
+			test.A("ready-1.conditions.test-cond.svc.cluster.local. 303 IN A 172.17.7.10"),
+			test.A("ready-2.conditions.test-cond.svc.cluster.local. 303 IN A 172.17.7.11"),
+			test.A("terminating-published.conditions.test-cond.svc.cluster.local. 303 IN A 172.17.7.15"),
+		},
+	},
+	{
+		Qname: "ready-2.conditions.test-cond.svc.cluster.local.", Qtype: dns.TypeA,
+		Rcode: dns.RcodeSuccess,
+		Answer: []dns.RR{
+			test.A("ready-2.conditions.test-cond.svc.cluster.local. 303 IN A 172.17.7.11"),
+		},
+	},
+	{ // An endpoint that is not ready has no name
+		Qname: "not-ready.conditions.test-cond.svc.cluster.local.", Qtype: dns.TypeA,
+		Rcode: dns.RcodeNameError,
+		Ns: []dns.RR{
+			test.SOA("cluster.local.	303	IN	SOA	ns.dns.cluster.local. hostmaster.cluster.local. 1502313310 7200 1800 86400 30"),
+		},
+	},
+	{ // neither has a terminating endpoint that still serves
+		Qname: "terminating-serving.conditions.test-cond.svc.cluster.local.", Qtype: dns.TypeA,
+		Rcode: dns.RcodeNameError,
+		Ns: []dns.RR{
+			test.SOA("cluster.local.	303	IN	SOA	ns.dns.cluster.local. hostmaster.cluster.local. 1502313310 7200 1800 86400 30"),
+		},
+	},
+	{
+		Qname: "terminating.conditions.test-cond.svc.cluster.local.", Qtype: dns.TypeA,
+		Rcode: dns.RcodeNameError,
+		Ns: []dns.RR{
+			test.SOA("cluster.local.	303	IN	SOA	ns.dns.cluster.local. hostmaster.cluster.local. 1502313310 7200 1800 86400 30"),
+		},
+	},
+	{ // unless it remains ready
+		Qname: "terminating-published.conditions.test-cond.svc.cluster.local.", Qtype: dns.TypeA,
+		Rcode: dns.RcodeSuccess,
+		Answer: []dns.RR{
+			test.A("terminating-published.conditions.test-cond.svc.cluster.local. 303 IN A 172.17.7.15"),
+		},
+	},
+}
+
+// rollingSteps are the conditions of the endpoints of the service rolling while its pods terminate, as the
+// endpointslice controller sets them, and the answers after each step.
+var rollingSteps = []struct {
+	name      string
+	endpoints []fixture.Endpoint
+	cases     []test.Case
+}{
+	{
+		name: "pod-2 terminating",
+		endpoints: []fixture.Endpoint{
+			{IP: "172.17.7.20", Hostname: "pod-1"},
+			{IP: "172.17.7.21", Hostname: "pod-2", NotReady: true, Terminating: true, Serving: true},
+		},
+		cases: []test.Case{
+			{
+				Qname: "rolling.test-cond.svc.cluster.local.", Qtype: dns.TypeA,
+				Rcode: dns.RcodeSuccess,
+				Answer: []dns.RR{
+					test.A("rolling.test-cond.svc.cluster.local. 303 IN A 172.17.7.20"),
+				},
+			},
+			{
+				Qname: "pod-2.rolling.test-cond.svc.cluster.local.", Qtype: dns.TypeA,
+				Rcode: dns.RcodeNameError,
+				Ns: []dns.RR{
+					test.SOA("cluster.local.	303	IN	SOA	ns.dns.cluster.local. hostmaster.cluster.local. 1502313310 7200 1800 86400 30"),
+				},
+			},
+		},
+	},
+	{
+		name: "pod-2 stopped serving",
+		endpoints: []fixture.Endpoint{
+			{IP: "172.17.7.20", Hostname: "pod-1"},
+			{IP: "172.17.7.21", Hostname: "pod-2", NotReady: true, Terminating: true},
+		},
+		cases: []test.Case{
+			{
+				Qname: "_http._tcp.rolling.test-cond.svc.cluster.local.", Qtype: dns.TypeSRV,
+				Rcode: dns.RcodeSuccess,
+				Answer: []dns.RR{
+					test.SRV("_http._tcp.rolling.test-cond.svc.cluster.local. 303 IN SRV 0 100 80 pod-1.rolling.test-cond.svc.cluster.local."),
+				},
+				Extra: []dns.RR{
+					test.A("pod-1.rolling.test-cond.svc.cluster.local. 303 IN A 172.17.7.20"),
+				},
+			},
+		},
+	},
+	{
+		name: "pod-2 gone",
+		endpoints: []fixture.Endpoint{
+			{IP: "172.17.7.20", Hostname: "pod-1"},
+		},
+		cases: []test.Case{
+			{
+				Qname: "rolling.test-cond.svc.cluster.local.", Qtype: dns.TypeA,
+				Rcode: dns.RcodeSuccess,
+				Answer: []dns.RR{
+					test.A("rolling.test-cond.svc.cluster.local. 303 IN A 172.17.7.20"),
+				},
+			},
+		},
+	},
+	{ // a headless service without ready endpoints does not exist, even while its last pod still serves
+		name: "pod-1 terminating",
+		endpoints: []fixture.Endpoint{
+			{IP: "172.17.7.20", Hostname: "pod-1", NotReady: true, Terminating: true, Serving: true},
+		},
+		cases: []test.Case{
+			{
+				Qname: "rolling.test-cond.svc.cluster.local.", Qtype: dns.TypeA,
+				Rcode: dns.RcodeNameError,
+				Ns: []dns.RR{
+					test.SOA("cluster.local.	303	IN	SOA	ns.dns.cluster.local. hostmaster.cluster.local. 1502313310 7200 1800 86400 30"),
+				},
+			},
+		},
+	},
+}
+
+// TestKubernetesEndpointConditions checks which endpoints of headless services CoreDNS publishes, for
+// EndpointSlices with mixed conditions and zone hints, for services publishing addresses that are not ready,
+// and while the pods of a service terminate one after the other.
+func TestKubernetesEndpointConditions(t *testing.T) {
+	namespace := NewTestNamespace(t)
+	corefile := `    .:53 {
+        health
+        ready
+        errors
+        log
+        kubernetes cluster.local in-addr.arpa ip6.arpa {
+            namespaces ` + namespace + `
+        }
+    }
+`
+	err := LoadCorefile(corefile)
+	if err != nil {
+		t.Fatalf("Could not load corefile: %s", err)
+	}
+
+	unready := unreadyServices
+	unready.Name = namespace
+	set := &fixture.Set{Namespaces: []fixture.Namespace{unready, {Name: namespace, Services: append([]fixture.Service(nil), conditionsServices...)}}}
+	ApplyFixtures(t, set)
+
+	// the fixtures are ready, but CoreDNS may not have seen them yet
+	retry := RetryPolicy{Transport: 2, Assertion: 5, Interval: time.Second}
+	var cases []Case
+	for _, tc := range NamespacedCases(dnsTestCasesConditions, conditionsNamespace, namespace) {
+		cases = append(cases, Case{Case: tc, Retry: retry})
+	}
+	for _, tc := range (&fixture.Set{Namespaces: set.Namespaces[:1]}).Cases("cluster.local.") {
+		cases = append(cases, Case{Case: tc, Retry: retry})
+	}
+	DoIntegrationCases(t, cases, namespace)
+
+	client, err := NewClient()
+	if err != nil {
+		t.Fatalf("could not create kubernetes client: %s", err)
+	}
+	rolling := &set.Namespaces[1].Services[1]
+	for _, step := range rollingSteps {
+		t.Run(step.name, func(t *testing.T) {
+			rolling.Endpoints = step.endpoints
+			if err := set.UpdateEndpoints(context.Background(), client); err != nil {
+				t.Fatalf("could not update endpoints: %s", err)
+			}
+			var cases []Case
+			for _, tc := range NamespacedCases(step.cases, conditionsNamespace, namespace) {
+				cases = append(cases, Case{Case: tc, Retry: retry})
+			}
+			DoIntegrationCases(t, cases, namespace)
+		})
+	}
+}
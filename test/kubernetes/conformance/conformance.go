@@ -0,0 +1,175 @@
+// Package conformance checks a DNS server against the Kubernetes DNS-Based Service Discovery specification.
+// Every case belongs to a clause of the specification, and a run reports which clauses pass. The suite only
+// needs the address of the server, so it runs against CoreDNS as well as any other implementation.
+package conformance
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/coredns/ci/test/kubernetes/fixture"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+// Clause is a clause of the specification.
+type Clause struct {
+	Section string `json:"section"`
+	Title   string `json:"title"`
+	URL     string `json:"url"`
+}
+
+const (
+	specURL = "https://github.com/kubernetes/dns/blob/master/docs/specification.md"
+	// podsURL documents the records of pods, which the specification does not cover.
+	podsURL = "https://kubernetes.io/docs/concepts/services-networking/dns-pod-service/"
+)
+
+// The clauses of the suite, in the order of the specification.
+var (
+	SchemaVersion      = Clause{"2.2", "Record for Schema Version", specURL + "#22---record-for-schema-version"}
+	ClusterIPAddresses = Clause{"2.3.1", "A/AAAA records of a Service with ClusterIP", specURL + "#231---aaaaa-records"}
+	ClusterIPSRV       = Clause{"2.3.2", "SRV records of a Service with ClusterIP", specURL + "#232---srv-records"}
+	ClusterIPPTR       = Clause{"2.3.3", "PTR records of a Service with ClusterIP", specURL + "#233---ptr-records"}
+	HeadlessAddresses  = Clause{"2.4.1", "A/AAAA records of a Headless Service", specURL + "#241---aaaaa-records"}
+	HeadlessSRV        = Clause{"2.4.2", "SRV records of a Headless Service", specURL + "#242---srv-records"}
+	HeadlessPTR        = Clause{"2.4.3", "PTR records of a Headless Service", specURL + "#243---ptr-records"}
+	ExternalNameCNAME  = Clause{"2.5.1", "CNAME record of an External Name Service", specURL + "#251---cname-record"}
+	PodAddresses       = Clause{"pods", "A/AAAA records of a Pod", podsURL + "#a-aaaa-records-1"}
+	PodHostname        = Clause{"pods-hostname", "Pod's hostname and subdomain fields", podsURL + "#pod-s-hostname-and-subdomain-fields"}
+)
+
+// Clauses are all clauses of the suite.
+var Clauses = []Clause{
+	SchemaVersion,
+	ClusterIPAddresses, ClusterIPSRV, ClusterIPPTR,
+	HeadlessAddresses, HeadlessSRV, HeadlessPTR,
+	ExternalNameCNAME,
+	PodAddresses, PodHostname,
+}
+
+// SchemaVersionTXT is the version of the specification the suite checks.
+const SchemaVersionTXT = "1.1.0"
+
+// Case is a query and the answer a conforming server gives, for a clause.
+type Case struct {
+	Clause Clause
+	test.Case
+}
+
+// The fixtures queried by the suite.
+const (
+	clusterIP    = "10.96.0.20"
+	endpoint1    = "192.0.2.10"
+	endpoint2    = "192.0.2.11"
+	externalName = "example.net"
+	hostnamePod  = "hostname"
+	plainPod     = "plain"
+)
+
+// Fixtures returns the objects the cases of the suite query, in namespace. The pods need IPs before the cases
+// can be derived, see fixture.Set.Wait.
+func Fixtures(namespace string) *fixture.Set {
+	http := []fixture.Port{{Name: "http", Port: 80}}
+	return &fixture.Set{Namespaces: []fixture.Namespace{{
+		Name: namespace,
+		Services: []fixture.Service{
+			{Name: "clusterip", ClusterIPs: []string{clusterIP}, Ports: http},
+			{Name: "headless", Ports: http, Endpoints: []fixture.Endpoint{
+				{IP: endpoint1, Hostname: "ep-1"},
+				{IP: endpoint2, Hostname: "ep-2"},
+			}},
+			{Name: "external", ExternalName: externalName},
+			{Name: "sub", Selector: map[string]string{"app": "sub"}},
+		},
+		Pods: []fixture.Pod{
+			{Name: hostnamePod, Labels: map[string]string{"app": "sub"}, Hostname: "host-1", Subdomain: "sub"},
+			{Name: plainPod},
+		},
+	}}}
+}
+
+// Cases returns the cases of the suite in zone, for set as returned by Fixtures with the IPs of its pods loaded.
+// Cases of pods without an IP are left out.
+func Cases(set *fixture.Set, zone string) []Case {
+	zone = dns.Fqdn(zone)
+	ns := set.Namespaces[0]
+	svc := func(name string) string { return name + "." + ns.Name + ".svc." + zone }
+	arpa := func(ip string) string {
+		name, _ := dns.ReverseAddr(ip)
+		return name
+	}
+
+	cases := []Case{
+		{SchemaVersion, test.Case{
+			Qname: "dns-version." + zone, Qtype: dns.TypeTXT,
+			Answer: []dns.RR{test.TXT(fmt.Sprintf(`dns-version.%s 303 IN TXT "%s"`, zone, SchemaVersionTXT))},
+		}},
+
+		{ClusterIPAddresses, test.Case{
+			Qname: svc("clusterip"), Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A(svc("clusterip") + " 303 IN A " + clusterIP)},
+		}},
+		{ClusterIPAddresses, test.Case{ // an IPv4 service has no AAAA records
+			Qname: svc("clusterip"), Qtype: dns.TypeAAAA,
+		}},
+		{ClusterIPSRV, test.Case{
+			Qname: "_http._tcp." + svc("clusterip"), Qtype: dns.TypeSRV,
+			Answer: []dns.RR{test.SRV("_http._tcp." + svc("clusterip") + " 303 IN SRV 0 100 80 " + svc("clusterip"))},
+		}},
+		{ClusterIPPTR, test.Case{
+			Qname: arpa(clusterIP), Qtype: dns.TypePTR,
+			Answer: []dns.RR{test.PTR(arpa(clusterIP) + " 303 IN PTR " + svc("clusterip"))},
+		}},
+
+		{HeadlessAddresses, test.Case{
+			Qname: svc("headless"), Qtype: dns.TypeA,
+			Answer: []dns.RR{
+				test.A(svc("headless") + " 303 IN A " + endpoint1),
+				test.A(svc("headless") + " 303 IN A " + endpoint2),
+			},
+		}},
+		{HeadlessAddresses, test.Case{
+			Qname: "ep-1." + svc("headless"), Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("ep-1." + svc("headless") + " 303 IN A " + endpoint1)},
+		}},
+		{HeadlessSRV, test.Case{
+			Qname: "_http._tcp." + svc("headless"), Qtype: dns.TypeSRV,
+			Answer: []dns.RR{
+				test.SRV("_http._tcp." + svc("headless") + " 303 IN SRV 0 50 80 ep-1." + svc("headless")),
+				test.SRV("_http._tcp." + svc("headless") + " 303 IN SRV 0 50 80 ep-2." + svc("headless")),
+			},
+		}},
+		{HeadlessPTR, test.Case{
+			Qname: arpa(endpoint2), Qtype: dns.TypePTR,
+			Answer: []dns.RR{test.PTR(arpa(endpoint2) + " 303 IN PTR ep-2." + svc("headless"))},
+		}},
+
+		{ExternalNameCNAME, test.Case{
+			Qname: svc("external"), Qtype: dns.TypeCNAME,
+			Answer: []dns.RR{test.CNAME(svc("external") + " 303 IN CNAME " + dns.Fqdn(externalName))},
+		}},
+	}
+
+	for _, pod := range ns.Pods {
+		if pod.IP == "" {
+			continue
+		}
+		switch pod.Name {
+		case plainPod:
+			name := strings.NewReplacer(".", "-", ":", "-").Replace(pod.IP) + "." + ns.Name + ".pod." + zone
+			cases = append(cases, Case{PodAddresses, address(name, pod.IP)})
+		case hostnamePod:
+			cases = append(cases, Case{PodHostname, address(pod.Hostname+"."+svc(pod.Subdomain), pod.IP)})
+		}
+	}
+	return cases
+}
+
+func address(name, ip string) test.Case {
+	if strings.Contains(ip, ":") {
+		return test.Case{Qname: name, Qtype: dns.TypeAAAA, Answer: []dns.RR{test.AAAA(name + " 303 IN AAAA " + ip)}}
+	}
+	return test.Case{Qname: name, Qtype: dns.TypeA, Answer: []dns.RR{test.A(name + " 303 IN A " + ip)}}
+}
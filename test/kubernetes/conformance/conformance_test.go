@@ -0,0 +1,103 @@
+package conformance
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/test"
+	ctest "github.com/coredns/coredns/test"
+)
+
+// clusterLocal and inAddrArpa are the zones of a conforming stand-in server for Fixtures("test-1"), with
+// the pods at 10.244.0.5 and 10.244.0.6.
+const (
+	clusterLocal = `$ORIGIN cluster.local.
+@	30 IN SOA ns.dns.cluster.local. hostmaster.cluster.local. 1 7200 1800 86400 30
+	30 IN NS ns.dns.cluster.local.
+ns.dns	30 IN A 10.96.0.10
+dns-version	30 IN TXT "1.1.0"
+clusterip.test-1.svc	30 IN A 10.96.0.20
+_http._tcp.clusterip.test-1.svc	30 IN SRV 0 100 80 clusterip.test-1.svc
+headless.test-1.svc	30 IN A 192.0.2.10
+headless.test-1.svc	30 IN A 192.0.2.11
+ep-1.headless.test-1.svc	30 IN A 192.0.2.10
+ep-2.headless.test-1.svc	30 IN A 192.0.2.11
+_http._tcp.headless.test-1.svc	30 IN SRV 10 50 80 ep-1.headless.test-1.svc
+_http._tcp.headless.test-1.svc	30 IN SRV 10 50 80 ep-2.headless.test-1.svc
+external.test-1.svc	30 IN CNAME example.net.
+host-1.sub.test-1.svc	30 IN A 10.244.0.5
+10-244-0-6.test-1.pod	30 IN A 10.244.0.6
+`
+	inAddrArpa = `$ORIGIN in-addr.arpa.
+@	30 IN SOA ns.dns.cluster.local. hostmaster.cluster.local. 1 7200 1800 86400 30
+	30 IN NS ns.dns.cluster.local.
+20.0.96.10	30 IN PTR clusterip.test-1.svc.cluster.local.
+11.2.0.192	30 IN PTR ep-2.headless.test-1.svc.cluster.local.
+`
+)
+
+// standIn starts a server for the zones, and returns its address.
+func standIn(t *testing.T, clusterLocal, inAddrArpa string) string {
+	dir := t.TempDir()
+	zone, _, err := test.TempFile(dir, clusterLocal)
+	if err != nil {
+		t.Fatalf("could not create zone file: %s", err)
+	}
+	reverse, _, err := test.TempFile(dir, inAddrArpa)
+	if err != nil {
+		t.Fatalf("could not create zone file: %s", err)
+	}
+	server, udp, _, err := ctest.CoreDNSServerAndPorts(`.:0 {
+    file ` + zone + ` cluster.local
+    file ` + reverse + ` in-addr.arpa
+}`)
+	if err != nil {
+		t.Fatalf("could not start stand-in: %s", err)
+	}
+	t.Cleanup(func() { server.Stop() })
+	return udp
+}
+
+func suite() []Case {
+	set := Fixtures("test-1")
+	for i := range set.Namespaces[0].Pods {
+		pod := &set.Namespaces[0].Pods[i]
+		pod.IP = map[string]string{hostnamePod: "10.244.0.5", plainPod: "10.244.0.6"}[pod.Name]
+	}
+	return Cases(set, "cluster.local")
+}
+
+func TestRun(t *testing.T) {
+	r := Run(standIn(t, clusterLocal, inAddrArpa), suite())
+	t.Log(r)
+	if r.Failed() {
+		t.Errorf("expected the stand-in to conform")
+	}
+	if len(r.Clauses) != len(Clauses) {
+		t.Errorf("expected a result for all %d clauses, got %d", len(Clauses), len(r.Clauses))
+	}
+}
+
+func TestRunFailures(t *testing.T) {
+	broken := strings.NewReplacer(
+		"dns-version\t30 IN TXT \"1.1.0\"\n", "",
+		"SRV 0 100 80 clusterip", "SRV 0 100 8080 clusterip",
+	).Replace(clusterLocal)
+	r := Run(standIn(t, broken, inAddrArpa), suite())
+
+	failed := map[string]ClauseResult{}
+	for _, cr := range r.Clauses {
+		if cr.Failed > 0 {
+			failed[cr.Section] = cr
+		}
+	}
+	if len(failed) != 2 {
+		t.Fatalf("expected 2 failed clauses, got:\n%s", r)
+	}
+	if f := failed[SchemaVersion.Section].Failures; len(f) != 1 || !strings.Contains(f[0], "rcode NXDOMAIN, expected NOERROR") {
+		t.Errorf("expected the schema version to be missing, got %v", f)
+	}
+	if f := failed[ClusterIPSRV.Section].Failures; len(f) != 1 || !strings.Contains(f[0], "missing") || !strings.Contains(f[0], "unexpected") {
+		t.Errorf("expected a missing and an unexpected SRV record, got %v", f)
+	}
+}
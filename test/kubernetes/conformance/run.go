@@ -0,0 +1,166 @@
+package conformance
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// timeout is the timeout of a query.
+const timeout = 5 * time.Second
+
+// Report is the result of a run, by clause.
+type Report struct {
+	Timestamp time.Time      `json:"timestamp"`
+	CoreDNS   string         `json:"coredns,omitempty"` // CoreDNS commit under test, from $COREDNS_COMMIT
+	Server    string         `json:"server"`
+	Clauses   []ClauseResult `json:"clauses"`
+}
+
+// ClauseResult is the result of the cases of a clause.
+type ClauseResult struct {
+	Clause
+	Passed   int      `json:"passed"`
+	Failed   int      `json:"failed"`
+	Failures []string `json:"failures,omitempty"`
+}
+
+// Run queries server for every case and reports the results by clause, in the order of Clauses. Clauses
+// without cases are left out.
+func Run(server string, cases []Case) *Report {
+	r := &Report{Timestamp: time.Now().UTC(), CoreDNS: os.Getenv("COREDNS_COMMIT"), Server: server}
+	results := map[string]*ClauseResult{}
+	for _, c := range Clauses {
+		results[c.Section] = &ClauseResult{Clause: c}
+	}
+	for _, tc := range cases {
+		cr, ok := results[tc.Clause.Section]
+		if !ok {
+			cr = &ClauseResult{Clause: tc.Clause}
+			results[tc.Clause.Section] = cr
+		}
+		if err := check(server, tc); err != nil {
+			cr.Failed++
+			cr.Failures = append(cr.Failures, fmt.Sprintf("%s %s: %s", tc.Qname, dns.TypeToString[tc.Qtype], err))
+		} else {
+			cr.Passed++
+		}
+	}
+
+	for _, c := range Clauses {
+		if cr := results[c.Section]; cr.Passed+cr.Failed > 0 {
+			r.Clauses = append(r.Clauses, *cr)
+		}
+		delete(results, c.Section)
+	}
+	var others []string
+	for section := range results {
+		others = append(others, section)
+	}
+	sort.Strings(others)
+	for _, section := range others {
+		r.Clauses = append(r.Clauses, *results[section])
+	}
+	return r
+}
+
+// Failed returns whether a case of the report failed.
+func (r *Report) Failed() bool {
+	for _, cr := range r.Clauses {
+		if cr.Failed > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns a line per clause, with its failures indented below it.
+func (r *Report) String() string {
+	var b strings.Builder
+	for _, cr := range r.Clauses {
+		status := "PASS"
+		if cr.Failed > 0 {
+			status = "FAIL"
+		}
+		fmt.Fprintf(&b, "%s %s %s (%d/%d) %s\n", status, cr.Section, cr.Title, cr.Passed, cr.Passed+cr.Failed, cr.URL)
+		for _, f := range cr.Failures {
+			fmt.Fprintf(&b, "    %s\n", f)
+		}
+	}
+	return b.String()
+}
+
+// Write writes the report as JSON to <dir>/conformance.json.
+func (r *Report) Write(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "conformance.json"), data, 0644)
+}
+
+// check queries server for tc, and compares the rcode and the answer section of the response with the case.
+// The specification leaves the TTL and the priority and weight of SRV records to the implementation, so they
+// are not compared, and neither are the authority and additional sections.
+func check(server string, tc Case) error {
+	res, err := exchange(server, tc.Msg())
+	if err != nil {
+		return err
+	}
+	if res.Rcode != tc.Rcode {
+		return fmt.Errorf("rcode %s, expected %s", dns.RcodeToString[res.Rcode], dns.RcodeToString[tc.Rcode])
+	}
+	got, want := keys(res.Answer), keys(tc.Answer)
+	var problems []string
+	for k := range want {
+		if !got[k] {
+			problems = append(problems, "missing "+k)
+		}
+	}
+	for k := range got {
+		if !want[k] {
+			problems = append(problems, "unexpected "+k)
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("%s", strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+// exchange sends m over UDP, and again over TCP if the response is truncated.
+func exchange(server string, m *dns.Msg) (*dns.Msg, error) {
+	c := &dns.Client{Timeout: timeout}
+	res, _, err := c.Exchange(m, server)
+	if err == nil && res.Truncated {
+		c.Net = "tcp"
+		res, _, err = c.Exchange(m, server)
+	}
+	return res, err
+}
+
+// keys returns the records as text without their TTL and SRV priority and weight.
+func keys(rrs []dns.RR) map[string]bool {
+	k := make(map[string]bool, len(rrs))
+	for _, rr := range rrs {
+		rr = dns.Copy(rr)
+		rr.Header().Ttl = 0
+		rr.Header().Name = strings.ToLower(rr.Header().Name)
+		if srv, ok := rr.(*dns.SRV); ok {
+			srv.Priority, srv.Weight = 0, 0
+			srv.Target = strings.ToLower(srv.Target)
+		}
+		k[strings.ReplaceAll(rr.String(), "\t", " ")] = true
+	}
+	return k
+}
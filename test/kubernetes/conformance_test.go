@@ -0,0 +1,52 @@
+package kubernetes
+
+import (
+	"os"
+	"testing"
+
+	"github.com/coredns/ci/test/kubernetes/conformance"
+)
+
+// TestKubernetesConformance runs the conformance suite against CoreDNS, exposed on a node port, or against the
+// server at $CONFORMANCE_SERVER, and reports the result per clause of the specification.
+func TestKubernetesConformance(t *testing.T) {
+	corefile := `    .:53 {
+        health
+        ready
+        errors
+        kubernetes cluster.local in-addr.arpa ip6.arpa {
+            pods insecure
+        }
+    }
+`
+	err := LoadCorefile(corefile)
+	if err != nil {
+		t.Fatalf("Could not load corefile: %s", err)
+	}
+	namespace := NewTestNamespace(t)
+	set := conformance.Fixtures(namespace)
+	ApplyFixtures(t, set)
+
+	server := os.Getenv("CONFORMANCE_SERVER")
+	if server == "" {
+		server, _ = ExposeCoreDNS(t)
+	}
+	report := conformance.Run(server, conformance.Cases(set, "cluster.local."))
+	t.Logf("conformance of %s:\n%s", server, report)
+	for _, cr := range report.Clauses {
+		t.Run(cr.Section, func(t *testing.T) {
+			for _, f := range cr.Failures {
+				t.Errorf("%s (%s)", f, cr.URL)
+			}
+		})
+	}
+
+	if dir := os.Getenv("REPORT_DIR"); dir != "" {
+		if err := report.Write(dir); err != nil {
+			t.Errorf("could not write report: %s", err)
+		}
+	}
+	if report.Failed() {
+		t.Logf("coredns log: %s", CorednsLogs())
+	}
+}
@@ -0,0 +1,122 @@
+package kubernetes
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+// Kinds of inconsistencies.
+const (
+	Missing  = "missing"  // an expected record is not served
+	Extra    = "extra"    // a record is served that is not expected
+	Mismatch = "mismatch" // the response has another rcode than expected, or the query failed
+)
+
+// Inconsistency is a difference between the records CoreDNS serves for a name and the records expected
+// from the objects in the API.
+type Inconsistency struct {
+	Qname  string
+	Qtype  uint16
+	Kind   string
+	Detail string // the record that is missing or extra, or what mismatches
+}
+
+func (i Inconsistency) String() string {
+	return fmt.Sprintf("%s %s: %s %s", i.Qname, dns.TypeToString[i.Qtype], i.Kind, i.Detail)
+}
+
+// CheckConsistency queries the name of every case with exchange and returns the inconsistencies between
+// the responses and the cases. Cases for the same name and type, such as the PTR cases of an IP that backs
+// several services, are merged. Records are compared without their TTL and the weight of SRV records.
+func CheckConsistency(cases []test.Case, exchange func(*dns.Msg) (*dns.Msg, error)) []Inconsistency {
+	var order []string
+	merged := map[string]*test.Case{}
+	for _, tc := range cases {
+		key := strings.ToLower(tc.Qname) + " " + dns.TypeToString[tc.Qtype]
+		if m, ok := merged[key]; ok {
+			m.Answer = append(m.Answer, tc.Answer...)
+			continue
+		}
+		tc := tc
+		merged[key] = &tc
+		order = append(order, key)
+	}
+
+	var found []Inconsistency
+	for _, key := range order {
+		tc := merged[key]
+		res, err := exchange(tc.Msg())
+		if err != nil {
+			found = append(found, Inconsistency{Qname: tc.Qname, Qtype: tc.Qtype, Kind: Mismatch, Detail: err.Error()})
+			continue
+		}
+		if res.Rcode != tc.Rcode {
+			found = append(found, Inconsistency{Qname: tc.Qname, Qtype: tc.Qtype, Kind: Mismatch,
+				Detail: fmt.Sprintf("rcode %s, expected %s", dns.RcodeToString[res.Rcode], dns.RcodeToString[tc.Rcode])})
+			continue
+		}
+		found = append(found, compareRecords(tc.Qname, tc.Qtype, res.Answer, tc.Answer)...)
+	}
+	return found
+}
+
+// CheckAXFR returns the inconsistencies between the A, AAAA, SRV and CNAME records of a zone transfer, such as one
+// parsed with ParseDigAXFR, and the expected records. Other records of the transfer are ignored, and records
+// are compared without their TTL and the weight of SRV records.
+func CheckAXFR(xfr []dns.RR, expected []dns.RR) []Inconsistency {
+	var records []dns.RR
+	for _, rr := range xfr {
+		switch rr.Header().Rrtype {
+		case dns.TypeA, dns.TypeAAAA, dns.TypeSRV, dns.TypeCNAME:
+			records = append(records, rr)
+		}
+	}
+	return compareRecords("", 0, records, expected)
+}
+
+// compareRecords returns the records of expected missing in got, and the records of got that are not
+// expected. If qname is empty, the inconsistencies are reported for the names and types of the records.
+func compareRecords(qname string, qtype uint16, got, expected []dns.RR) []Inconsistency {
+	gotKeys, expectedKeys := recordKeys(got), recordKeys(expected)
+	var found []Inconsistency
+	report := func(kind string, keys, other map[string]dns.RR) {
+		var absent []string
+		for key := range keys {
+			if _, ok := other[key]; !ok {
+				absent = append(absent, key)
+			}
+		}
+		sort.Strings(absent)
+		for _, key := range absent {
+			i := Inconsistency{Qname: qname, Qtype: qtype, Kind: kind, Detail: key}
+			if qname == "" {
+				i.Qname, i.Qtype = keys[key].Header().Name, keys[key].Header().Rrtype
+			}
+			found = append(found, i)
+		}
+	}
+	report(Missing, expectedKeys, gotKeys)
+	report(Extra, gotKeys, expectedKeys)
+	return found
+}
+
+// recordKeys returns the records by their text without the TTL and the weight of SRV records.
+func recordKeys(rrs []dns.RR) map[string]dns.RR {
+	keys := make(map[string]dns.RR, len(rrs))
+	for _, rr := range rrs {
+		rr = dns.Copy(rr)
+		rr.Header().Ttl = 0
+		rr.Header().Name = strings.ToLower(rr.Header().Name)
+		if srv, ok := rr.(*dns.SRV); ok {
+			srv.Weight = 0
+			srv.Target = strings.ToLower(srv.Target)
+		}
+		keys[rr.String()] = rr
+	}
+	return keys
+}
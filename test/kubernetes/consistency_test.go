@@ -0,0 +1,149 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/coredns/ci/test/kubernetes/fixture"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+func TestCheckConsistency(t *testing.T) {
+	cases := []test.Case{
+		{Qname: "svc.test-1.svc.cluster.local.", Qtype: dns.TypeA, Answer: []dns.RR{test.A("svc.test-1.svc.cluster.local. 303 IN A 10.96.0.100")}},
+		{Qname: "10.0.244.10.in-addr.arpa.", Qtype: dns.TypePTR, Answer: []dns.RR{test.PTR("10.0.244.10.in-addr.arpa. 303 IN PTR 10-244-0-10.a.test-1.svc.cluster.local.")}},
+		{Qname: "10.0.244.10.in-addr.arpa.", Qtype: dns.TypePTR, Answer: []dns.RR{test.PTR("10.0.244.10.in-addr.arpa. 303 IN PTR 10-244-0-10.b.test-1.svc.cluster.local.")}},
+		{Qname: "gone.test-1.svc.cluster.local.", Qtype: dns.TypeA, Rcode: dns.RcodeNameError},
+		{Qname: "down.test-1.svc.cluster.local.", Qtype: dns.TypeA},
+		{Qname: "_http._tcp.svc.test-1.svc.cluster.local.", Qtype: dns.TypeSRV, Answer: []dns.RR{test.SRV("_http._tcp.svc.test-1.svc.cluster.local. 303 IN SRV 0 50 80 svc.test-1.svc.cluster.local.")}},
+	}
+	responses := map[string][]dns.RR{
+		"svc.test-1.svc.cluster.local.": {test.A("svc.test-1.svc.cluster.local. 5 IN A 10.96.0.101")},
+		"10.0.244.10.in-addr.arpa.": {
+			test.PTR("10.0.244.10.in-addr.arpa. 5 IN PTR 10-244-0-10.b.test-1.svc.cluster.local."),
+			test.PTR("10.0.244.10.in-addr.arpa. 5 IN PTR 10-244-0-10.a.test-1.svc.cluster.local."),
+		},
+		"_http._tcp.svc.test-1.svc.cluster.local.": {test.SRV("_http._tcp.svc.test-1.svc.cluster.local. 5 IN SRV 0 100 80 svc.test-1.svc.cluster.local.")},
+	}
+	queried := 0
+	exchange := func(m *dns.Msg) (*dns.Msg, error) {
+		queried++
+		if m.Question[0].Name == "down.test-1.svc.cluster.local." {
+			return nil, errors.New("timeout")
+		}
+		res := new(dns.Msg)
+		res.SetReply(m)
+		res.Answer = responses[m.Question[0].Name]
+		return res, nil
+	}
+
+	got := map[string]bool{}
+	for _, i := range CheckConsistency(cases, exchange) {
+		got[i.String()] = true
+	}
+	for _, want := range []string{
+		"svc.test-1.svc.cluster.local. A: missing svc.test-1.svc.cluster.local.\t0\tIN\tA\t10.96.0.100",
+		"svc.test-1.svc.cluster.local. A: extra svc.test-1.svc.cluster.local.\t0\tIN\tA\t10.96.0.101",
+		"gone.test-1.svc.cluster.local. A: mismatch rcode NOERROR, expected NXDOMAIN",
+		"down.test-1.svc.cluster.local. A: mismatch timeout",
+	} {
+		if !got[want] {
+			t.Errorf("expected inconsistency %q", want)
+		}
+	}
+	if len(got) != 4 {
+		t.Errorf("expected 4 inconsistencies, got %v", got)
+	}
+	// the PTR cases are merged into one query
+	if queried != 5 {
+		t.Errorf("expected 5 queries, got %d", queried)
+	}
+}
+
+func TestCheckAXFR(t *testing.T) {
+	xfr := []dns.RR{
+		test.SOA("cluster.local. 5 IN SOA ns.dns.cluster.local. hostmaster.cluster.local. 1726233714 7200 1800 86400 5"),
+		test.NS("cluster.local. 5 IN NS kube-dns.kube-system.svc.cluster.local."),
+		test.A("svc.test-1.svc.cluster.local. 5 IN A 10.96.0.100"),
+		test.A("stale.test-1.svc.cluster.local. 5 IN A 10.96.0.102"),
+		test.SOA("cluster.local. 5 IN SOA ns.dns.cluster.local. hostmaster.cluster.local. 1726233714 7200 1800 86400 5"),
+	}
+	expected := []dns.RR{
+		test.A("svc.test-1.svc.cluster.local. 303 IN A 10.96.0.100"),
+		test.SRV("svc.test-1.svc.cluster.local. 303 IN SRV 0 100 80 svc.test-1.svc.cluster.local."),
+	}
+	found := CheckAXFR(xfr, expected)
+	if len(found) != 2 {
+		t.Fatalf("expected 2 inconsistencies, got %v", found)
+	}
+	if found[0].Kind != Missing || found[0].Qtype != dns.TypeSRV {
+		t.Errorf("expected missing SRV record, got %s", found[0])
+	}
+	if found[1].Kind != Extra || found[1].Qname != "stale.test-1.svc.cluster.local." {
+		t.Errorf("expected extra record for stale, got %s", found[1])
+	}
+}
+
+// TestKubernetesConsistency derives the records of all Services, EndpointSlices and Pods in the cluster, queries
+// them through a node port and checks that CoreDNS serves exactly these records, by query and by zone transfer.
+func TestKubernetesConsistency(t *testing.T) {
+	corefile := `    .:53 {
+        health
+        ready
+        errors
+        kubernetes cluster.local in-addr.arpa ip6.arpa {
+            pods verified
+        }
+        transfer {
+            to *
+        }
+    }
+`
+	err := LoadCorefile(corefile)
+	if err != nil {
+		t.Fatalf("Could not load corefile: %s", err)
+	}
+	namespace := NewTestNamespace(t)
+	CollectArtifacts(t, namespace)
+	if err := StartClientPod(namespace); err != nil {
+		t.Fatalf("failed to start client pod: %s", err)
+	}
+	// the node port service is created first, so its records are expected as well
+	_, tcp := ExposeCoreDNS(t)
+
+	client, err := NewClient()
+	if err != nil {
+		t.Fatalf("could not create kubernetes client: %s", err)
+	}
+	set, err := fixture.FromCluster(context.TODO(), client)
+	if err != nil {
+		t.Fatalf("could not list objects: %s", err)
+	}
+	cases := append(set.Cases("cluster.local."), set.PodCases("cluster.local.")...)
+
+	// large answers are truncated over udp
+	c := &dns.Client{Net: "tcp", Timeout: 5 * time.Second}
+	exchange := func(m *dns.Msg) (*dns.Msg, error) {
+		res, _, err := c.Exchange(m, tcp)
+		return res, err
+	}
+	t.Logf("checking %d cases", len(cases))
+	for _, i := range CheckConsistency(cases, exchange) {
+		t.Error(i)
+	}
+
+	res, err := DoIntegrationTest(t, test.Case{Qname: "cluster.local.", Qtype: dns.TypeAXFR}, namespace)
+	if err != nil {
+		t.Fatalf("zone transfer failed: %s", err)
+	}
+	for _, i := range CheckAXFR(res.Answer, set.TransferRecords("cluster.local.")) {
+		t.Error(i)
+	}
+	if t.Failed() {
+		t.Errorf("coredns log: %s", CorednsLogs())
+	}
+}
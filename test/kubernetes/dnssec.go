@@ -0,0 +1,190 @@
+package kubernetes
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// SigningKey is a DNSSEC key generated for a test, and the files the dnssec plugin reads it from.
+type SigningKey struct {
+	DNSKEY  *dns.DNSKEY
+	Public  string // the .key file
+	Private string // the .private file
+}
+
+// NewSigningKey generates an ECDSA P-256 key for zone, with the SEP flag set, so the dnssec plugin uses it
+// to sign all records.
+func NewSigningKey(zone string) (*SigningKey, error) {
+	key := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: dns.Fqdn(zone), Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     dns.ZONE | dns.SEP,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	priv, err := key.Generate(256)
+	if err != nil {
+		return nil, err
+	}
+	return &SigningKey{DNSKEY: key, Public: key.String() + "\n", Private: key.PrivateKeyString(priv)}, nil
+}
+
+// Files returns the files of the key with the name base, for LoadCorefileAndFiles. The key is then configured
+// with "key file /etc/coredns/<base>".
+func (k *SigningKey) Files(base string) map[string]string {
+	return map[string]string{base + ".key": k.Public, base + ".private": k.Private}
+}
+
+// VerifyRRSIGs checks that every RRset in the answer and authority sections of m is signed by zone, with an
+// RRSIG that is valid now and verifies against one of keys. It returns an error for every RRset that is not.
+func VerifyRRSIGs(m *dns.Msg, zone string, keys []*dns.DNSKEY) []error {
+	var errs []error
+	for _, section := range [][]dns.RR{m.Answer, m.Ns} {
+		var order []string
+		rrsets := map[string][]dns.RR{}
+		sigs := map[string][]*dns.RRSIG{}
+		for _, rr := range section {
+			if sig, ok := rr.(*dns.RRSIG); ok {
+				key := rrsetKey(sig.Header().Name, sig.TypeCovered)
+				sigs[key] = append(sigs[key], sig)
+				continue
+			}
+			key := rrsetKey(rr.Header().Name, rr.Header().Rrtype)
+			if _, ok := rrsets[key]; !ok {
+				order = append(order, key)
+			}
+			rrsets[key] = append(rrsets[key], rr)
+		}
+		for _, key := range order {
+			if err := verifyRRset(rrsets[key], sigs[key], zone, keys); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %s", key, err))
+			}
+		}
+	}
+	return errs
+}
+
+func verifyRRset(rrset []dns.RR, sigs []*dns.RRSIG, zone string, keys []*dns.DNSKEY) error {
+	if len(sigs) == 0 {
+		return fmt.Errorf("no RRSIG")
+	}
+	var failures []string
+	for _, sig := range sigs {
+		if !strings.EqualFold(sig.SignerName, dns.Fqdn(zone)) {
+			failures = append(failures, fmt.Sprintf("key %d: signer %s", sig.KeyTag, sig.SignerName))
+			continue
+		}
+		if !sig.ValidityPeriod(time.Now()) {
+			failures = append(failures, fmt.Sprintf("key %d: not valid now", sig.KeyTag))
+			continue
+		}
+		for _, key := range keys {
+			if key.KeyTag() != sig.KeyTag || key.Algorithm != sig.Algorithm {
+				continue
+			}
+			err := sig.Verify(key, rrset)
+			if err == nil {
+				return nil
+			}
+			failures = append(failures, fmt.Sprintf("key %d: %s", sig.KeyTag, err))
+		}
+	}
+	if len(failures) == 0 {
+		return fmt.Errorf("no RRSIG by a known key")
+	}
+	return fmt.Errorf("no valid RRSIG: %s", strings.Join(failures, ", "))
+}
+
+func rrsetKey(name string, rrtype uint16) string {
+	return strings.ToLower(name) + " " + dns.TypeToString[rrtype]
+}
+
+// VerifyDenial checks that m, a response for qname and qtype without answers, proves that they do not exist
+// with an NSEC record in the authority section: one for qname without qtype in its type bitmap, or one that
+// covers qname. The signatures of the NSEC records are checked with VerifyRRSIGs.
+func VerifyDenial(m *dns.Msg, qname string, qtype uint16) error {
+	if len(m.Answer) > 0 {
+		return fmt.Errorf("expected no answers, got %d", len(m.Answer))
+	}
+	var nsecs []string
+	for _, rr := range m.Ns {
+		nsec, ok := rr.(*dns.NSEC)
+		if !ok {
+			continue
+		}
+		if strings.EqualFold(nsec.Header().Name, qname) {
+			for _, t := range nsec.TypeBitMap {
+				if t == qtype {
+					return fmt.Errorf("NSEC %s has type %s", nsec.Header().Name, dns.TypeToString[qtype])
+				}
+			}
+			return nil
+		}
+		if covers(nsec, qname) {
+			return nil
+		}
+		nsecs = append(nsecs, nsec.String())
+	}
+	if len(nsecs) == 0 {
+		return fmt.Errorf("no NSEC")
+	}
+	return fmt.Errorf("no NSEC proves %s %s does not exist: %s", qname, dns.TypeToString[qtype], strings.Join(nsecs, ", "))
+}
+
+// covers returns whether qname is between the owner and the next domain of nsec, in canonical order.
+func covers(nsec *dns.NSEC, qname string) bool {
+	owner, next := nsec.Header().Name, nsec.NextDomain
+	if canonicalCompare(owner, next) < 0 {
+		return canonicalCompare(owner, qname) < 0 && canonicalCompare(qname, next) < 0
+	}
+	// the last NSEC of the zone wraps around to the apex
+	return canonicalCompare(owner, qname) < 0 || canonicalCompare(qname, next) < 0
+}
+
+// canonicalCompare compares two names in the canonical order of RFC 4034, section 6.1: label by label from
+// the root, case insensitive, with escaped bytes compared by their value.
+func canonicalCompare(a, b string) int {
+	la, lb := canonicalLabels(a), canonicalLabels(b)
+	for i := 0; i < len(la) && i < len(lb); i++ {
+		if c := strings.Compare(la[i], lb[i]); c != 0 {
+			return c
+		}
+	}
+	return len(la) - len(lb)
+}
+
+// canonicalLabels returns the labels of name from the root, in lower case and unescaped.
+func canonicalLabels(name string) []string {
+	labels := dns.SplitDomainName(name)
+	for i, j := 0, len(labels)-1; i < j; i, j = i+1, j-1 {
+		labels[i], labels[j] = labels[j], labels[i]
+	}
+	for i, l := range labels {
+		var b strings.Builder
+		for k := 0; k < len(l); k++ {
+			switch {
+			case l[k] == '\\' && k+3 < len(l) && isDigits(l[k+1:k+4]):
+				b.WriteByte((l[k+1]-'0')*100 + (l[k+2]-'0')*10 + (l[k+3] - '0'))
+				k += 3
+			case l[k] == '\\' && k+1 < len(l):
+				b.WriteByte(l[k+1])
+				k++
+			default:
+				b.WriteByte(l[k])
+			}
+		}
+		labels[i] = strings.ToLower(b.String())
+	}
+	return labels
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,286 @@
+package kubernetes
+
+import (
+	"crypto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coredns/ci/test/kubernetes/fixture"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+// sign returns rrset with an RRSIG of key for zone.
+func sign(t *testing.T, zone string, key *dns.DNSKEY, priv string, rrset ...dns.RR) []dns.RR {
+	signer, err := key.ReadPrivateKey(strings.NewReader(priv), "private")
+	if err != nil {
+		t.Fatalf("could not read private key: %s", err)
+	}
+	now := time.Now()
+	sig := &dns.RRSIG{
+		Hdr:        dns.RR_Header{Name: rrset[0].Header().Name, Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: rrset[0].Header().Ttl},
+		Algorithm:  key.Algorithm,
+		SignerName: zone,
+		KeyTag:     key.KeyTag(),
+		Inception:  uint32(now.Add(-time.Hour).Unix()),
+		Expiration: uint32(now.Add(time.Hour).Unix()),
+	}
+	if err := sig.Sign(signer.(crypto.Signer), rrset); err != nil {
+		t.Fatalf("could not sign: %s", err)
+	}
+	return append(rrset, sig)
+}
+
+func TestVerifyRRSIGs(t *testing.T) {
+	key, err := NewSigningKey("cluster.local.")
+	if err != nil {
+		t.Fatalf("could not generate key: %s", err)
+	}
+	other, err := NewSigningKey("cluster.local.")
+	if err != nil {
+		t.Fatalf("could not generate key: %s", err)
+	}
+	a := test.A("svc.ns.svc.cluster.local. 5 IN A 10.96.0.1")
+
+	m := new(dns.Msg)
+	m.Answer = sign(t, "cluster.local.", key.DNSKEY, key.Private, a)
+	m.Ns = sign(t, "cluster.local.", key.DNSKEY, key.Private, test.SOA("cluster.local. 5 IN SOA ns.dns.cluster.local. hostmaster.cluster.local. 1 7200 1800 86400 5"))
+	if errs := VerifyRRSIGs(m, "cluster.local.", []*dns.DNSKEY{key.DNSKEY}); len(errs) != 0 {
+		t.Errorf("expected valid signatures, got %v", errs)
+	}
+	if errs := VerifyRRSIGs(m, "cluster.local.", []*dns.DNSKEY{other.DNSKEY}); len(errs) != 2 {
+		t.Errorf("expected 2 rrsets signed by an unknown key, got %v", errs)
+	}
+	if errs := VerifyRRSIGs(m, "example.org.", []*dns.DNSKEY{key.DNSKEY}); len(errs) != 2 {
+		t.Errorf("expected 2 rrsets signed by another zone, got %v", errs)
+	}
+
+	// a record that was changed after signing
+	m.Answer[0] = test.A("svc.ns.svc.cluster.local. 5 IN A 10.96.0.2")
+	if errs := VerifyRRSIGs(m, "cluster.local.", []*dns.DNSKEY{key.DNSKEY}); len(errs) != 1 {
+		t.Errorf("expected the answer to fail verification, got %v", errs)
+	}
+
+	// a record without signature
+	m.Answer = append(m.Answer, test.AAAA("svc.ns.svc.cluster.local. 5 IN AAAA 1234:abcd::1"))
+	m.Answer[0] = a
+	errs := VerifyRRSIGs(m, "cluster.local.", []*dns.DNSKEY{key.DNSKEY})
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "AAAA: no RRSIG") {
+		t.Errorf("expected the AAAA record to be unsigned, got %v", errs)
+	}
+}
+
+func TestVerifyDenial(t *testing.T) {
+	nsec := func(s string) *dns.Msg {
+		m := new(dns.Msg)
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			t.Fatalf("could not parse %q: %s", s, err)
+		}
+		m.Ns = []dns.RR{rr}
+		return m
+	}
+	tests := []struct {
+		nsec  string
+		qname string
+		qtype uint16
+		ok    bool
+	}{
+		// the black lies of the dnssec plugin
+		{`nx.cluster.local. 5 IN NSEC \000.nx.cluster.local. HINFO TXT AAAA RRSIG NSEC`, "nx.cluster.local.", dns.TypeA, true},
+		{`nx.cluster.local. 5 IN NSEC \000.nx.cluster.local. A HINFO TXT RRSIG NSEC`, "nx.cluster.local.", dns.TypeA, false},
+		{`Nx.Cluster.Local. 5 IN NSEC \000.nx.cluster.local. RRSIG NSEC`, "nx.cluster.local.", dns.TypeA, true},
+		// covering NSEC records
+		{`a.cluster.local. 5 IN NSEC c.cluster.local. A RRSIG NSEC`, "b.cluster.local.", dns.TypeA, true},
+		{`a.cluster.local. 5 IN NSEC c.cluster.local. A RRSIG NSEC`, "x.b.cluster.local.", dns.TypeA, true},
+		{`a.cluster.local. 5 IN NSEC c.cluster.local. A RRSIG NSEC`, "d.cluster.local.", dns.TypeA, false},
+		{`z.cluster.local. 5 IN NSEC cluster.local. A RRSIG NSEC`, "zz.cluster.local.", dns.TypeA, true},
+		{`nx.cluster.local. 5 IN NSEC \000.nx.cluster.local. RRSIG NSEC`, "\\000.nx.cluster.local.", dns.TypeA, false},
+	}
+	for _, tc := range tests {
+		err := VerifyDenial(nsec(tc.nsec), tc.qname, tc.qtype)
+		if tc.ok && err != nil {
+			t.Errorf("%s: expected %s to be denied, got %s", tc.nsec, tc.qname, err)
+		}
+		if !tc.ok && err == nil {
+			t.Errorf("%s: expected %s not to be denied", tc.nsec, tc.qname)
+		}
+	}
+
+	if err := VerifyDenial(new(dns.Msg), "nx.cluster.local.", dns.TypeA); err == nil {
+		t.Error("expected a response without NSEC not to deny anything")
+	}
+}
+
+func TestCanonicalCompare(t *testing.T) {
+	// the example of RFC 4034, section 6.1
+	ordered := []string{
+		"example.", "a.example.", "yljkjljk.a.example.", "Z.a.example.", "zABC.a.EXAMPLE.",
+		"z.example.", "\\001.z.example.", "*.z.example.", "\\200.z.example.",
+	}
+	for i := 0; i < len(ordered)-1; i++ {
+		if c := canonicalCompare(ordered[i], ordered[i+1]); c >= 0 {
+			t.Errorf("expected %s before %s, got %d", ordered[i], ordered[i+1], c)
+		}
+	}
+	if c := canonicalCompare("Z.a.example.", "z.A.example."); c != 0 {
+		t.Errorf("expected names differing in case to be equal, got %d", c)
+	}
+}
+
+// dnssecServices are the services whose records are queried signed.
+var dnssecServices = []fixture.Service{
+	{Name: "svc-a", ClusterIPs: []string{"10.96.0.40"}, Ports: []fixture.Port{{Name: "http", Port: 80}}},
+	{Name: "headless", Ports: []fixture.Port{{Name: "http", Port: 80}}, Endpoints: []fixture.Endpoint{
+		{IP: "172.17.8.10", Hostname: "ep-1"},
+		{IP: "172.17.8.11", Hostname: "ep-2"},
+	}},
+}
+
+// TestKubernetesDNSSEC serves cluster.local signed on the fly by the dnssec plugin with keys generated by the
+// test, and validates the signatures of the answers and the proofs of denial of existence. The key is then
+// rolled over by double signing: each step only adds or removes a key, so the answers remain valid for the
+// DNSKEY set of the step before, which resolvers may still have cached.
+func TestKubernetesDNSSEC(t *testing.T) {
+	namespace := NewTestNamespace(t)
+	ApplyFixtures(t, &fixture.Set{Namespaces: []fixture.Namespace{{Name: namespace, Services: dnssecServices}}})
+	zone := "cluster.local."
+	svc := func(name string) string { return name + "." + namespace + ".svc." + zone }
+
+	oldKey, err := NewSigningKey(zone)
+	if err != nil {
+		t.Fatalf("could not generate key: %s", err)
+	}
+	newKey, err := NewSigningKey(zone)
+	if err != nil {
+		t.Fatalf("could not generate key: %s", err)
+	}
+	keys := map[string]*SigningKey{"Kcluster.local.old": oldKey, "Kcluster.local.new": newKey}
+
+	_, tcp := ExposeCoreDNS(t)
+	exchange := func(qname string, qtype uint16) (*dns.Msg, error) {
+		m := new(dns.Msg)
+		m.SetQuestion(qname, qtype)
+		m.SetEdns0(4096, true)
+		c := &dns.Client{Net: "tcp", Timeout: 5 * time.Second}
+		r, _, err := c.Exchange(m, tcp)
+		return r, err
+	}
+
+	steps := []struct {
+		name    string
+		keys    []string // the keys CoreDNS signs with
+		trusted []string // the keys a resolver may have cached
+	}{
+		{"old key", []string{"Kcluster.local.old"}, []string{"Kcluster.local.old"}},
+		{"both keys", []string{"Kcluster.local.old", "Kcluster.local.new"}, []string{"Kcluster.local.old"}},
+		{"new key", []string{"Kcluster.local.new"}, []string{"Kcluster.local.old", "Kcluster.local.new"}},
+	}
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			files := map[string]string{}
+			var directives, published []string
+			for _, name := range step.keys {
+				for f, content := range keys[name].Files(name) {
+					files[f] = content
+				}
+				directives = append(directives, "/etc/coredns/"+name)
+				published = append(published, keys[name].DNSKEY.String())
+			}
+			corefile := `    .:53 {
+        health
+        ready
+        errors
+        log
+        kubernetes cluster.local {
+            namespaces ` + namespace + `
+        }
+        dnssec cluster.local {
+            key file ` + strings.Join(directives, " ") + `
+        }
+    }
+`
+			if err := LoadCorefileAndFiles(corefile, files); err != nil {
+				t.Fatalf("Could not load corefile and keys: %s", err)
+			}
+
+			// the DNSKEY set is the set of signing keys, and is signed by them
+			r, err := exchange(zone, dns.TypeDNSKEY)
+			if err != nil {
+				t.Fatalf("could not query DNSKEY: %s", err)
+			}
+			var served []*dns.DNSKEY
+			for _, rr := range r.Answer {
+				if k, ok := rr.(*dns.DNSKEY); ok {
+					served = append(served, k)
+				}
+			}
+			if len(served) != len(step.keys) {
+				t.Errorf("expected DNSKEYs %v, got %v", published, r.Answer)
+			}
+			for _, name := range step.keys {
+				found := false
+				for _, k := range served {
+					found = found || k.KeyTag() == keys[name].DNSKEY.KeyTag()
+				}
+				if !found {
+					t.Errorf("expected DNSKEY %s to be served, got %v", name, r.Answer)
+				}
+			}
+
+			var trusted []*dns.DNSKEY
+			for _, name := range step.trusted {
+				trusted = append(trusted, keys[name].DNSKEY)
+			}
+			for _, validate := range []struct {
+				name string
+				keys []*dns.DNSKEY
+			}{{"trusted", trusted}, {"served", served}} {
+				for _, q := range []struct {
+					qname string
+					qtype uint16
+					rcode int
+					// denied queries have no answers, and an NSEC proving it
+					denied bool
+				}{
+					{zone, dns.TypeDNSKEY, dns.RcodeSuccess, false},
+					{zone, dns.TypeSOA, dns.RcodeSuccess, false},
+					{svc("svc-a"), dns.TypeA, dns.RcodeSuccess, false},
+					{"_http._tcp." + svc("svc-a"), dns.TypeSRV, dns.RcodeSuccess, false},
+					{svc("headless"), dns.TypeA, dns.RcodeSuccess, false},
+					{"ep-1." + svc("headless"), dns.TypeA, dns.RcodeSuccess, false},
+					// NODATA
+					{svc("svc-a"), dns.TypeAAAA, dns.RcodeSuccess, true},
+					// NXDOMAIN is answered as NODATA by the black lies of the dnssec plugin
+					{svc("svc-none"), dns.TypeA, dns.RcodeSuccess, true},
+					{"ep-3." + svc("headless"), dns.TypeA, dns.RcodeSuccess, true},
+				} {
+					r, err := exchange(q.qname, q.qtype)
+					if err != nil {
+						t.Errorf("%s %s: could not query: %s", q.qname, dns.TypeToString[q.qtype], err)
+						continue
+					}
+					if r.Rcode != q.rcode {
+						t.Errorf("%s %s: expected rcode %s, got %s", q.qname, dns.TypeToString[q.qtype], dns.RcodeToString[q.rcode], dns.RcodeToString[r.Rcode])
+					}
+					if !q.denied && len(r.Answer) == 0 {
+						t.Errorf("%s %s: expected answers", q.qname, dns.TypeToString[q.qtype])
+					}
+					if q.denied {
+						if err := VerifyDenial(r, q.qname, q.qtype); err != nil {
+							t.Errorf("%s %s: %s", q.qname, dns.TypeToString[q.qtype], err)
+						}
+					}
+					for _, err := range VerifyRRSIGs(r, zone, validate.keys) {
+						t.Errorf("%s %s with the %s keys: %s", q.qname, dns.TypeToString[q.qtype], validate.name, err)
+					}
+				}
+			}
+			if t.Failed() {
+				t.Logf("coredns log: %s", CorednsLogs())
+			}
+		})
+	}
+}
@@ -0,0 +1,202 @@
+package kubernetes
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	tap "github.com/dnstap/golang-dnstap"
+	"github.com/miekg/dns"
+)
+
+// DnstapMessage is a single dnstap message received from CoreDNS.
+type DnstapMessage struct {
+	Type     tap.Message_Type
+	Identity string
+	Extra    string
+	// Msg is the query or the response carried by the message, depending on Type.
+	// It is nil unless the dnstap plugin is configured with "full".
+	Msg *dns.Msg
+}
+
+// DnstapReceiver is a framestream listener collecting the messages sent by the CoreDNS dnstap plugin.
+type DnstapReceiver struct {
+	ln    net.Listener
+	conns map[net.Conn]struct{}
+	msgs  []DnstapMessage
+	mu    sync.Mutex
+	wg    sync.WaitGroup
+}
+
+// DnstapServer starts a dnstap receiver on the local system's ip address, so it can be reached from the
+// coredns pods. It returns the receiver and the address to use in the dnstap plugin configuration.
+func DnstapServer(t *testing.T) (*DnstapReceiver, string) {
+	ln, err := net.Listen("tcp", net.JoinHostPort(locaIP().String(), "0"))
+	if err != nil {
+		t.Fatalf("could not listen for dnstap messages: %s", err)
+	}
+	r := NewDnstapReceiver(ln)
+	return r, "tcp://" + ln.Addr().String()
+}
+
+// NewDnstapReceiver returns a DnstapReceiver accepting dnstap connections on ln.
+func NewDnstapReceiver(ln net.Listener) *DnstapReceiver {
+	r := &DnstapReceiver{ln: ln, conns: make(map[net.Conn]struct{})}
+	r.wg.Add(1)
+	go r.serve()
+	return r
+}
+
+func (r *DnstapReceiver) serve() {
+	defer r.wg.Done()
+	for {
+		conn, err := r.ln.Accept()
+		if err != nil {
+			return
+		}
+		r.mu.Lock()
+		r.conns[conn] = struct{}{}
+		r.mu.Unlock()
+
+		r.wg.Add(1)
+		go func() {
+			defer r.wg.Done()
+			defer func() {
+				r.mu.Lock()
+				delete(r.conns, conn)
+				r.mu.Unlock()
+				conn.Close()
+			}()
+			r.read(conn)
+		}()
+	}
+}
+
+// read decodes dnstap frames from conn until the connection is closed.
+func (r *DnstapReceiver) read(conn net.Conn) {
+	reader, err := tap.NewReader(conn, &tap.ReaderOptions{Bidirectional: true, Timeout: 5 * time.Second})
+	if err != nil {
+		return
+	}
+	dec := tap.NewDecoder(reader, int(tap.MaxPayloadSize))
+	for {
+		dt := new(tap.Dnstap)
+		if err := dec.Decode(dt); err != nil {
+			return
+		}
+		m, err := dnstapToMessage(dt)
+		if err != nil {
+			continue
+		}
+		r.mu.Lock()
+		r.msgs = append(r.msgs, m)
+		r.mu.Unlock()
+	}
+}
+
+// dnstapToMessage converts a decoded dnstap payload to a DnstapMessage.
+func dnstapToMessage(dt *tap.Dnstap) (DnstapMessage, error) {
+	tm := dt.GetMessage()
+	if tm == nil {
+		return DnstapMessage{}, errors.New("dnstap payload without message")
+	}
+	m := DnstapMessage{
+		Type:     tm.GetType(),
+		Identity: string(dt.GetIdentity()),
+		Extra:    string(dt.GetExtra()),
+	}
+	wire := tm.GetQueryMessage()
+	if isDnstapResponse(m.Type) {
+		wire = tm.GetResponseMessage()
+	}
+	if len(wire) == 0 {
+		return m, nil
+	}
+	m.Msg = new(dns.Msg)
+	if err := m.Msg.Unpack(wire); err != nil {
+		return DnstapMessage{}, fmt.Errorf("could not unpack %s message: %v", m.Type, err)
+	}
+	return m, nil
+}
+
+func isDnstapResponse(t tap.Message_Type) bool {
+	switch t {
+	case tap.Message_AUTH_RESPONSE, tap.Message_RESOLVER_RESPONSE, tap.Message_CLIENT_RESPONSE,
+		tap.Message_FORWARDER_RESPONSE, tap.Message_STUB_RESPONSE, tap.Message_TOOL_RESPONSE,
+		tap.Message_UPDATE_RESPONSE:
+		return true
+	}
+	return false
+}
+
+// Messages returns the messages received so far of the given types, or all messages if no type is given.
+func (r *DnstapReceiver) Messages(types ...tap.Message_Type) []DnstapMessage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var msgs []DnstapMessage
+	for _, m := range r.msgs {
+		if len(types) == 0 {
+			msgs = append(msgs, m)
+			continue
+		}
+		for _, t := range types {
+			if m.Type == t {
+				msgs = append(msgs, m)
+				break
+			}
+		}
+	}
+	return msgs
+}
+
+// Queries returns the messages of the given type that carry a question for qname and qtype.
+func (r *DnstapReceiver) Queries(typ tap.Message_Type, qname string, qtype uint16) []DnstapMessage {
+	var msgs []DnstapMessage
+	for _, m := range r.Messages(typ) {
+		if m.Msg == nil || len(m.Msg.Question) == 0 {
+			continue
+		}
+		q := m.Msg.Question[0]
+		if q.Qtype == qtype && dns.Fqdn(qname) == dns.Fqdn(q.Name) {
+			msgs = append(msgs, m)
+		}
+	}
+	return msgs
+}
+
+// WaitFor waits until at least n messages of the given types have been received, or times out after maxWait.
+// The dnstap plugin sends messages asynchronously, so tests should wait before asserting on the messages.
+func (r *DnstapReceiver) WaitFor(n int, maxWait time.Duration, types ...tap.Message_Type) ([]DnstapMessage, error) {
+	deadline := time.Now().Add(maxWait)
+	for {
+		msgs := r.Messages(types...)
+		if len(msgs) >= n {
+			return msgs, nil
+		}
+		if time.Now().After(deadline) {
+			return msgs, fmt.Errorf("timeout waiting for %d dnstap messages, received %d", n, len(msgs))
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// Reset discards all messages received so far.
+func (r *DnstapReceiver) Reset() {
+	r.mu.Lock()
+	r.msgs = nil
+	r.mu.Unlock()
+}
+
+// Stop closes the listener and all open dnstap connections.
+func (r *DnstapReceiver) Stop() {
+	r.ln.Close()
+	r.mu.Lock()
+	for c := range r.conns {
+		c.Close()
+	}
+	r.mu.Unlock()
+	r.wg.Wait()
+}
@@ -0,0 +1,97 @@
+package kubernetes
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	tap "github.com/dnstap/golang-dnstap"
+	"github.com/miekg/dns"
+)
+
+func TestDnstapReceiver(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %s", err)
+	}
+	r := NewDnstapReceiver(ln)
+	defer r.Stop()
+
+	query := new(dns.Msg)
+	query.SetQuestion("example.net.", dns.TypeA)
+	query.SetEdns0(4096, false)
+	opt := query.IsEdns0()
+	opt.Option = append(opt.Option, &dns.EDNS0_LOCAL{Code: 65518, Data: []byte{0xab, 0xcd}})
+	response := new(dns.Msg)
+	response.SetReply(query)
+	response.Answer = append(response.Answer, &dns.A{
+		Hdr: dns.RR_Header{Name: "example.net.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 303},
+		A:   net.ParseIP("13.14.15.16"),
+	})
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("could not connect to receiver: %s", err)
+	}
+	w, err := tap.NewWriter(conn, &tap.WriterOptions{Bidirectional: true, Timeout: time.Second})
+	if err != nil {
+		t.Fatalf("could not open framestream writer: %s", err)
+	}
+	enc := tap.NewEncoder(w)
+	for _, m := range []struct {
+		typ tap.Message_Type
+		msg *dns.Msg
+	}{
+		{tap.Message_CLIENT_QUERY, query},
+		{tap.Message_FORWARDER_QUERY, query},
+		{tap.Message_FORWARDER_RESPONSE, response},
+	} {
+		buf, _ := m.msg.Pack()
+		typ := m.typ
+		tm := &tap.Message{Type: &typ}
+		if isDnstapResponse(typ) {
+			tm.ResponseMessage = buf
+		} else {
+			tm.QueryMessage = buf
+		}
+		dt := tap.Dnstap_MESSAGE
+		if err := enc.Encode(&tap.Dnstap{Type: &dt, Identity: []byte("coredns"), Message: tm}); err != nil {
+			t.Fatalf("could not encode dnstap message: %s", err)
+		}
+	}
+	w.Close()
+
+	if _, err := r.WaitFor(3, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	fwd := r.Queries(tap.Message_FORWARDER_QUERY, "example.net", dns.TypeA)
+	if len(fwd) != 1 {
+		t.Fatalf("expected 1 forwarded query, got %d", len(fwd))
+	}
+	if fwd[0].Identity != "coredns" {
+		t.Errorf("expected identity %q, got %q", "coredns", fwd[0].Identity)
+	}
+
+	cq := r.Messages(tap.Message_CLIENT_QUERY)
+	if len(cq) != 1 || cq[0].Msg == nil {
+		t.Fatalf("expected 1 client query with a message, got %v", cq)
+	}
+	o := cq[0].Msg.IsEdns0()
+	if o == nil || len(o.Option) != 1 {
+		t.Fatalf("expected client query to carry 1 EDNS0 option, got %v", o)
+	}
+	if local, ok := o.Option[0].(*dns.EDNS0_LOCAL); !ok || local.Code != 65518 {
+		t.Errorf("expected EDNS0 local option 65518, got %v", o.Option[0])
+	}
+
+	resp := r.Messages(tap.Message_FORWARDER_RESPONSE)
+	if len(resp) != 1 || resp[0].Msg == nil || len(resp[0].Msg.Answer) != 1 {
+		t.Fatalf("expected 1 forwarder response with 1 answer, got %v", resp)
+	}
+
+	r.Reset()
+	if n := len(r.Messages()); n != 0 {
+		t.Errorf("expected no messages after reset, got %d", n)
+	}
+}
@@ -0,0 +1,110 @@
+package kubernetes
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/coredns/ci/test/kubernetes/fixture"
+
+	"github.com/coredns/coredns/plugin/test"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// dualStackNamespace holds the dual-stack fixtures. The addresses are in the default service and pod
+// subnets of a dual-stack kind cluster, see build/kubernetes/kind-dualstack.yaml.
+var dualStackNamespace = fixture.Namespace{
+	Services: []fixture.Service{
+		{
+			Name:       "svc-v6",
+			ClusterIPs: []string{"fd00:10:96::100"},
+			Ports:      []fixture.Port{{Name: "http", Port: 80}},
+			Endpoints:  []fixture.Endpoint{{IP: "fd00:10:244:1::10", Hostname: "svc-v6"}},
+		},
+		{
+			Name:       "svc-dual",
+			ClusterIPs: []string{"10.96.0.18", "fd00:10:96::200"},
+			Ports:      []fixture.Port{{Name: "http", Port: 80}, {Name: "https", Port: 443}},
+			Endpoints:  []fixture.Endpoint{{IP: "172.17.2.10"}, {IP: "fd00:10:244:1::20"}},
+		},
+		{
+			Name:  "headless-v6",
+			Ports: []fixture.Port{{Name: "c-port", Port: 1234}},
+			Endpoints: []fixture.Endpoint{
+				{IP: "fd00:10:244:1::30"},
+				{IP: "fd00:10:244:1::31", Hostname: "headless-v6-2"},
+			},
+		},
+		{
+			Name:      "headless-dual",
+			DualStack: true,
+			Ports:     []fixture.Port{{Name: "http", Port: 80}},
+			Selector:  map[string]string{"app": "headless-dual"},
+		},
+	},
+	Pods: []fixture.Pod{
+		{Name: "dual-1", Labels: map[string]string{"app": "headless-dual"}, Hostname: "dual-1", Subdomain: "headless-dual"},
+		{Name: "dual-2", Labels: map[string]string{"app": "headless-dual"}},
+	},
+}
+
+func TestKubernetesDualStack(t *testing.T) {
+	client, err := NewClient()
+	if err != nil {
+		t.Fatalf("could not create kubernetes client: %s", err)
+	}
+	nodes, err := client.CoreV1().Nodes().List(context.TODO(), meta.ListOptions{})
+	if err != nil {
+		t.Fatalf("could not list nodes: %s", err)
+	}
+	if len(nodes.Items) == 0 || len(nodes.Items[0].Spec.PodCIDRs) < 2 {
+		t.Skip("skipping dual-stack tests on a single-stack cluster")
+	}
+
+	ns := dualStackNamespace
+	ns.Name = NewTestNamespace(t)
+	fixtures := fixture.Set{Namespaces: []fixture.Namespace{ns}}
+
+	corefile := `    .:53 {
+        health
+        ready
+        errors
+        log
+        kubernetes cluster.local in-addr.arpa ip6.arpa {
+            namespaces ` + ns.Name + `
+            pods insecure
+        }
+    }
+`
+	err = LoadCorefile(corefile)
+	if err != nil {
+		t.Fatalf("Could not load corefile: %s", err)
+	}
+	ApplyFixtures(t, &fixtures)
+
+	cases := append(fixtures.Cases("cluster.local"), fixtures.InsecurePodCases("cluster.local")...)
+	retry := RetryPolicy{Transport: 2, Assertion: 5, Interval: time.Second}
+
+	t.Run("IPv4", func(t *testing.T) {
+		DoIntegrationCases(t, dualStackCases(cases, retry, ""), ns.Name)
+	})
+
+	t.Run("IPv6", func(t *testing.T) {
+		ips, err := CoreDNSPodIPv6s()
+		if err != nil {
+			t.Fatalf("could not get coredns pod ipv6 address: %s", err)
+		}
+		if len(ips) == 0 {
+			t.Fatalf("coredns pod has no ipv6 address")
+		}
+		DoIntegrationCases(t, dualStackCases(cases, retry, ips[0]), ns.Name)
+	})
+}
+
+func dualStackCases(tcs []test.Case, retry RetryPolicy, server string) []Case {
+	cases := make([]Case, len(tcs))
+	for i := range tcs {
+		cases[i] = Case{Case: tcs[i], Retry: retry, Server: server}
+	}
+	return cases
+}
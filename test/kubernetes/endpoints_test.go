@@ -49,13 +49,14 @@ func TestKubernetesEndpointPodNames(t *testing.T) {
 		t.Fatalf("Could not load corefile: %s", err)
 	}
 	namespace := "test-1"
+	CollectArtifacts(t, namespace)
 	err = StartClientPod(namespace)
 	if err != nil {
 		t.Fatalf("failed to start client pod: %s", err)
 	}
 	for _, expected := range tests {
 		t.Run(fmt.Sprintf("%s %s", expected.Qname, dns.TypeToString[expected.Qtype]), func(t *testing.T) {
-			result, err := DoIntegrationTest(expected.Case, namespace)
+			result, err := DoIntegrationTest(t, expected.Case, namespace)
 			if err != nil {
 				t.Error(err.Error())
 			}
@@ -0,0 +1,84 @@
+package kubernetes
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	api "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// exposeTimeout is how long ExposeCoreDNS waits for CoreDNS to answer on the node port.
+const exposeTimeout = 30 * time.Second
+
+// ExposeCoreDNS creates a NodePort service for the coredns pods, so they can be queried directly from the
+// test, instead of with dig in the client pod, and returns the UDP and TCP addresses on the node. The service
+// is deleted when t completes.
+func ExposeCoreDNS(t *testing.T) (udp, tcp string) {
+	addrs := ExposeCoreDNSPorts(t,
+		api.ServicePort{Name: "dns", Port: 53, Protocol: api.ProtocolUDP},
+		api.ServicePort{Name: "dns-tcp", Port: 53, Protocol: api.ProtocolTCP},
+	)
+	udp, tcp = addrs["dns"], addrs["dns-tcp"]
+
+	// the node port works once kube-proxy has programmed it
+	if err := WaitForServer(udp, nil, exposeTimeout); err != nil {
+		t.Fatalf("coredns not reachable on node port %s: %s", udp, err)
+	}
+	return udp, tcp
+}
+
+// ExposeCoreDNSPorts creates a NodePort service for ports of the coredns pods, and returns their addresses
+// on the node by port name. It does not wait for the node ports to work. The service is deleted when t
+// completes.
+func ExposeCoreDNSPorts(t *testing.T, ports ...api.ServicePort) map[string]string {
+	client, err := NewClient()
+	if err != nil {
+		t.Fatalf("could not create kubernetes client: %s", err)
+	}
+	ctx := context.TODO()
+
+	nodes, err := client.CoreV1().Nodes().List(ctx, meta.ListOptions{})
+	if err != nil {
+		t.Fatalf("could not list nodes: %s", err)
+	}
+	var nodeIP string
+	for _, node := range nodes.Items {
+		for _, addr := range node.Status.Addresses {
+			if addr.Type == api.NodeInternalIP && nodeIP == "" {
+				nodeIP = addr.Address
+			}
+		}
+	}
+	if nodeIP == "" {
+		t.Fatalf("could not find the internal ip of a node")
+	}
+
+	svc, err := client.CoreV1().Services("kube-system").Create(ctx, &api.Service{
+		ObjectMeta: meta.ObjectMeta{GenerateName: "coredns-nodeport-"},
+		Spec: api.ServiceSpec{
+			Type:     api.ServiceTypeNodePort,
+			Selector: map[string]string{"k8s-app": "kube-dns"},
+			Ports:    ports,
+		},
+	}, meta.CreateOptions{})
+	if err != nil {
+		t.Fatalf("could not create node port service: %s", err)
+	}
+	t.Cleanup(func() {
+		err := client.CoreV1().Services("kube-system").Delete(context.TODO(), svc.Name, meta.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			t.Errorf("could not delete node port service %s: %s", svc.Name, err)
+		}
+	})
+
+	addrs := make(map[string]string, len(svc.Spec.Ports))
+	for _, p := range svc.Spec.Ports {
+		addrs[p.Name] = net.JoinHostPort(nodeIP, strconv.Itoa(int(p.NodePort)))
+	}
+	return addrs
+}
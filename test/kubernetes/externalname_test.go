@@ -0,0 +1,201 @@
+package kubernetes
+
+import (
+	"testing"
+	"time"
+
+	"github.com/coredns/ci/test/kubernetes/fixture"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+// Upstream zones of the ExternalName tests. www.example.org is a chain within its zone, alias.example.org
+// leaves it for example.com, which is served by another upstream.
+const (
+	exampleOrg = `example.org.	IN	SOA	ns.example.org. admin.example.org. 2015082541 7200 3600 1209600 3600
+www.example.org.	IN	CNAME	web.example.org.
+web.example.org.	IN	A	13.14.15.17
+alias.example.org.	IN	CNAME	target.example.com.
+`
+	exampleCom = `example.com.	IN	SOA	ns.example.com. admin.example.com. 2015082541 7200 3600 1209600 3600
+target.example.com.	IN	A	13.14.15.18
+`
+)
+
+// externalNameNamespace is the namespace the ExternalName cases are written for, it is replaced by a
+// namespace created for the test.
+const externalNameNamespace = "test-ext"
+
+// externalNameServices returns the services of the ExternalName tests in namespace.
+func externalNameServices(namespace string) []fixture.Service {
+	svc := func(name string) string { return name + "." + namespace + ".svc.cluster.local" }
+	return []fixture.Service{
+		{Name: "svc-a", ClusterIPs: []string{"10.96.0.24"}, Ports: []fixture.Port{{Name: "http", Port: 80}}},
+		{Name: "headless-a", Endpoints: []fixture.Endpoint{{IP: "172.17.4.10"}, {IP: "172.17.4.11"}}},
+		{Name: "ext-net", ExternalName: "example.net"},
+		{Name: "ext-www", ExternalName: "www.example.org"},
+		{Name: "ext-alias", ExternalName: "alias.example.org"},
+		{Name: "ext-nx", ExternalName: "nx.example.net"},
+		{Name: "ext-svc", ExternalName: svc("svc-a")},
+		{Name: "ext-headless", ExternalName: svc("headless-a")},
+		{Name: "ext-chain", ExternalName: svc("ext-svc")},
+		{Name: "ext-self", ExternalName: svc("ext-self")},
+		{Name: "ext-loop-a", ExternalName: svc("ext-loop-b")},
+		{Name: "ext-loop-b", ExternalName: svc("ext-loop-a")},
+	}
+}
+
+var dnsTestCasesExternalName = []test.Case{
+	{ // An ExternalName is resolved by the upstream of its zone
+		Qname: "ext-net.test-ext.svc.cluster.local.", Qtype: dns.TypeA,
+		Rcode: dns.RcodeSuccess,
+		Answer: []dns.RR{
+			test.CNAME("ext-net.test-ext.svc.cluster.local. 303 IN CNAME example.net."),
+			test.A("example.net. 303 IN A 13.14.15.16"),
+		},
+	},
+	{ // A CNAME chain within the upstream zone is resolved by the upstream
+		Qname: "ext-www.test-ext.svc.cluster.local.", Qtype: dns.TypeA,
+		Rcode: dns.RcodeSuccess,
+		Answer: []dns.RR{
+			test.CNAME("ext-www.test-ext.svc.cluster.local. 303 IN CNAME www.example.org."),
+			test.CNAME("www.example.org. 303 IN CNAME web.example.org."),
+			test.A("web.example.org. 303 IN A 13.14.15.17"),
+		},
+	},
+	{ // A chain leaving the zone of the upstream is answered as far as that upstream resolves it; CoreDNS looks
+		// up the target of the ExternalName once, and does not follow the answer into the next zone
+		Qname: "ext-alias.test-ext.svc.cluster.local.", Qtype: dns.TypeA,
+		Rcode: dns.RcodeSuccess,
+		Answer: []dns.RR{
+			test.CNAME("ext-alias.test-ext.svc.cluster.local. 303 IN CNAME alias.example.org."),
+			test.CNAME("alias.example.org. 303 IN CNAME target.example.com."),
+		},
+	},
+	{ // The other zone resolves on its own
+		Qname: "target.example.com.", Qtype: dns.TypeA,
+		Rcode: dns.RcodeSuccess,
+		Answer: []dns.RR{
+			test.A("target.example.com. 303 IN A 13.14.15.18"),
+		},
+	},
+	{ // A target that does not exist leaves the CNAME, the service itself exists
+		Qname: "ext-nx.test-ext.svc.cluster.local.", Qtype: dns.TypeA,
+		Rcode: dns.RcodeSuccess,
+		Answer: []dns.RR{
+			test.CNAME("ext-nx.test-ext.svc.cluster.local. 303 IN CNAME nx.example.net."),
+		},
+	},
+	{ // An ExternalName of a service in the cluster is resolved by the kubernetes plugin
+		Qname: "ext-svc.test-ext.svc.cluster.local.", Qtype: dns.TypeA,
+		Rcode: dns.RcodeSuccess,
+		Answer: []dns.RR{
+			test.CNAME("ext-svc.test-ext.svc.cluster.local. 303 IN CNAME svc-a.test-ext.svc.cluster.local."),
+			test.A("svc-a.test-ext.svc.cluster.local. 303 IN A 10.96.0.24"),
+		},
+	},
+	{
+		Qname: "ext-headless.test-ext.svc.cluster.local.", Qtype: dns.TypeA,
+		Rcode: dns.RcodeSuccess,
+		Answer: []dns.RR{
+			test.CNAME("ext-headless.test-ext.svc.cluster.local. 303 IN CNAME headless-a.test-ext.svc.cluster.local."),
+			test.A("headless-a.test-ext.svc.cluster.local. 303 IN A 172.17.4.10"),
+			test.A("headless-a.test-ext.svc.cluster.local. 303 IN A 172.17.4.11"),
+		},
+	},
+	{ // A chain of ExternalName services in the cluster is followed to the end
+		Qname: "ext-chain.test-ext.svc.cluster.local.", Qtype: dns.TypeA,
+		Rcode: dns.RcodeSuccess,
+		Answer: []dns.RR{
+			test.CNAME("ext-chain.test-ext.svc.cluster.local. 303 IN CNAME ext-svc.test-ext.svc.cluster.local."),
+			test.CNAME("ext-svc.test-ext.svc.cluster.local. 303 IN CNAME svc-a.test-ext.svc.cluster.local."),
+			test.A("svc-a.test-ext.svc.cluster.local. 303 IN A 10.96.0.24"),
+		},
+	},
+	{ // An ExternalName pointing to itself is a loop, which has no answer
+		Qname: "ext-self.test-ext.svc.cluster.local.", Qtype: dns.TypeA,
+		Rcode: dns.RcodeSuccess,
+		Ns: []dns.RR{
+			test.SOA("cluster.local.	303	IN	SOA	ns.dns.cluster.local. hostmaster.cluster.local. 1502313310 7200 1800 86400 30"),
+		},
+	},
+	{ // A loop through two services has no answer either
+		Qname: "ext-loop-a.test-ext.svc.cluster.local.", Qtype: dns.TypeA,
+		Rcode: dns.RcodeSuccess,
+		Ns: []dns.RR{
+			test.SOA("cluster.local.	303	IN	SOA	ns.dns.cluster.local. hostmaster.cluster.local. 1502313310 7200 1800 86400 30"),
+		},
+	},
+	{ // An ExternalName is its own SRV target, without a port, with the addresses of the target
+		Qname: "ext-net.test-ext.svc.cluster.local.", Qtype: dns.TypeSRV,
+		Rcode: dns.RcodeSuccess,
+		Answer: []dns.RR{
+			test.SRV("ext-net.test-ext.svc.cluster.local. 303 IN SRV 0 100 0 example.net."),
+		},
+		Extra: []dns.RR{
+			test.A("example.net. 303 IN A 13.14.15.16"),
+		},
+	},
+	{ // An ExternalName has no ports, so no port SRV records
+		Qname: "_http._tcp.ext-net.test-ext.svc.cluster.local.", Qtype: dns.TypeSRV,
+		Rcode: dns.RcodeNameError,
+		Ns: []dns.RR{
+			test.SOA("cluster.local.	303	IN	SOA	ns.dns.cluster.local. hostmaster.cluster.local. 1502313310 7200 1800 86400 30"),
+		},
+	},
+	{ // The CNAME record of an ExternalName
+		Qname: "ext-www.test-ext.svc.cluster.local.", Qtype: dns.TypeCNAME,
+		Rcode: dns.RcodeSuccess,
+		Answer: []dns.RR{
+			test.CNAME("ext-www.test-ext.svc.cluster.local. 303 IN CNAME www.example.org."),
+		},
+	},
+}
+
+// TestKubernetesExternalName resolves ExternalName services to names outside and inside the cluster, with local
+// CoreDNS instances as stand-ins for the authoritative servers of the external zones.
+func TestKubernetesExternalName(t *testing.T) {
+	rmNet, upNet, udpNet := UpstreamServer(t, "example.net", ExampleNet)
+	defer upNet.Stop()
+	defer rmNet()
+	rmOrg, upOrg, udpOrg := UpstreamServer(t, "example.org", exampleOrg)
+	defer upOrg.Stop()
+	defer rmOrg()
+	rmCom, upCom, udpCom := UpstreamServer(t, "example.com", exampleCom)
+	defer upCom.Stop()
+	defer rmCom()
+
+	namespace := NewTestNamespace(t)
+	corefile := `    example.org:53 {
+        errors
+        forward . ` + udpOrg + `
+    }
+    example.com:53 {
+        errors
+        forward . ` + udpCom + `
+    }
+    .:53 {
+        health
+        ready
+        errors
+        log
+        kubernetes cluster.local {
+            namespaces ` + namespace + `
+        }
+        forward . ` + udpNet + `
+    }
+`
+	err := LoadCorefile(corefile)
+	if err != nil {
+		t.Fatalf("Could not load corefile: %s", err)
+	}
+	ApplyFixtures(t, &fixture.Set{Namespaces: []fixture.Namespace{{Name: namespace, Services: externalNameServices(namespace)}}})
+
+	// the fixtures are ready, but CoreDNS may not have seen them yet
+	var cases []Case
+	for _, tc := range NamespacedCases(dnsTestCasesExternalName, externalNameNamespace, namespace) {
+		cases = append(cases, Case{Case: tc, Retry: RetryPolicy{Transport: 2, Assertion: 5, Interval: time.Second}})
+	}
+	DoIntegrationCases(t, cases, namespace)
+}
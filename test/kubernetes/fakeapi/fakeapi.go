@@ -0,0 +1,182 @@
+// Package fakeapi serves the objects of a fake clientset over HTTP as a Kubernetes API server, so CoreDNS
+// can run in-process against objects that only exist in memory. Only the cluster wide list and watch requests
+// CoreDNS makes for services, pods, EndpointSlices and namespaces are supported.
+package fakeapi
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	api "k8s.io/api/core/v1"
+	discovery "k8s.io/api/discovery/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// Server is a Kubernetes API server backed by a clientset.
+type Server struct {
+	codec runtime.Codec
+	srv   *httptest.Server
+}
+
+// resource is how a resource is listed and watched.
+type resource struct {
+	list  func(ctx context.Context, opts meta.ListOptions) (runtime.Object, error)
+	watch func(ctx context.Context, opts meta.ListOptions) (watch.Interface, error)
+}
+
+// New starts a server for the objects of client, which is typically a fake clientset. Watches only see
+// changes made after they started, so objects should be created before CoreDNS lists them.
+func New(client kubernetes.Interface) *Server {
+	s := &Server{codec: scheme.Codecs.LegacyCodec(api.SchemeGroupVersion, discovery.SchemeGroupVersion)}
+	s.srv = httptest.NewServer(s.mux(client))
+	return s
+}
+
+// Listen is like New, but serves on addr instead of a loopback address, so CoreDNS running elsewhere,
+// e.g. in a pod of the cluster, can reach the server.
+func Listen(client kubernetes.Interface, addr string) (*Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{codec: scheme.Codecs.LegacyCodec(api.SchemeGroupVersion, discovery.SchemeGroupVersion)}
+	s.srv = httptest.NewUnstartedServer(s.mux(client))
+	s.srv.Listener.Close()
+	s.srv.Listener = ln
+	s.srv.Start()
+	return s, nil
+}
+
+func (s *Server) mux(client kubernetes.Interface) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/api/v1/services", s.handler(resource{
+		list: func(ctx context.Context, opts meta.ListOptions) (runtime.Object, error) {
+			return client.CoreV1().Services(api.NamespaceAll).List(ctx, opts)
+		},
+		watch: client.CoreV1().Services(api.NamespaceAll).Watch,
+	}))
+	mux.Handle("/api/v1/pods", s.handler(resource{
+		list: func(ctx context.Context, opts meta.ListOptions) (runtime.Object, error) {
+			return client.CoreV1().Pods(api.NamespaceAll).List(ctx, opts)
+		},
+		watch: client.CoreV1().Pods(api.NamespaceAll).Watch,
+	}))
+	mux.Handle("/api/v1/namespaces", s.handler(resource{
+		list: func(ctx context.Context, opts meta.ListOptions) (runtime.Object, error) {
+			return client.CoreV1().Namespaces().List(ctx, opts)
+		},
+		watch: client.CoreV1().Namespaces().Watch,
+	}))
+	mux.Handle("/apis/discovery.k8s.io/v1/endpointslices", s.handler(resource{
+		list: func(ctx context.Context, opts meta.ListOptions) (runtime.Object, error) {
+			return client.DiscoveryV1().EndpointSlices(api.NamespaceAll).List(ctx, opts)
+		},
+		watch: client.DiscoveryV1().EndpointSlices(api.NamespaceAll).Watch,
+	}))
+	return mux
+}
+
+// URL returns the URL of the server.
+func (s *Server) URL() string { return s.srv.URL }
+
+// Close stops the server. Open watches are closed, so the server can be stopped while CoreDNS still runs.
+func (s *Server) Close() {
+	s.srv.CloseClientConnections()
+	s.srv.Close()
+}
+
+// WriteKubeconfig writes a kubeconfig for the server to path, for use with the kubeconfig option of
+// the kubernetes plugin.
+func (s *Server) WriteKubeconfig(path string) error {
+	return clientcmd.WriteToFile(*s.Kubeconfig(), path)
+}
+
+// Kubeconfig returns a kubeconfig with a context named "fake" for the server, which is the current context.
+func (s *Server) Kubeconfig() *clientcmdapi.Config {
+	config := clientcmdapi.NewConfig()
+	config.Clusters["fake"] = &clientcmdapi.Cluster{Server: s.srv.URL}
+	config.AuthInfos["fake"] = &clientcmdapi.AuthInfo{}
+	config.Contexts["fake"] = &clientcmdapi.Context{Cluster: "fake", AuthInfo: "fake"}
+	config.CurrentContext = "fake"
+	return config
+}
+
+func (s *Server) handler(r resource) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var opts meta.ListOptions
+		if err := scheme.ParameterCodec.DecodeParameters(req.URL.Query(), api.SchemeGroupVersion, &opts); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if opts.Watch {
+			s.watch(w, req, r, opts)
+			return
+		}
+		obj, err := r.list(req.Context(), opts)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		data, err := runtime.Encode(s.codec, obj)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", runtime.ContentTypeJSON)
+		w.Write(data)
+	})
+}
+
+// watch streams the events of a watch as JSON until the client goes away or the timeout of the request expires.
+func (s *Server) watch(w http.ResponseWriter, req *http.Request, r resource, opts meta.ListOptions) {
+	ctx := req.Context()
+	if opts.TimeoutSeconds != nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(*opts.TimeoutSeconds)*time.Second)
+		defer cancel()
+	}
+	wi, err := r.watch(ctx, opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer wi.Stop()
+
+	w.Header().Set("Content-Type", runtime.ContentTypeJSON)
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+	enc := json.NewEncoder(w)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev, ok := <-wi.ResultChan():
+			if !ok {
+				return
+			}
+			data, err := runtime.Encode(s.codec, ev.Object)
+			if err != nil {
+				return
+			}
+			if err := enc.Encode(meta.WatchEvent{Type: string(ev.Type), Object: runtime.RawExtension{Raw: data}}); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+}
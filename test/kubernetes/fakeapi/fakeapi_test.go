@@ -0,0 +1,88 @@
+package fakeapi
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	api "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+func TestServer(t *testing.T) {
+	ctx := context.Background()
+	backend := fake.NewSimpleClientset(&api.Service{ObjectMeta: meta.ObjectMeta{Namespace: "ns", Name: "svc-1"}})
+	s := New(backend)
+	defer s.Close()
+
+	path := filepath.Join(t.TempDir(), "kubeconfig")
+	if err := s.WriteKubeconfig(path); err != nil {
+		t.Fatalf("could not write kubeconfig: %s", err)
+	}
+	config, err := clientcmd.BuildConfigFromFlags("", path)
+	if err != nil {
+		t.Fatalf("could not load kubeconfig: %s", err)
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		t.Fatalf("could not create client: %s", err)
+	}
+
+	list, err := client.CoreV1().Services(api.NamespaceAll).List(ctx, meta.ListOptions{})
+	if err != nil {
+		t.Fatalf("could not list services: %s", err)
+	}
+	if len(list.Items) != 1 || list.Items[0].Name != "svc-1" {
+		t.Fatalf("expected service svc-1, got %v", list.Items)
+	}
+
+	timeout := int64(10)
+	w, err := client.CoreV1().Services(api.NamespaceAll).Watch(ctx, meta.ListOptions{TimeoutSeconds: &timeout})
+	if err != nil {
+		t.Fatalf("could not watch services: %s", err)
+	}
+	defer w.Stop()
+	// the watch is established once the response headers are received, so changes after this are seen
+	_, err = backend.CoreV1().Services("ns").Create(ctx, &api.Service{ObjectMeta: meta.ObjectMeta{Namespace: "ns", Name: "svc-2"}}, meta.CreateOptions{})
+	if err != nil {
+		t.Fatalf("could not create service: %s", err)
+	}
+	select {
+	case ev := <-w.ResultChan():
+		svc, ok := ev.Object.(*api.Service)
+		if !ok || svc.Name != "svc-2" {
+			t.Errorf("expected event for service svc-2, got %s %v", ev.Type, ev.Object)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("timeout waiting for watch event")
+	}
+}
+
+func TestListen(t *testing.T) {
+	backend := fake.NewSimpleClientset(&api.Service{ObjectMeta: meta.ObjectMeta{Namespace: "ns", Name: "svc-1"}})
+	s, err := Listen(backend, "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not start server: %s", err)
+	}
+	defer s.Close()
+
+	config, err := clientcmd.NewDefaultClientConfig(*s.Kubeconfig(), &clientcmd.ConfigOverrides{}).ClientConfig()
+	if err != nil {
+		t.Fatalf("could not load kubeconfig: %s", err)
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		t.Fatalf("could not create client: %s", err)
+	}
+	list, err := client.CoreV1().Services(api.NamespaceAll).List(context.Background(), meta.ListOptions{})
+	if err != nil {
+		t.Fatalf("could not list services: %s", err)
+	}
+	if len(list.Items) != 1 || list.Items[0].Name != "svc-1" {
+		t.Fatalf("expected service svc-1, got %v", list.Items)
+	}
+}
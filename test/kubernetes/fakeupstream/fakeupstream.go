@@ -0,0 +1,178 @@
+// Package fakeupstream is a DNS server whose behavior is scripted per query, to play an upstream of the
+// forward plugin that is slow, drops queries, fails, or only answers over TCP. It serves the same port over
+// UDP and TCP, and logs the queries it receives, including the health checks of the forward plugin.
+package fakeupstream
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Query is a query the server received.
+type Query struct {
+	Name string
+	Type uint16
+	Net  string // udp or tcp
+	At   time.Time
+}
+
+// HealthCheck returns whether q is a health check of the forward plugin, a query for the NS records of the root.
+func (q Query) HealthCheck() bool { return q.Name == "." && q.Type == dns.TypeNS }
+
+// Behavior is what the server does with a query. The zero Behavior answers it with the records of the server.
+type Behavior struct {
+	Delay    time.Duration // wait before responding, or dropping
+	Drop     bool          // do not respond
+	Rcode    int           // respond with this rcode and no records, if it is not success
+	Truncate bool          // respond to a query over UDP with the TC bit and no records
+}
+
+// Script returns the behavior for a query.
+type Script func(q Query) Behavior
+
+// Always returns a script with the same behavior for every query.
+func Always(b Behavior) Script { return func(Query) Behavior { return b } }
+
+// Sequence returns a script with the behaviors in turn, one per query, and the last one for the queries after.
+// Health checks are queries too.
+func Sequence(bs ...Behavior) Script {
+	var mu sync.Mutex
+	n := 0
+	return func(Query) Behavior {
+		mu.Lock()
+		defer mu.Unlock()
+		b := bs[min(n, len(bs)-1)]
+		n++
+		return b
+	}
+}
+
+// Server is a DNS server with a scripted behavior.
+type Server struct {
+	Addr string // host:port of both the UDP and the TCP server
+
+	udp, tcp *dns.Server
+	records  []dns.RR
+
+	mu      sync.Mutex
+	script  Script
+	queries []Query
+}
+
+// Start starts a server on addr, which may have port 0 to pick a port free for both UDP and TCP, that
+// answers queries with records, and NXDOMAIN for names without records. Health checks are answered
+// without records. Its behavior is that of the zero Behavior until it is changed with SetScript.
+func Start(addr string, records []dns.RR) (*Server, error) {
+	s := &Server{records: records, script: Always(Behavior{})}
+
+	var pc net.PacketConn
+	var l net.Listener
+	var err error
+	for i := 0; i < 10; i++ {
+		pc, err = net.ListenPacket("udp", addr)
+		if err != nil {
+			return nil, err
+		}
+		l, err = net.Listen("tcp", pc.LocalAddr().String())
+		if err == nil {
+			break
+		}
+		// the port picked for UDP is taken for TCP, try another one
+		pc.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.Addr = pc.LocalAddr().String()
+
+	s.udp = &dns.Server{PacketConn: pc, Net: "udp", Handler: dns.HandlerFunc(s.serve)}
+	s.tcp = &dns.Server{Listener: l, Net: "tcp", Handler: dns.HandlerFunc(s.serve)}
+	for _, srv := range []*dns.Server{s.udp, s.tcp} {
+		started := make(chan struct{})
+		srv.NotifyStartedFunc = func() { close(started) }
+		go srv.ActivateAndServe()
+		<-started
+	}
+	return s, nil
+}
+
+// SetScript sets the script the behavior for the next queries is taken from.
+func (s *Server) SetScript(script Script) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.script = script
+}
+
+// Queries returns the queries received so far, in the order they were received.
+func (s *Server) Queries() []Query {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Query(nil), s.queries...)
+}
+
+// Reset forgets the queries received so far.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queries = nil
+}
+
+// Close stops the server.
+func (s *Server) Close() error {
+	return errors.Join(s.udp.Shutdown(), s.tcp.Shutdown())
+}
+
+func (s *Server) serve(w dns.ResponseWriter, r *dns.Msg) {
+	if len(r.Question) != 1 {
+		return
+	}
+	q := Query{Name: r.Question[0].Name, Type: r.Question[0].Qtype, Net: w.LocalAddr().Network(), At: time.Now()}
+
+	s.mu.Lock()
+	s.queries = append(s.queries, q)
+	script := s.script
+	s.mu.Unlock()
+
+	b := script(q)
+	time.Sleep(b.Delay)
+	if b.Drop {
+		return
+	}
+
+	m := new(dns.Msg)
+	m.SetReply(r)
+	m.Authoritative = true
+	switch {
+	case b.Truncate && q.Net == "udp":
+		m.Truncated = true
+	case b.Rcode != dns.RcodeSuccess:
+		m.Rcode = b.Rcode
+	case q.HealthCheck():
+	default:
+		var found bool
+		m.Answer, found = s.answer(q)
+		if !found {
+			m.Rcode = dns.RcodeNameError
+		}
+	}
+	w.WriteMsg(m)
+}
+
+// answer returns the records for q, and whether the server has records for its name.
+func (s *Server) answer(q Query) (rrs []dns.RR, found bool) {
+	for _, rr := range s.records {
+		if !strings.EqualFold(rr.Header().Name, q.Name) {
+			continue
+		}
+		found = true
+		if rr.Header().Rrtype == q.Type {
+			rrs = append(rrs, dns.Copy(rr))
+		}
+	}
+	return rrs, found
+}
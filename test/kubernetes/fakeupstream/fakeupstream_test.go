@@ -0,0 +1,116 @@
+package fakeupstream
+
+import (
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+func exchange(t *testing.T, s *Server, net, name string, qtype uint16) (*dns.Msg, time.Duration, error) {
+	t.Helper()
+	m := new(dns.Msg)
+	m.SetQuestion(name, qtype)
+	c := &dns.Client{Net: net, Timeout: 500 * time.Millisecond}
+	r, rtt, err := c.Exchange(m, s.Addr)
+	return r, rtt, err
+}
+
+func TestServer(t *testing.T) {
+	s, err := Start("127.0.0.1:0", []dns.RR{test.A("example.net. 5 IN A 192.0.2.1")})
+	if err != nil {
+		t.Fatalf("could not start server: %s", err)
+	}
+	defer s.Close()
+
+	tests := []struct {
+		name     string
+		behavior Behavior
+		net      string
+		qname    string
+		qtype    uint16
+		rcode    int
+		answers  int
+		tc       bool
+		timeout  bool
+	}{
+		{"answer udp", Behavior{}, "udp", "example.net.", dns.TypeA, dns.RcodeSuccess, 1, false, false},
+		{"answer tcp", Behavior{}, "tcp", "example.net.", dns.TypeA, dns.RcodeSuccess, 1, false, false},
+		{"no data", Behavior{}, "udp", "example.net.", dns.TypeAAAA, dns.RcodeSuccess, 0, false, false},
+		{"nxdomain", Behavior{}, "udp", "none.example.net.", dns.TypeA, dns.RcodeNameError, 0, false, false},
+		{"health check", Behavior{}, "udp", ".", dns.TypeNS, dns.RcodeSuccess, 0, false, false},
+		{"servfail", Behavior{Rcode: dns.RcodeServerFailure}, "udp", "example.net.", dns.TypeA, dns.RcodeServerFailure, 0, false, false},
+		{"truncated udp", Behavior{Truncate: true}, "udp", "example.net.", dns.TypeA, dns.RcodeSuccess, 0, true, false},
+		{"truncate answers tcp", Behavior{Truncate: true}, "tcp", "example.net.", dns.TypeA, dns.RcodeSuccess, 1, false, false},
+		{"drop udp", Behavior{Drop: true}, "udp", "example.net.", dns.TypeA, 0, 0, false, true},
+		{"drop tcp", Behavior{Drop: true}, "tcp", "example.net.", dns.TypeA, 0, 0, false, true},
+		{"too slow", Behavior{Delay: time.Second}, "udp", "example.net.", dns.TypeA, 0, 0, false, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s.SetScript(Always(tc.behavior))
+			s.Reset()
+			r, _, err := exchange(t, s, tc.net, tc.qname, tc.qtype)
+			if tc.timeout {
+				if err == nil {
+					t.Fatalf("expected a timeout, got %s", r)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("expected a response, got %s", err)
+				}
+				if r.Rcode != tc.rcode || len(r.Answer) != tc.answers || r.Truncated != tc.tc {
+					t.Errorf("expected rcode %s with %d answers and TC %t, got %s", dns.RcodeToString[tc.rcode], tc.answers, tc.tc, r)
+				}
+			}
+			queries := s.Queries()
+			if len(queries) != 1 || queries[0].Name != tc.qname || queries[0].Type != tc.qtype || queries[0].Net != tc.net {
+				t.Errorf("expected a %s query for %s over %s, got %v", dns.TypeToString[tc.qtype], tc.qname, tc.net, queries)
+			}
+			if queries[0].HealthCheck() != (tc.name == "health check") {
+				t.Errorf("expected health check %t, got %t", tc.name == "health check", queries[0].HealthCheck())
+			}
+		})
+	}
+}
+
+func TestDelay(t *testing.T) {
+	s, err := Start("127.0.0.1:0", []dns.RR{test.A("example.net. 5 IN A 192.0.2.1")})
+	if err != nil {
+		t.Fatalf("could not start server: %s", err)
+	}
+	defer s.Close()
+
+	s.SetScript(Always(Behavior{Delay: 200 * time.Millisecond}))
+	_, rtt, err := exchange(t, s, "udp", "example.net.", dns.TypeA)
+	if err != nil {
+		t.Fatalf("expected a response, got %s", err)
+	}
+	if rtt < 200*time.Millisecond {
+		t.Errorf("expected a response after 200ms, got one after %s", rtt)
+	}
+}
+
+func TestSequence(t *testing.T) {
+	s, err := Start("127.0.0.1:0", []dns.RR{test.A("example.net. 5 IN A 192.0.2.1")})
+	if err != nil {
+		t.Fatalf("could not start server: %s", err)
+	}
+	defer s.Close()
+
+	s.SetScript(Sequence(Behavior{Rcode: dns.RcodeServerFailure}, Behavior{Rcode: dns.RcodeRefused}, Behavior{}))
+	for i, rcode := range []int{dns.RcodeServerFailure, dns.RcodeRefused, dns.RcodeSuccess, dns.RcodeSuccess} {
+		r, _, err := exchange(t, s, "udp", "example.net.", dns.TypeA)
+		if err != nil {
+			t.Fatalf("query %d: expected a response, got %s", i, err)
+		}
+		if r.Rcode != rcode {
+			t.Errorf("query %d: expected rcode %s, got %s", i, dns.RcodeToString[rcode], dns.RcodeToString[r.Rcode])
+		}
+	}
+	if n := len(s.Queries()); n != 4 {
+		t.Errorf("expected 4 queries, got %d", n)
+	}
+}
@@ -9,6 +9,7 @@ import (
 
 	"github.com/coredns/coredns/plugin/test"
 
+	tap "github.com/dnstap/golang-dnstap"
 	"github.com/miekg/dns"
 )
 
@@ -73,22 +74,76 @@ func TestKubernetesFallthrough(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Could not load corefile/zonefile: %s", err)
 	}
-	testCases := dnsTestCasesFallthrough
+	DoIntegrationTests(t, dnsTestCasesFallthrough, "test-1")
+}
+
+func TestKubernetesFallthroughForwarded(t *testing.T) {
+
+	rmFunc, upstream, udp := UpstreamServer(t, "example.net", ExampleNet)
+	defer upstream.Stop()
+	defer rmFunc()
+
+	receiver, tapAddr := DnstapServer(t)
+	defer receiver.Stop()
+
+	corefile := `    .:53 {
+      health
+      ready
+      errors
+      log
+      dnstap ` + tapAddr + ` full
+      file /etc/coredns/Zonefile cluster.local
+      kubernetes cluster.local {
+          namespaces test-1
+          fallthrough
+      }
+      forward . ` + udp + `
+    }
+`
+	err := LoadCorefileAndZonefile(corefile, clusterLocal, true)
+	if err != nil {
+		t.Fatalf("Could not load corefile/zonefile: %s", err)
+	}
 	namespace := "test-1"
+	CollectArtifacts(t, namespace)
 	err = StartClientPod(namespace)
 	if err != nil {
 		t.Fatalf("failed to start client pod: %s", err)
 	}
-	for _, tc := range testCases {
+
+	tests := []struct {
+		test.Case
+		forwarded []string // names expected to be forwarded upstream
+	}{
+		{Case: dnsTestCasesFallthrough[0], forwarded: []string{"example.net."}}, // ExternalName target is resolved upstream
+		{Case: dnsTestCasesFallthrough[1]},                                      // answered by the file plugin after fallthrough
+		{Case: dnsTestCasesFallthrough[2]},
+	}
+	for _, tc := range tests {
 		t.Run(fmt.Sprintf("%s %s", tc.Qname, dns.TypeToString[tc.Qtype]), func(t *testing.T) {
-			res, err := DoIntegrationTest(tc, namespace)
+			receiver.Reset()
+			res, err := DoIntegrationTest(t, tc.Case, namespace)
 			if err != nil {
-				t.Error(err.Error())
+				t.Fatal(err.Error())
 			}
 			test.CNAMEOrder(res)
-			if err := test.SortAndCheck(res, tc); err != nil {
+			if err := test.SortAndCheck(res, tc.Case); err != nil {
+				t.Error(err)
+			}
+
+			// wait for the client response, which is sent after any forwarded query
+			if _, err := receiver.WaitFor(1, 5*time.Second, tap.Message_CLIENT_RESPONSE); err != nil {
 				t.Error(err)
 			}
+			fwd := receiver.Messages(tap.Message_FORWARDER_QUERY)
+			if len(fwd) != len(tc.forwarded) {
+				t.Errorf("expected %d forwarded queries, got %d: %v", len(tc.forwarded), len(fwd), fwd)
+			}
+			for _, name := range tc.forwarded {
+				if len(receiver.Queries(tap.Message_FORWARDER_QUERY, name, tc.Qtype)) == 0 {
+					t.Errorf("expected a forwarded query for %s %s", name, dns.TypeToString[tc.Qtype])
+				}
+			}
 			if t.Failed() {
 				t.Errorf("coredns log: %s", CorednsLogs())
 			}
@@ -134,27 +189,7 @@ func TestKubernetesFallthroughFiltered(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Could not load corefile/zonefile: %s", err)
 	}
-	testCases := dnsTestCasesFallthroughFiltered
-	namespace := "test-1"
-	err = StartClientPod(namespace)
-	if err != nil {
-		t.Fatalf("failed to start client pod: %s", err)
-	}
-	for _, tc := range testCases {
-		t.Run(fmt.Sprintf("%s %s", tc.Qname, dns.TypeToString[tc.Qtype]), func(t *testing.T) {
-			res, err := DoIntegrationTest(tc, namespace)
-			if err != nil {
-				t.Error(err.Error())
-			}
-			test.CNAMEOrder(res)
-			if err := test.SortAndCheck(res, tc); err != nil {
-				t.Error(err)
-			}
-			if t.Failed() {
-				t.Errorf("coredns log: %s", CorednsLogs())
-			}
-		})
-	}
+	DoIntegrationTests(t, dnsTestCasesFallthroughFiltered, "test-1")
 }
 
 const clusterLocal = `    ; cluster.local test file for fallthrough
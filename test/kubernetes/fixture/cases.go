@@ -0,0 +1,346 @@
+package fixture
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+	api "k8s.io/api/core/v1"
+)
+
+// TTL is the TTL of derived records. 303 tells test.Section to not check the TTL.
+const TTL = 303
+
+// Cases returns the test cases for the A, AAAA, SRV, PTR and CNAME records of the services and endpoints of the set
+// in zone. The PTR cases require the Corefile to serve the reverse zones of the addresses.
+// Endpoints of services with a Selector are derived from the ready pods of the namespace with a known IP.
+func (s *Set) Cases(zone string) []test.Case {
+	zone = dns.Fqdn(zone)
+	var cases []test.Case
+	for _, ns := range s.Namespaces {
+		for _, svc := range ns.Services {
+			cases = append(cases, svc.cases(ns, zone)...)
+		}
+	}
+	return cases
+}
+
+// PodCases returns the test cases for the A or AAAA records of the primary IP of the pods of the set with
+// a known IP in zone, as served with "pods insecure" or "pods verified".
+func (s *Set) PodCases(zone string) []test.Case {
+	return s.podCases(zone, func(pod Pod) []string {
+		if pod.IP == "" {
+			return nil
+		}
+		return []string{pod.IP}
+	})
+}
+
+// InsecurePodCases returns the test cases for the A and AAAA records of all IPs of the pods of the set in zone,
+// as served with "pods insecure". With "pods verified" only the records of the primary IPs are served.
+func (s *Set) InsecurePodCases(zone string) []test.Case {
+	return s.podCases(zone, func(pod Pod) []string { return pod.IPs })
+}
+
+func (s *Set) podCases(zone string, ips func(Pod) []string) []test.Case {
+	zone = dns.Fqdn(zone)
+	var cases []test.Case
+	for _, ns := range s.Namespaces {
+		for _, pod := range ns.Pods {
+			for _, ip := range ips(pod) {
+				name := dashed(ip) + "." + ns.Name + ".pod." + zone
+				cases = append(cases, test.Case{
+					Qname: name, Qtype: qtype(ip),
+					Rcode:  dns.RcodeSuccess,
+					Answer: []dns.RR{address(name, ip)},
+				})
+			}
+		}
+	}
+	return cases
+}
+
+// TransferRecords returns the A, AAAA, SRV and CNAME records of the services of the set in a zone transfer of zone.
+// Unlike queries, a transfer has no records for the endpoints of ClusterIP services, and no SRV records for
+// the service name of headless services, nor for unnamed ports of their endpoints.
+func (s *Set) TransferRecords(zone string) []dns.RR {
+	zone = dns.Fqdn(zone)
+	var rrs []dns.RR
+	for _, ns := range s.Namespaces {
+		for _, svc := range ns.Services {
+			rrs = append(rrs, svc.transferRecords(ns, zone)...)
+		}
+	}
+	return rrs
+}
+
+func (svc Service) transferRecords(ns Namespace, zone string) []dns.RR {
+	name := svc.Name + "." + ns.Name + ".svc." + zone
+	if svc.ExternalName != "" {
+		return []dns.RR{cname(name, svc.ExternalName)}
+	}
+	var rrs []dns.RR
+	if !svc.headless() {
+		for _, ip := range svc.ClusterIPs {
+			rrs = append(rrs, address(name, ip))
+		}
+		for _, p := range svc.Ports {
+			rrs = append(rrs, test.SRV(fmt.Sprintf("%s %d IN SRV 0 100 %d %s", name, TTL, p.Port, name)))
+			if p.Name != "" {
+				qname := "_" + p.Name + "._" + strings.ToLower(string(p.protocol())) + "." + name
+				rrs = append(rrs, test.SRV(fmt.Sprintf("%s %d IN SRV 0 100 %d %s", qname, TTL, p.Port, name)))
+			}
+		}
+		return rrs
+	}
+
+	// the weight is divided over the endpoints of an EndpointSlice, which has the endpoints of one family
+	endpoints := svc.readyEndpoints(ns)
+	perFamily := map[api.IPFamily]int{}
+	for _, ep := range endpoints {
+		perFamily[family(ep.IP)]++
+	}
+	for _, ep := range endpoints {
+		target := endpointHostname(ep) + "." + name
+		rrs = append(rrs, address(name, ep.IP), address(target, ep.IP))
+		weight := 100 / perFamily[family(ep.IP)]
+		if weight == 0 {
+			weight = 1
+		}
+		for _, p := range svc.Ports {
+			if p.Name != "" {
+				qname := "_" + p.Name + "._" + strings.ToLower(string(p.protocol())) + "." + name
+				rrs = append(rrs, test.SRV(fmt.Sprintf("%s %d IN SRV 0 %d %d %s", qname, TTL, weight, p.targetPort(), target)))
+			}
+		}
+	}
+	return rrs
+}
+
+// backend is an address and port that answers for a service in an SRV record.
+type backend struct {
+	target string
+	ip     string
+	port   int32
+}
+
+func (svc Service) cases(ns Namespace, zone string) []test.Case {
+	name := svc.Name + "." + ns.Name + ".svc." + zone
+	if svc.ExternalName != "" {
+		return []test.Case{{
+			Qname: name, Qtype: dns.TypeCNAME,
+			Rcode:  dns.RcodeSuccess,
+			Answer: []dns.RR{cname(name, svc.ExternalName)},
+		}}
+	}
+	endpoints := svc.readyEndpoints(ns)
+
+	var cases []test.Case
+	var addrs, portAddrs []string
+	if svc.headless() {
+		if len(endpoints) == 0 {
+			return []test.Case{{
+				Qname: name, Qtype: dns.TypeA,
+				Rcode: dns.RcodeNameError,
+				Ns:    []dns.RR{soa(zone)},
+			}}
+		}
+		for _, ep := range endpoints {
+			addrs = append(addrs, ep.IP)
+		}
+	} else {
+		addrs = svc.ClusterIPs
+		portAddrs = svc.ClusterIPs
+	}
+
+	for _, qt := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		tc := test.Case{Qname: name, Qtype: qt, Rcode: dns.RcodeSuccess}
+		for _, ip := range addrs {
+			if qtype(ip) == qt {
+				tc.Answer = append(tc.Answer, address(name, ip))
+			}
+		}
+		if len(tc.Answer) > 0 {
+			cases = append(cases, tc)
+		}
+	}
+
+	// SRV records for the service, and for each of its named ports.
+	var all []backend
+	for _, p := range svc.Ports {
+		var backends []backend
+		if svc.headless() {
+			for _, ep := range endpoints {
+				backends = append(backends, backend{target: endpointHostname(ep) + "." + name, ip: ep.IP, port: p.targetPort()})
+			}
+		} else {
+			for _, ip := range portAddrs {
+				backends = append(backends, backend{target: name, ip: ip, port: p.Port})
+			}
+		}
+		all = append(all, backends...)
+		if p.Name != "" {
+			qname := "_" + p.Name + "._" + strings.ToLower(string(p.protocol())) + "." + name
+			cases = append(cases, srvCase(qname, backends))
+		}
+	}
+	if len(all) > 0 {
+		cases = append(cases, srvCase(name, all))
+	}
+
+	for _, ip := range svc.ClusterIPs {
+		cases = append(cases, ptrCase(ip, name))
+	}
+
+	// Records for the individual endpoints, which are served for headless and ClusterIP services alike.
+	hosts := map[string][]string{}
+	var order []string
+	for _, ep := range endpoints {
+		host := endpointHostname(ep)
+		if _, ok := hosts[host]; !ok {
+			order = append(order, host)
+		}
+		hosts[host] = append(hosts[host], ep.IP)
+		cases = append(cases, ptrCase(ep.IP, host+"."+name))
+	}
+	for _, host := range order {
+		for _, qt := range []uint16{dns.TypeA, dns.TypeAAAA} {
+			tc := test.Case{Qname: host + "." + name, Qtype: qt, Rcode: dns.RcodeSuccess}
+			for _, ip := range hosts[host] {
+				if qtype(ip) == qt {
+					tc.Answer = append(tc.Answer, address(tc.Qname, ip))
+				}
+			}
+			if len(tc.Answer) > 0 {
+				cases = append(cases, tc)
+			}
+		}
+	}
+	return cases
+}
+
+// readyEndpoints returns the ready endpoints of the service. For services with a selector these are
+// the IPs of the families of the service of the pods of the namespace matching the selector that are ready,
+// or all of them if the service publishes addresses that are not ready.
+func (svc Service) readyEndpoints(ns Namespace) []Endpoint {
+	var endpoints []Endpoint
+	if svc.Selector == nil {
+		for _, ep := range svc.Endpoints {
+			if !ep.NotReady {
+				endpoints = append(endpoints, ep)
+			}
+		}
+		return endpoints
+	}
+	for _, pod := range ns.Pods {
+		if (pod.NotReady && !svc.PublishNotReadyAddresses) || pod.IP == "" || !selects(svc.Selector, pod.Labels) {
+			continue
+		}
+		ips := pod.IPs
+		if len(ips) == 0 {
+			ips = []string{pod.IP}
+		}
+		for _, ip := range ips {
+			if !svc.hasFamily(family(ip), family(pod.IP)) {
+				continue
+			}
+			ep := Endpoint{IP: ip}
+			if pod.Subdomain == svc.Name {
+				ep.Hostname = pod.Hostname
+			}
+			endpoints = append(endpoints, ep)
+		}
+	}
+	return endpoints
+}
+
+// hasFamily returns whether the service has IP family f, given the primary family of the cluster.
+func (svc Service) hasFamily(f, primary api.IPFamily) bool {
+	if svc.headless() {
+		return svc.DualStack || f == primary
+	}
+	for _, ip := range svc.ClusterIPs {
+		if family(ip) == f {
+			return true
+		}
+	}
+	return false
+}
+
+// srvCase returns the SRV case for the backends of a name. Like CoreDNS, the weight is divided evenly over
+// all backends, while duplicate records and additional addresses are only included once.
+func srvCase(qname string, backends []backend) test.Case {
+	tc := test.Case{Qname: qname, Qtype: dns.TypeSRV, Rcode: dns.RcodeSuccess}
+	weight := 100 / len(backends)
+	if weight == 0 {
+		weight = 1
+	}
+	seen := map[string]bool{}
+	for _, b := range backends {
+		srv := fmt.Sprintf("%s %d IN SRV 0 %d %d %s", qname, TTL, weight, b.port, b.target)
+		if !seen[srv] {
+			seen[srv] = true
+			tc.Answer = append(tc.Answer, test.SRV(srv))
+		}
+		if !seen[b.target+" "+b.ip] {
+			seen[b.target+" "+b.ip] = true
+			tc.Extra = append(tc.Extra, address(b.target, b.ip))
+		}
+	}
+	return tc
+}
+
+func ptrCase(ip, name string) test.Case {
+	arpa, _ := dns.ReverseAddr(ip)
+	return test.Case{
+		Qname: arpa, Qtype: dns.TypePTR,
+		Rcode:  dns.RcodeSuccess,
+		Answer: []dns.RR{test.PTR(fmt.Sprintf("%s %d IN PTR %s", arpa, TTL, name))},
+	}
+}
+
+func address(name, ip string) dns.RR {
+	if qtype(ip) == dns.TypeAAAA {
+		return test.AAAA(fmt.Sprintf("%s %d IN AAAA %s", name, TTL, ip))
+	}
+	return test.A(fmt.Sprintf("%s %d IN A %s", name, TTL, ip))
+}
+
+func cname(name, target string) dns.RR {
+	return test.CNAME(fmt.Sprintf("%s %d IN CNAME %s", name, TTL, dns.Fqdn(target)))
+}
+
+func soa(zone string) dns.RR {
+	return test.SOA(fmt.Sprintf("%s %d IN SOA ns.dns.%s hostmaster.%s 1502313310 7200 1800 86400 30", zone, TTL, zone, zone))
+}
+
+func qtype(ip string) uint16 {
+	if family(ip) == api.IPv6Protocol {
+		return dns.TypeAAAA
+	}
+	return dns.TypeA
+}
+
+// endpointHostname returns the name of an endpoint below its service, as CoreDNS does.
+func endpointHostname(ep Endpoint) string {
+	if ep.Hostname != "" {
+		return ep.Hostname
+	}
+	return dashed(ep.IP)
+}
+
+// dashed returns ip with the dots or colons replaced by dashes.
+func dashed(ip string) string {
+	return strings.NewReplacer(".", "-", ":", "-").Replace(ip)
+}
+
+func selects(selector, labels map[string]string) bool {
+	for k, v := range selector {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
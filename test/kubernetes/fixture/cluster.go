@@ -0,0 +1,138 @@
+package fixture
+
+import (
+	"context"
+
+	api "k8s.io/api/core/v1"
+	discovery "k8s.io/api/discovery/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// FromCluster returns the set of the Services, EndpointSlices and Pods in the given namespaces of the cluster,
+// or in all namespaces if none are given, so the records CoreDNS should serve for them can be derived with Cases
+// and PodCases. The endpoints of all services are taken from their EndpointSlices, and pods that are terminating
+// or have no IP yet are left out, as CoreDNS does.
+func FromCluster(ctx context.Context, client kubernetes.Interface, namespaces ...string) (*Set, error) {
+	if len(namespaces) == 0 {
+		list, err := client.CoreV1().Namespaces().List(ctx, meta.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		for _, ns := range list.Items {
+			namespaces = append(namespaces, ns.Name)
+		}
+	}
+
+	s := &Set{}
+	for _, name := range namespaces {
+		ns, err := namespaceFromCluster(ctx, client, name)
+		if err != nil {
+			return nil, err
+		}
+		s.Namespaces = append(s.Namespaces, ns)
+	}
+	return s, nil
+}
+
+func namespaceFromCluster(ctx context.Context, client kubernetes.Interface, name string) (Namespace, error) {
+	ns := Namespace{Name: name}
+	services, err := client.CoreV1().Services(name).List(ctx, meta.ListOptions{})
+	if err != nil {
+		return ns, err
+	}
+	slices, err := client.DiscoveryV1().EndpointSlices(name).List(ctx, meta.ListOptions{})
+	if err != nil {
+		return ns, err
+	}
+	pods, err := client.CoreV1().Pods(name).List(ctx, meta.ListOptions{})
+	if err != nil {
+		return ns, err
+	}
+
+	slicesOf := map[string][]discovery.EndpointSlice{}
+	for _, es := range slices.Items {
+		if es.AddressType == discovery.AddressTypeFQDN {
+			continue
+		}
+		svc := es.Labels[discovery.LabelServiceName]
+		slicesOf[svc] = append(slicesOf[svc], es)
+	}
+	for _, svc := range services.Items {
+		ns.Services = append(ns.Services, serviceFromCluster(svc, slicesOf[svc.Name]))
+	}
+
+	seen := map[string]bool{}
+	for _, pod := range pods.Items {
+		// pods sharing the network of the node share its IP, which has one record
+		if pod.DeletionTimestamp != nil || pod.Status.PodIP == "" || seen[pod.Status.PodIP] {
+			continue
+		}
+		seen[pod.Status.PodIP] = true
+		p := Pod{
+			Name:      pod.Name,
+			Labels:    pod.Labels,
+			Hostname:  pod.Spec.Hostname,
+			Subdomain: pod.Spec.Subdomain,
+			NotReady:  !podReady(pod),
+			IP:        pod.Status.PodIP,
+		}
+		for _, ip := range pod.Status.PodIPs {
+			p.IPs = append(p.IPs, ip.IP)
+		}
+		ns.Pods = append(ns.Pods, p)
+	}
+	return ns, nil
+}
+
+func serviceFromCluster(svc api.Service, slices []discovery.EndpointSlice) Service {
+	s := Service{Name: svc.Name, Labels: svc.Labels}
+	if svc.Spec.Type == api.ServiceTypeExternalName {
+		s.ExternalName = svc.Spec.ExternalName
+		return s
+	}
+	if svc.Spec.ClusterIP != api.ClusterIPNone {
+		s.ClusterIPs = svc.Spec.ClusterIPs
+	}
+
+	// the target ports are those of the EndpointSlices, which CoreDNS uses for the SRV records of endpoints
+	targetPorts := map[string]int32{}
+	for _, es := range slices {
+		for _, p := range es.Ports {
+			if p.Name != nil && p.Port != nil {
+				if _, ok := targetPorts[*p.Name]; !ok {
+					targetPorts[*p.Name] = *p.Port
+				}
+			}
+		}
+	}
+	for _, p := range svc.Spec.Ports {
+		s.Ports = append(s.Ports, Port{Name: p.Name, Port: p.Port, TargetPort: targetPorts[p.Name], Protocol: p.Protocol})
+	}
+
+	seen := map[Endpoint]bool{}
+	for _, es := range slices {
+		for _, end := range es.Endpoints {
+			for _, ip := range end.Addresses {
+				ep := Endpoint{IP: ip, NotReady: end.Conditions.Ready != nil && !*end.Conditions.Ready}
+				if end.Hostname != nil {
+					ep.Hostname = *end.Hostname
+				}
+				if !seen[ep] {
+					seen[ep] = true
+					s.Endpoints = append(s.Endpoints, ep)
+				}
+			}
+		}
+	}
+	return s
+}
+
+func podReady(pod api.Pod) bool {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == api.PodReady {
+			return c.Status == api.ConditionTrue
+		}
+	}
+	return false
+}
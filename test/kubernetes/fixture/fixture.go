@@ -0,0 +1,379 @@
+// Package fixture declares Kubernetes objects used as test fixtures, applies them to a cluster and derives the
+// DNS answers CoreDNS is expected to give for them, so expectations do not have to be maintained by hand.
+package fixture
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	api "k8s.io/api/core/v1"
+	discovery "k8s.io/api/discovery/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Set is a set of fixtures.
+type Set struct {
+	Namespaces []Namespace
+}
+
+// Namespace is a namespace and the objects in it.
+type Namespace struct {
+	Name     string
+	Services []Service
+	Pods     []Pod
+}
+
+// Service is a Service. A service without ClusterIPs is headless, unless AllocateClusterIP is set. A service
+// with an IPv4 and an IPv6 ClusterIP is dual-stack. IPv4 ClusterIPs applied to a cluster are pinned in the static
+// band of its service subnet, 10.96.0.16 to 10.96.0.255 in kind, which the cluster does not allocate from.
+type Service struct {
+	Name       string
+	ClusterIPs []string
+	// AllocateClusterIP makes a service without ClusterIPs get one allocated by the cluster. It is only known
+	// once the service has been created, see LoadClusterIPs.
+	AllocateClusterIP bool
+	// ExternalName makes the service an ExternalName service, an alias for this name without endpoints.
+	ExternalName string
+	// DualStack makes a headless service dual-stack, so its endpoints include the pod IPs of both families.
+	DualStack bool
+	Ports     []Port
+	// Endpoints are applied as EndpointSlices for services without a Selector.
+	Endpoints []Endpoint
+	// Selector selects the pods of the namespace that back the service. The EndpointSlices of the service
+	// are then maintained by Kubernetes, and Endpoints is ignored.
+	Selector map[string]string
+	// Labels are the labels of the service. Its EndpointSlices are not labelled with them.
+	Labels map[string]string
+	// PublishNotReadyAddresses makes Kubernetes publish the pods selected by the service as ready endpoints,
+	// whether they are ready or not.
+	PublishNotReadyAddresses bool
+	// ExternalIPs are the external IPs of the service.
+	ExternalIPs []string
+	// LoadBalancerIngress are the IPs or hostnames of the load balancer of the service. They make the service
+	// a LoadBalancer service, and are set on its status, as a cloud provider would do.
+	LoadBalancerIngress []string
+}
+
+// Port is a port of a Service.
+type Port struct {
+	Name       string
+	Port       int32
+	TargetPort int32        // port of the endpoints, defaults to Port
+	Protocol   api.Protocol // defaults to TCP
+}
+
+// Endpoint is an endpoint of a Service.
+type Endpoint struct {
+	IP       string
+	Hostname string
+	NotReady bool
+	// Terminating endpoints are usually not ready. Serving tells whether they still serve; the endpoints
+	// that are not terminating serve when they are ready.
+	Terminating bool
+	Serving     bool
+	// Zone is the zone of the endpoint, ZoneHint the zone it is hinted to be consumed from.
+	Zone     string
+	ZoneHint string
+}
+
+// Pod is a pod running a pause container.
+type Pod struct {
+	Name      string
+	Labels    map[string]string
+	Hostname  string
+	Subdomain string
+	// NotReady pods use an image that can't be pulled, so they never become ready.
+	NotReady bool
+	// IP is the primary address of the pod, which is only known once it has been scheduled. See LoadPodIPs.
+	IP string
+	// IPs are all addresses of the pod, the primary one first. A pod in a dual-stack cluster has one of each family.
+	IPs []string
+}
+
+// ManagedBy is the value of the endpointslice.kubernetes.io/managed-by label of EndpointSlices created from fixtures.
+const ManagedBy = "fixture.ci.coredns.io"
+
+const (
+	pauseImage   = "registry.k8s.io/pause:3.9"
+	invalidImage = "invalid-image:0.0"
+)
+
+// Apply creates the objects of the set. Namespaces that already exist are reused.
+func (s *Set) Apply(ctx context.Context, client kubernetes.Interface) error {
+	for _, ns := range s.Namespaces {
+		_, err := client.CoreV1().Namespaces().Create(ctx, &api.Namespace{ObjectMeta: meta.ObjectMeta{Name: ns.Name}}, meta.CreateOptions{})
+		if err != nil && !apierrors.IsAlreadyExists(err) {
+			return err
+		}
+		for _, svc := range ns.Services {
+			created, err := client.CoreV1().Services(ns.Name).Create(ctx, svc.object(ns.Name), meta.CreateOptions{})
+			if err != nil {
+				return err
+			}
+			if len(svc.LoadBalancerIngress) > 0 {
+				created.Status.LoadBalancer.Ingress = svc.loadBalancerIngress()
+				if _, err := client.CoreV1().Services(ns.Name).UpdateStatus(ctx, created, meta.UpdateOptions{}); err != nil {
+					return err
+				}
+			}
+			for _, es := range svc.endpointSlices(ns.Name) {
+				if _, err := client.DiscoveryV1().EndpointSlices(ns.Name).Create(ctx, es, meta.CreateOptions{}); err != nil {
+					return err
+				}
+			}
+		}
+		for _, pod := range ns.Pods {
+			if _, err := client.CoreV1().Pods(ns.Name).Create(ctx, pod.object(ns.Name), meta.CreateOptions{}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// UpdateEndpoints updates the EndpointSlices of the services of the set without a Selector to their
+// Endpoints, as they were changed since Apply. The EndpointSlices of families without endpoints are deleted.
+func (s *Set) UpdateEndpoints(ctx context.Context, client kubernetes.Interface) error {
+	for _, ns := range s.Namespaces {
+		for _, svc := range ns.Services {
+			if svc.Selector != nil || svc.ExternalName != "" {
+				continue
+			}
+			slices := client.DiscoveryV1().EndpointSlices(ns.Name)
+			updated := map[string]bool{}
+			for _, es := range svc.endpointSlices(ns.Name) {
+				updated[es.Name] = true
+				_, err := slices.Update(ctx, es, meta.UpdateOptions{})
+				if apierrors.IsNotFound(err) {
+					_, err = slices.Create(ctx, es, meta.CreateOptions{})
+				}
+				if err != nil {
+					return err
+				}
+			}
+			for _, at := range []discovery.AddressType{discovery.AddressTypeIPv4, discovery.AddressTypeIPv6} {
+				name := svc.Name + "-" + strings.ToLower(string(at))
+				if updated[name] {
+					continue
+				}
+				err := slices.Delete(ctx, name, meta.DeleteOptions{})
+				if err != nil && !apierrors.IsNotFound(err) {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Delete deletes the namespaces of the set, and with them all objects in them.
+func (s *Set) Delete(ctx context.Context, client kubernetes.Interface) error {
+	for _, ns := range s.Namespaces {
+		err := client.CoreV1().Namespaces().Delete(ctx, ns.Name, meta.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadPodIPs sets the IP of the pods of the set from the cluster. Pods that have no IP yet are left unchanged.
+func (s *Set) LoadPodIPs(ctx context.Context, client kubernetes.Interface) error {
+	for i := range s.Namespaces {
+		ns := &s.Namespaces[i]
+		for j := range ns.Pods {
+			pod, err := client.CoreV1().Pods(ns.Name).Get(ctx, ns.Pods[j].Name, meta.GetOptions{})
+			if err != nil {
+				return err
+			}
+			if pod.Status.PodIP != "" {
+				ns.Pods[j].IP = pod.Status.PodIP
+				ns.Pods[j].IPs = nil
+				for _, ip := range pod.Status.PodIPs {
+					ns.Pods[j].IPs = append(ns.Pods[j].IPs, ip.IP)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// LoadClusterIPs sets the ClusterIPs of the services of the set that have them allocated by the cluster.
+func (s *Set) LoadClusterIPs(ctx context.Context, client kubernetes.Interface) error {
+	for i := range s.Namespaces {
+		ns := &s.Namespaces[i]
+		list, err := client.CoreV1().Services(ns.Name).List(ctx, meta.ListOptions{})
+		if err != nil {
+			return err
+		}
+		clusterIPs := map[string][]string{}
+		for _, svc := range list.Items {
+			clusterIPs[svc.Name] = svc.Spec.ClusterIPs
+		}
+		for j := range ns.Services {
+			if ns.Services[j].AllocateClusterIP {
+				ns.Services[j].ClusterIPs = clusterIPs[ns.Services[j].Name]
+			}
+		}
+	}
+	return nil
+}
+
+func (svc Service) headless() bool {
+	return len(svc.ClusterIPs) == 0 && !svc.AllocateClusterIP && svc.ExternalName == ""
+}
+
+func (p Port) targetPort() int32 {
+	if p.TargetPort == 0 {
+		return p.Port
+	}
+	return p.TargetPort
+}
+
+func (p Port) protocol() api.Protocol {
+	if p.Protocol == "" {
+		return api.ProtocolTCP
+	}
+	return p.Protocol
+}
+
+func (svc Service) object(namespace string) *api.Service {
+	s := &api.Service{
+		ObjectMeta: meta.ObjectMeta{Namespace: namespace, Name: svc.Name, Labels: svc.Labels},
+		Spec:       api.ServiceSpec{Selector: svc.Selector, PublishNotReadyAddresses: svc.PublishNotReadyAddresses},
+	}
+	for _, p := range svc.Ports {
+		s.Spec.Ports = append(s.Spec.Ports, api.ServicePort{Name: p.Name, Port: p.Port, Protocol: p.protocol()})
+	}
+	s.Spec.ExternalIPs = svc.ExternalIPs
+	if svc.ExternalName != "" {
+		s.Spec.Type = api.ServiceTypeExternalName
+		s.Spec.ExternalName = svc.ExternalName
+		return s
+	}
+	if svc.headless() {
+		s.Spec.ClusterIP = api.ClusterIPNone
+		if svc.DualStack {
+			policy := api.IPFamilyPolicyRequireDualStack
+			s.Spec.IPFamilyPolicy = &policy
+		}
+		return s
+	}
+	if len(svc.LoadBalancerIngress) > 0 {
+		s.Spec.Type = api.ServiceTypeLoadBalancer
+	}
+	if len(svc.ClusterIPs) == 0 {
+		return s
+	}
+	s.Spec.ClusterIP = svc.ClusterIPs[0]
+	s.Spec.ClusterIPs = svc.ClusterIPs
+	for _, ip := range svc.ClusterIPs {
+		s.Spec.IPFamilies = append(s.Spec.IPFamilies, family(ip))
+	}
+	if len(svc.ClusterIPs) > 1 {
+		policy := api.IPFamilyPolicyRequireDualStack
+		s.Spec.IPFamilyPolicy = &policy
+	}
+	return s
+}
+
+func (svc Service) loadBalancerIngress() []api.LoadBalancerIngress {
+	var ingress []api.LoadBalancerIngress
+	for _, in := range svc.LoadBalancerIngress {
+		if net.ParseIP(in) != nil {
+			ingress = append(ingress, api.LoadBalancerIngress{IP: in})
+			continue
+		}
+		ingress = append(ingress, api.LoadBalancerIngress{Hostname: in})
+	}
+	return ingress
+}
+
+// endpointSlices returns an EndpointSlice per address family for the endpoints of the service.
+func (svc Service) endpointSlices(namespace string) []*discovery.EndpointSlice {
+	if svc.Selector != nil || svc.ExternalName != "" {
+		return nil
+	}
+	var ports []discovery.EndpointPort
+	for _, p := range svc.Ports {
+		name, port, protocol := p.Name, p.targetPort(), p.protocol()
+		ports = append(ports, discovery.EndpointPort{Name: &name, Port: &port, Protocol: &protocol})
+	}
+
+	var slices []*discovery.EndpointSlice
+	for _, at := range []discovery.AddressType{discovery.AddressTypeIPv4, discovery.AddressTypeIPv6} {
+		es := &discovery.EndpointSlice{
+			ObjectMeta: meta.ObjectMeta{
+				Namespace: namespace,
+				Name:      svc.Name + "-" + strings.ToLower(string(at)),
+				Labels: map[string]string{
+					discovery.LabelServiceName: svc.Name,
+					discovery.LabelManagedBy:   ManagedBy,
+				},
+			},
+			AddressType: at,
+			Ports:       ports,
+		}
+		for _, ep := range svc.Endpoints {
+			if addressType(ep.IP) != at {
+				continue
+			}
+			ready, serving, terminating := !ep.NotReady, !ep.NotReady, ep.Terminating
+			if terminating {
+				serving = ep.Serving
+			}
+			e := discovery.Endpoint{
+				Addresses:  []string{ep.IP},
+				Conditions: discovery.EndpointConditions{Ready: &ready, Serving: &serving, Terminating: &terminating},
+			}
+			if ep.Hostname != "" {
+				hostname := ep.Hostname
+				e.Hostname = &hostname
+			}
+			if ep.Zone != "" {
+				zone := ep.Zone
+				e.Zone = &zone
+			}
+			if ep.ZoneHint != "" {
+				e.Hints = &discovery.EndpointHints{ForZones: []discovery.ForZone{{Name: ep.ZoneHint}}}
+			}
+			es.Endpoints = append(es.Endpoints, e)
+		}
+		if len(es.Endpoints) > 0 {
+			slices = append(slices, es)
+		}
+	}
+	return slices
+}
+
+func (pod Pod) object(namespace string) *api.Pod {
+	image, pull := pauseImage, api.PullIfNotPresent
+	if pod.NotReady {
+		image, pull = invalidImage, api.PullNever
+	}
+	return &api.Pod{
+		ObjectMeta: meta.ObjectMeta{Namespace: namespace, Name: pod.Name, Labels: pod.Labels},
+		Spec: api.PodSpec{
+			Hostname:   pod.Hostname,
+			Subdomain:  pod.Subdomain,
+			Containers: []api.Container{{Name: "pause", Image: image, ImagePullPolicy: pull}},
+		},
+	}
+}
+
+func family(ip string) api.IPFamily {
+	if addressType(ip) == discovery.AddressTypeIPv6 {
+		return api.IPv6Protocol
+	}
+	return api.IPv4Protocol
+}
+
+func addressType(ip string) discovery.AddressType {
+	if net.ParseIP(ip).To4() == nil {
+		return discovery.AddressTypeIPv6
+	}
+	return discovery.AddressTypeIPv4
+}
@@ -0,0 +1,490 @@
+package fixture
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+	api "k8s.io/api/core/v1"
+	discovery "k8s.io/api/discovery/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var testSet = Set{Namespaces: []Namespace{{
+	Name: "test-1",
+	Services: []Service{
+		{
+			Name:       "svc-1-a",
+			ClusterIPs: []string{"10.96.0.100"},
+			Ports:      []Port{{Name: "http", Port: 80}, {Name: "https", Port: 443}},
+			Endpoints:  []Endpoint{{IP: "172.17.0.253", Hostname: "svc-1-a"}},
+		},
+		{
+			Name:  "headless-svc",
+			Ports: []Port{{Name: "c-port", Port: 1234, Protocol: api.ProtocolUDP}},
+			Endpoints: []Endpoint{
+				{IP: "172.17.0.254"},
+				{IP: "1234:abcd::1", Hostname: "headless-svc-3"},
+				{IP: "172.17.0.250", NotReady: true},
+			},
+		},
+		{
+			Name:     "svc-unready",
+			Selector: map[string]string{"app": "unready"},
+		},
+	},
+	Pods: []Pod{{Name: "unready", Labels: map[string]string{"app": "unready"}, NotReady: true, IP: "10.244.0.9"}},
+}}}
+
+func TestCases(t *testing.T) {
+	want := map[string]test.Case{
+		"svc-1-a.test-1.svc.cluster.local. A": {
+			Answer: []dns.RR{test.A("svc-1-a.test-1.svc.cluster.local. 303 IN A 10.96.0.100")},
+		},
+		"svc-1-a.test-1.svc.cluster.local. SRV": {
+			Answer: []dns.RR{
+				test.SRV("svc-1-a.test-1.svc.cluster.local. 303 IN SRV 0 50 80 svc-1-a.test-1.svc.cluster.local."),
+				test.SRV("svc-1-a.test-1.svc.cluster.local. 303 IN SRV 0 50 443 svc-1-a.test-1.svc.cluster.local."),
+			},
+			Extra: []dns.RR{test.A("svc-1-a.test-1.svc.cluster.local. 303 IN A 10.96.0.100")},
+		},
+		"_https._tcp.svc-1-a.test-1.svc.cluster.local. SRV": {
+			Answer: []dns.RR{test.SRV("_https._tcp.svc-1-a.test-1.svc.cluster.local. 303 IN SRV 0 100 443 svc-1-a.test-1.svc.cluster.local.")},
+			Extra:  []dns.RR{test.A("svc-1-a.test-1.svc.cluster.local. 303 IN A 10.96.0.100")},
+		},
+		"100.0.96.10.in-addr.arpa. PTR": {
+			Answer: []dns.RR{test.PTR("100.0.96.10.in-addr.arpa. 303 IN PTR svc-1-a.test-1.svc.cluster.local.")},
+		},
+		"253.0.17.172.in-addr.arpa. PTR": {
+			Answer: []dns.RR{test.PTR("253.0.17.172.in-addr.arpa. 303 IN PTR svc-1-a.svc-1-a.test-1.svc.cluster.local.")},
+		},
+		"headless-svc.test-1.svc.cluster.local. A": {
+			Answer: []dns.RR{test.A("headless-svc.test-1.svc.cluster.local. 303 IN A 172.17.0.254")},
+		},
+		"headless-svc.test-1.svc.cluster.local. AAAA": {
+			Answer: []dns.RR{test.AAAA("headless-svc.test-1.svc.cluster.local. 303 IN AAAA 1234:abcd::1")},
+		},
+		"_c-port._udp.headless-svc.test-1.svc.cluster.local. SRV": {
+			Answer: []dns.RR{
+				test.SRV("_c-port._udp.headless-svc.test-1.svc.cluster.local. 303 IN SRV 0 50 1234 172-17-0-254.headless-svc.test-1.svc.cluster.local."),
+				test.SRV("_c-port._udp.headless-svc.test-1.svc.cluster.local. 303 IN SRV 0 50 1234 headless-svc-3.headless-svc.test-1.svc.cluster.local."),
+			},
+			Extra: []dns.RR{
+				test.A("172-17-0-254.headless-svc.test-1.svc.cluster.local. 303 IN A 172.17.0.254"),
+				test.AAAA("headless-svc-3.headless-svc.test-1.svc.cluster.local. 303 IN AAAA 1234:abcd::1"),
+			},
+		},
+		"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.d.c.b.a.4.3.2.1.ip6.arpa. PTR": {
+			Answer: []dns.RR{test.PTR("1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.d.c.b.a.4.3.2.1.ip6.arpa. 303 IN PTR headless-svc-3.headless-svc.test-1.svc.cluster.local.")},
+		},
+		"172-17-0-254.headless-svc.test-1.svc.cluster.local. A": {
+			Answer: []dns.RR{test.A("172-17-0-254.headless-svc.test-1.svc.cluster.local. 303 IN A 172.17.0.254")},
+		},
+		"svc-unready.test-1.svc.cluster.local. A": {
+			Rcode: dns.RcodeNameError,
+			Ns:    []dns.RR{test.SOA("cluster.local. 303 IN SOA ns.dns.cluster.local. hostmaster.cluster.local. 1502313310 7200 1800 86400 30")},
+		},
+	}
+
+	cases := map[string]test.Case{}
+	for _, tc := range testSet.Cases("cluster.local") {
+		key := tc.Qname + " " + dns.TypeToString[tc.Qtype]
+		if _, ok := cases[key]; ok {
+			t.Errorf("duplicate case %s", key)
+		}
+		cases[key] = tc
+	}
+	if _, ok := cases["250.0.17.172.in-addr.arpa. PTR"]; ok {
+		t.Errorf("unexpected case for not ready endpoint")
+	}
+
+	for key, w := range want {
+		tc, ok := cases[key]
+		if !ok {
+			t.Errorf("missing case %s", key)
+			continue
+		}
+		w.Qname, w.Qtype = tc.Qname, tc.Qtype
+		sort.Sort(test.RRSet(w.Answer))
+		sort.Sort(test.RRSet(w.Extra))
+		if w.Rcode == 0 && tc.Rcode != dns.RcodeSuccess {
+			t.Errorf("%s: expected rcode NOERROR, got %s", key, dns.RcodeToString[tc.Rcode])
+		}
+		// the derived case is checked like a response would be checked against the expectation
+		m := tc.Msg()
+		m.Rcode, m.Answer, m.Ns, m.Extra = tc.Rcode, tc.Answer, tc.Ns, tc.Extra
+		if err := test.SortAndCheck(m, w); err != nil {
+			t.Errorf("%s: %s", key, err)
+		}
+	}
+}
+
+func TestPodCases(t *testing.T) {
+	cases := testSet.PodCases("cluster.local.")
+	if len(cases) != 1 {
+		t.Fatalf("expected 1 pod case, got %d", len(cases))
+	}
+	if cases[0].Qname != "10-244-0-9.test-1.pod.cluster.local." || cases[0].Qtype != dns.TypeA {
+		t.Errorf("unexpected pod case %s %s", cases[0].Qname, dns.TypeToString[cases[0].Qtype])
+	}
+}
+
+func TestApply(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset()
+	if err := testSet.Apply(ctx, client); err != nil {
+		t.Fatalf("could not apply fixtures: %s", err)
+	}
+
+	svc, err := client.CoreV1().Services("test-1").Get(ctx, "headless-svc", meta.GetOptions{})
+	if err != nil {
+		t.Fatalf("could not get service: %s", err)
+	}
+	if svc.Spec.ClusterIP != api.ClusterIPNone {
+		t.Errorf("expected headless service, got cluster ip %q", svc.Spec.ClusterIP)
+	}
+
+	slices, err := client.DiscoveryV1().EndpointSlices("test-1").List(ctx, meta.ListOptions{})
+	if err != nil {
+		t.Fatalf("could not list endpointslices: %s", err)
+	}
+	// svc-1-a has an ipv4 slice, headless-svc an ipv4 and an ipv6 slice, svc-unready is managed by kubernetes
+	if len(slices.Items) != 3 {
+		t.Errorf("expected 3 endpointslices, got %d", len(slices.Items))
+	}
+
+	if _, err := client.CoreV1().Pods("test-1").Get(ctx, "unready", meta.GetOptions{}); err != nil {
+		t.Errorf("could not get pod: %s", err)
+	}
+
+	if err := testSet.Delete(ctx, client); err != nil {
+		t.Errorf("could not delete fixtures: %s", err)
+	}
+}
+
+func TestApplyLoadBalancer(t *testing.T) {
+	set := Set{Namespaces: []Namespace{{
+		Name: "test-1",
+		Services: []Service{{
+			Name: "lb", ClusterIPs: []string{"10.96.0.110"}, Ports: []Port{{Name: "http", Port: 80}},
+			ExternalIPs: []string{"192.0.2.1"}, LoadBalancerIngress: []string{"192.0.2.2", "lb.example.net"},
+		}},
+	}}}
+	ctx := context.Background()
+	client := fake.NewSimpleClientset()
+	if err := set.Apply(ctx, client); err != nil {
+		t.Fatalf("could not apply fixtures: %s", err)
+	}
+
+	svc, err := client.CoreV1().Services("test-1").Get(ctx, "lb", meta.GetOptions{})
+	if err != nil {
+		t.Fatalf("could not get service: %s", err)
+	}
+	if svc.Spec.Type != api.ServiceTypeLoadBalancer || len(svc.Spec.ExternalIPs) != 1 {
+		t.Errorf("expected a LoadBalancer service with an external IP, got %v", svc.Spec)
+	}
+	ingress := svc.Status.LoadBalancer.Ingress
+	if len(ingress) != 2 || ingress[0].IP != "192.0.2.2" || ingress[1].Hostname != "lb.example.net" {
+		t.Errorf("expected the ingress IP and hostname on the status, got %v", ingress)
+	}
+}
+
+func TestUpdateEndpoints(t *testing.T) {
+	set := Set{Namespaces: []Namespace{{
+		Name: "test-1",
+		Services: []Service{{
+			Name:      "headless",
+			Ports:     []Port{{Name: "http", Port: 80}},
+			Endpoints: []Endpoint{{IP: "172.17.0.10", Hostname: "pod-1", Zone: "zone-a", ZoneHint: "zone-a"}, {IP: "1234:abcd::10"}},
+		}},
+	}}}
+	ctx := context.Background()
+	client := fake.NewSimpleClientset()
+	if err := set.Apply(ctx, client); err != nil {
+		t.Fatalf("could not apply fixtures: %s", err)
+	}
+
+	// the ipv4 endpoint terminates, the ipv6 one is gone
+	set.Namespaces[0].Services[0].Endpoints = []Endpoint{{IP: "172.17.0.10", Hostname: "pod-1", NotReady: true, Terminating: true, Serving: true}}
+	if err := set.UpdateEndpoints(ctx, client); err != nil {
+		t.Fatalf("could not update endpoints: %s", err)
+	}
+
+	slices, err := client.DiscoveryV1().EndpointSlices("test-1").List(ctx, meta.ListOptions{})
+	if err != nil {
+		t.Fatalf("could not list endpointslices: %s", err)
+	}
+	if len(slices.Items) != 1 || len(slices.Items[0].Endpoints) != 1 {
+		t.Fatalf("expected one endpointslice with one endpoint, got %v", slices.Items)
+	}
+	c := slices.Items[0].Endpoints[0].Conditions
+	if *c.Ready || !*c.Serving || !*c.Terminating {
+		t.Errorf("expected a terminating, serving endpoint that is not ready, got ready %t, serving %t, terminating %t", *c.Ready, *c.Serving, *c.Terminating)
+	}
+	if len(set.Cases("cluster.local.")) != 1 {
+		t.Errorf("expected only the NXDOMAIN case of the service, got %v", set.Cases("cluster.local."))
+	}
+}
+
+func TestPublishNotReadyAddresses(t *testing.T) {
+	set := Set{Namespaces: []Namespace{{
+		Name: "test-1",
+		Services: []Service{
+			{Name: "svc-unready", Selector: map[string]string{"app": "unready"}},
+			{Name: "svc-published", Selector: map[string]string{"app": "unready"}, PublishNotReadyAddresses: true},
+		},
+		Pods: []Pod{{Name: "unready", Labels: map[string]string{"app": "unready"}, NotReady: true, IP: "10.244.0.9"}},
+	}}}
+	if eps := set.Namespaces[0].Services[0].readyEndpoints(set.Namespaces[0]); len(eps) != 0 {
+		t.Errorf("expected no endpoints, got %v", eps)
+	}
+	if eps := set.Namespaces[0].Services[1].readyEndpoints(set.Namespaces[0]); len(eps) != 1 || eps[0].IP != "10.244.0.9" {
+		t.Errorf("expected the unready pod to be published, got %v", eps)
+	}
+	if !set.Namespaces[0].Services[1].object("test-1").Spec.PublishNotReadyAddresses {
+		t.Error("expected the service to publish not ready addresses")
+	}
+}
+
+func TestWait(t *testing.T) {
+	set := Set{Namespaces: []Namespace{{
+		Name: "test-1",
+		Services: []Service{
+			{Name: "svc-1-a", ClusterIPs: []string{"10.96.0.100"}, Endpoints: []Endpoint{{IP: "172.17.0.253"}}},
+			{Name: "headless", Selector: map[string]string{"app": "headless"}},
+		},
+		Pods: []Pod{{Name: "pod-1", Labels: map[string]string{"app": "headless"}}},
+	}}}
+	ctx := context.Background()
+	client := fake.NewSimpleClientset()
+	if err := set.Apply(ctx, client); err != nil {
+		t.Fatalf("could not apply fixtures: %s", err)
+	}
+
+	timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	err := set.Wait(timeout, client)
+	if err == nil || !strings.Contains(err.Error(), "pod test-1/pod-1 has no IP") {
+		t.Errorf("expected pod to be pending, got %v", err)
+	}
+
+	// what the kubelet and the endpointslice controller would do
+	pod, _ := client.CoreV1().Pods("test-1").Get(ctx, "pod-1", meta.GetOptions{})
+	pod.Status.PodIP = "10.244.0.5"
+	if _, err := client.CoreV1().Pods("test-1").UpdateStatus(ctx, pod, meta.UpdateOptions{}); err != nil {
+		t.Fatalf("could not update pod: %s", err)
+	}
+	timeout, cancel = context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	err = set.Wait(timeout, client)
+	if err == nil || !strings.Contains(err.Error(), "service test-1/headless has 0 of 1 ready endpoints") {
+		t.Errorf("expected service to be pending, got %v", err)
+	}
+
+	if _, err := client.DiscoveryV1().EndpointSlices("test-1").Create(ctx, &discovery.EndpointSlice{
+		ObjectMeta:  meta.ObjectMeta{Name: "headless-abcde", Labels: map[string]string{discovery.LabelServiceName: "headless"}},
+		AddressType: discovery.AddressTypeIPv4,
+		Endpoints:   []discovery.Endpoint{{Addresses: []string{"10.244.0.5"}}},
+	}, meta.CreateOptions{}); err != nil {
+		t.Fatalf("could not create endpointslice: %s", err)
+	}
+	if err := set.Wait(ctx, client); err != nil {
+		t.Errorf("expected fixtures to be ready, got %v", err)
+	}
+	if set.Namespaces[0].Pods[0].IP != "10.244.0.5" {
+		t.Errorf("expected pod IP to be loaded, got %q", set.Namespaces[0].Pods[0].IP)
+	}
+}
+
+func TestDualStackCases(t *testing.T) {
+	set := Set{Namespaces: []Namespace{{
+		Name: "test-1",
+		Services: []Service{
+			{Name: "single", Selector: map[string]string{"app": "a"}},
+			{Name: "dual", DualStack: true, Selector: map[string]string{"app": "a"}},
+			{Name: "svc-dual", ClusterIPs: []string{"10.96.0.18", "fd00:10:96::200"}, Ports: []Port{{Name: "http", Port: 80}}},
+		},
+		Pods: []Pod{{Name: "pod-1", Labels: map[string]string{"app": "a"}, IP: "10.244.0.5", IPs: []string{"10.244.0.5", "fd00:10:244::5"}}},
+	}}}
+
+	cases := map[string]test.Case{}
+	for _, tc := range set.Cases("cluster.local.") {
+		cases[tc.Qname+" "+dns.TypeToString[tc.Qtype]] = tc
+	}
+	for _, tc := range set.InsecurePodCases("cluster.local.") {
+		cases[tc.Qname+" "+dns.TypeToString[tc.Qtype]] = tc
+	}
+
+	for _, key := range []string{
+		"single.test-1.svc.cluster.local. A",
+		"dual.test-1.svc.cluster.local. A",
+		"dual.test-1.svc.cluster.local. AAAA",
+		"fd00-10-244--5.dual.test-1.svc.cluster.local. AAAA",
+		"svc-dual.test-1.svc.cluster.local. AAAA",
+		"0.0.2.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.6.9.0.0.0.1.0.0.0.0.d.f.ip6.arpa. PTR",
+		"fd00-10-244--5.test-1.pod.cluster.local. AAAA",
+	} {
+		if _, ok := cases[key]; !ok {
+			t.Errorf("missing case %s", key)
+		}
+	}
+	if _, ok := cases["single.test-1.svc.cluster.local. AAAA"]; ok {
+		t.Errorf("unexpected AAAA case for single-stack headless service")
+	}
+	srv := cases["svc-dual.test-1.svc.cluster.local. SRV"]
+	if len(srv.Answer) != 1 || len(srv.Extra) != 2 {
+		t.Errorf("expected 1 SRV record with an A and AAAA record, got %v and %v", srv.Answer, srv.Extra)
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	set, err := Generate("scale", 2, 3, 2)
+	if err != nil {
+		t.Fatalf("could not generate fixtures: %s", err)
+	}
+	if len(set.Namespaces) != 2 || set.Namespaces[1].Name != "scale-1" {
+		t.Fatalf("expected namespaces scale-0 and scale-1, got %v", set.Namespaces)
+	}
+
+	svcs := set.Namespaces[1].Services
+	if len(svcs) != 3 {
+		t.Fatalf("expected 3 services, got %d", len(svcs))
+	}
+	// the first namespace holds two ClusterIP services and 6 endpoints
+	if svcs[0].ClusterIPs[0] != "10.128.0.2" || svcs[2].ClusterIPs[0] != "10.128.0.3" {
+		t.Errorf("unexpected cluster ips %v and %v", svcs[0].ClusterIPs, svcs[2].ClusterIPs)
+	}
+	if len(svcs[1].ClusterIPs) != 0 {
+		t.Errorf("expected svc-1 to be headless, got cluster ips %v", svcs[1].ClusterIPs)
+	}
+	if svcs[2].Endpoints[1].IP != "172.16.0.11" {
+		t.Errorf("expected endpoint 172.16.0.11, got %s", svcs[2].Endpoints[1].IP)
+	}
+
+	if _, err := Generate("scale", 1, 1, 1001); err == nil {
+		t.Error("expected error for too many endpoints")
+	}
+	if _, err := Generate("scale", 10000, 2000, 0); err == nil {
+		t.Error("expected error for too many services")
+	}
+}
+
+func TestAllocateClusterIPs(t *testing.T) {
+	ctx := context.Background()
+	set, err := Generate("scale", 1, 2, 1)
+	if err != nil {
+		t.Fatalf("could not generate fixtures: %s", err)
+	}
+	set.AllocateClusterIPs()
+	client := fake.NewSimpleClientset()
+	if err := set.Apply(ctx, client); err != nil {
+		t.Fatalf("could not apply fixtures: %s", err)
+	}
+
+	// the ClusterIP is left to the cluster, the fake clientset does not allocate one, so do it here
+	svc, err := client.CoreV1().Services("scale-0").Get(ctx, "svc-0", meta.GetOptions{})
+	if err != nil {
+		t.Fatalf("could not get service: %s", err)
+	}
+	if svc.Spec.ClusterIP != "" {
+		t.Fatalf("expected no ClusterIP to be requested, got %q", svc.Spec.ClusterIP)
+	}
+	svc.Spec.ClusterIP, svc.Spec.ClusterIPs = "10.96.123.4", []string{"10.96.123.4"}
+	if _, err := client.CoreV1().Services("scale-0").Update(ctx, svc, meta.UpdateOptions{}); err != nil {
+		t.Fatalf("could not update service: %s", err)
+	}
+
+	if err := set.LoadClusterIPs(ctx, client); err != nil {
+		t.Fatalf("could not load cluster IPs: %s", err)
+	}
+	svcs := set.Namespaces[0].Services
+	if len(svcs[0].ClusterIPs) != 1 || svcs[0].ClusterIPs[0] != "10.96.123.4" {
+		t.Errorf("expected cluster IP 10.96.123.4, got %v", svcs[0].ClusterIPs)
+	}
+	if !svcs[1].headless() {
+		t.Errorf("expected svc-1 to remain headless")
+	}
+	cases := set.Cases("cluster.local.")
+	if !strings.Contains(fmt.Sprint(cases), "10.96.123.4") {
+		t.Errorf("expected the cases to answer with the allocated cluster IP, got %v", cases)
+	}
+}
+
+func TestFromCluster(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset()
+	if err := testSet.Apply(ctx, client); err != nil {
+		t.Fatalf("could not apply fixtures: %s", err)
+	}
+	if _, err := client.CoreV1().Services("test-1").Create(ctx, &api.Service{
+		ObjectMeta: meta.ObjectMeta{Name: "external"},
+		Spec:       api.ServiceSpec{Type: api.ServiceTypeExternalName, ExternalName: "example.net"},
+	}, meta.CreateOptions{}); err != nil {
+		t.Fatalf("could not create service: %s", err)
+	}
+
+	set, err := FromCluster(ctx, client, "test-1")
+	if err != nil {
+		t.Fatalf("could not load fixtures: %s", err)
+	}
+	if len(set.Namespaces) != 1 || len(set.Namespaces[0].Services) != 4 {
+		t.Fatalf("expected 4 services in test-1, got %v", set.Namespaces)
+	}
+	// the pod has no IP, as no kubelet runs it
+	if len(set.Namespaces[0].Pods) != 0 {
+		t.Errorf("expected no pods, got %v", set.Namespaces[0].Pods)
+	}
+
+	// the records derived from the cluster are those derived from the fixtures
+	records := func(s *Set) map[string]string {
+		m := map[string]string{}
+		for _, tc := range s.Cases("cluster.local.") {
+			sort.Sort(test.RRSet(tc.Answer))
+			m[tc.Qname+" "+dns.TypeToString[tc.Qtype]] = fmt.Sprint(dns.RcodeToString[tc.Rcode], tc.Answer)
+		}
+		return m
+	}
+	got, want := records(set), records(&testSet)
+	want["external.test-1.svc.cluster.local. CNAME"] = "NOERROR[external.test-1.svc.cluster.local.\t303\tIN\tCNAME\texample.net.]"
+	for key, w := range want {
+		if got[key] != w {
+			t.Errorf("%s: expected %s, got %s", key, w, got[key])
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("expected %d cases, got %d", len(want), len(got))
+	}
+}
+
+func TestTransferRecords(t *testing.T) {
+	records := map[string]bool{}
+	for _, rr := range testSet.TransferRecords("cluster.local.") {
+		records[rr.String()] = true
+	}
+	for _, rr := range []string{
+		"svc-1-a.test-1.svc.cluster.local.\t303\tIN\tA\t10.96.0.100",
+		"svc-1-a.test-1.svc.cluster.local.\t303\tIN\tSRV\t0 100 80 svc-1-a.test-1.svc.cluster.local.",
+		"_https._tcp.svc-1-a.test-1.svc.cluster.local.\t303\tIN\tSRV\t0 100 443 svc-1-a.test-1.svc.cluster.local.",
+		"headless-svc.test-1.svc.cluster.local.\t303\tIN\tAAAA\t1234:abcd::1",
+		"172-17-0-254.headless-svc.test-1.svc.cluster.local.\t303\tIN\tA\t172.17.0.254",
+		"_c-port._udp.headless-svc.test-1.svc.cluster.local.\t303\tIN\tSRV\t0 100 1234 headless-svc-3.headless-svc.test-1.svc.cluster.local.",
+	} {
+		if !records[rr] {
+			t.Errorf("missing record %s", rr)
+		}
+	}
+	// endpoints of ClusterIP services are not transferred
+	if records["svc-1-a.svc-1-a.test-1.svc.cluster.local.\t303\tIN\tA\t172.17.0.253"] {
+		t.Errorf("unexpected record for endpoint of ClusterIP service")
+	}
+	if len(records) != 11 {
+		t.Errorf("expected 11 records, got %d: %v", len(records), records)
+	}
+}
@@ -0,0 +1,85 @@
+package fixture
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// Address ranges of generated sets. They are only applied as they are to a fake clientset, which has no
+// allocator, so they are large private ranges. In a cluster the ClusterIPs are allocated, see AllocateClusterIPs.
+var (
+	generatedClusterIPs = ipRange{base: net.IPv4(10, 128, 0, 0), size: 1 << 23}
+	generatedEndpoints  = ipRange{base: net.IPv4(172, 16, 0, 0), size: 1 << 20}
+)
+
+// maxGeneratedEndpoints is the maximum number of endpoints of a generated service, which is the maximum
+// number of endpoints of an EndpointSlice.
+const maxGeneratedEndpoints = 1000
+
+// Generate returns a synthetic set of namespaces namespaces named <prefix>-<n>, each with services services
+// that have endpoints endpoints, for scale testing. Every other service is headless, so the records of both
+// kinds of services are exercised. Addresses are assigned sequentially, so a set is the same on every run.
+func Generate(prefix string, namespaces, services, endpoints int) (*Set, error) {
+	if endpoints > maxGeneratedEndpoints {
+		return nil, fmt.Errorf("at most %d endpoints per service can be generated, not %d", maxGeneratedEndpoints, endpoints)
+	}
+	total := namespaces * services
+	if clusterIPs := (total + 1) / 2; clusterIPs > generatedClusterIPs.size {
+		return nil, fmt.Errorf("at most %d cluster IPs can be generated, not %d", generatedClusterIPs.size, clusterIPs)
+	}
+	if total*endpoints > generatedEndpoints.size {
+		return nil, fmt.Errorf("at most %d endpoints can be generated, not %d", generatedEndpoints.size, total*endpoints)
+	}
+
+	set := &Set{Namespaces: make([]Namespace, namespaces)}
+	clusterIP, endpointIP := 0, 0
+	for i := range set.Namespaces {
+		ns := Namespace{Name: fmt.Sprintf("%s-%d", prefix, i), Services: make([]Service, services)}
+		for j := range ns.Services {
+			svc := Service{
+				Name:      fmt.Sprintf("svc-%d", j),
+				Ports:     []Port{{Name: "http", Port: 80}},
+				Endpoints: make([]Endpoint, endpoints),
+			}
+			if j%2 == 0 {
+				svc.ClusterIPs = []string{generatedClusterIPs.ip(clusterIP)}
+				clusterIP++
+			}
+			for k := range svc.Endpoints {
+				svc.Endpoints[k] = Endpoint{IP: generatedEndpoints.ip(endpointIP)}
+				endpointIP++
+			}
+			ns.Services[j] = svc
+		}
+		set.Namespaces[i] = ns
+	}
+	return set, nil
+}
+
+// ipRange is a range of size IPv4 addresses starting at base.
+type ipRange struct {
+	base net.IP
+	size int
+}
+
+// ip returns the i-th address of the range.
+func (r ipRange) ip(i int) string {
+	ip := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(ip, binary.BigEndian.Uint32(r.base.To4())+uint32(i))
+	return ip.String()
+}
+
+// AllocateClusterIPs replaces the ClusterIPs of the services of the set by ones allocated by the cluster when it
+// is applied, as pinned ClusterIPs would collide with those the cluster allocates. Cases can only be derived
+// after LoadClusterIPs.
+func (s *Set) AllocateClusterIPs() {
+	for i := range s.Namespaces {
+		for j := range s.Namespaces[i].Services {
+			svc := &s.Namespaces[i].Services[j]
+			if len(svc.ClusterIPs) > 0 {
+				svc.ClusterIPs, svc.AllocateClusterIP = nil, true
+			}
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package fixture
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	discovery "k8s.io/api/discovery/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// pollInterval is the interval at which Wait checks the state of the fixtures.
+const pollInterval = time.Second
+
+// Wait waits until every pod of the set has an IP, and every service has as many ready endpoints in its
+// EndpointSlices as the set declares, or until ctx is done. The IPs of the pods are loaded into the set,
+// so Cases derives the endpoints of services with a selector.
+func (s *Set) Wait(ctx context.Context, client kubernetes.Interface) error {
+	for {
+		pending, err := s.pending(ctx, client)
+		if err != nil {
+			return err
+		}
+		if len(pending) == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("fixtures not ready: %s", strings.Join(pending, ", "))
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// pending returns a description of the objects of the set that are not ready yet.
+func (s *Set) pending(ctx context.Context, client kubernetes.Interface) ([]string, error) {
+	if err := s.LoadPodIPs(ctx, client); err != nil {
+		return nil, err
+	}
+
+	var pending []string
+	for _, ns := range s.Namespaces {
+		for _, pod := range ns.Pods {
+			if pod.IP == "" {
+				pending = append(pending, fmt.Sprintf("pod %s/%s has no IP", ns.Name, pod.Name))
+			}
+		}
+		for _, svc := range ns.Services {
+			want := len(svc.readyEndpoints(ns))
+			slices, err := client.DiscoveryV1().EndpointSlices(ns.Name).List(ctx, meta.ListOptions{
+				LabelSelector: discovery.LabelServiceName + "=" + svc.Name,
+			})
+			if err != nil {
+				return nil, err
+			}
+			got := 0
+			for _, es := range slices.Items {
+				for _, ep := range es.Endpoints {
+					// a nil ready condition is to be interpreted as ready
+					if ep.Conditions.Ready == nil || *ep.Conditions.Ready {
+						got++
+					}
+				}
+			}
+			if got != want {
+				pending = append(pending, fmt.Sprintf("service %s/%s has %d of %d ready endpoints", ns.Name, svc.Name, got, want))
+			}
+		}
+	}
+	return pending, nil
+}
@@ -0,0 +1,84 @@
+package kubernetes
+
+import (
+	"testing"
+	"time"
+
+	"github.com/coredns/ci/test/kubernetes/fixture"
+
+	api "k8s.io/api/core/v1"
+)
+
+// fixtureNamespace holds the fixtures, it is replaced by a namespace created for the test.
+var fixtureNamespace = fixture.Namespace{
+	Services: []fixture.Service{
+		{
+			Name:       "svc-a",
+			ClusterIPs: []string{"10.96.0.16"},
+			Ports:      []fixture.Port{{Name: "http", Port: 80}, {Name: "https", Port: 443, TargetPort: 8443}},
+			Endpoints:  []fixture.Endpoint{{IP: "172.17.1.10", Hostname: "svc-a"}},
+		},
+		{
+			Name:  "headless-svc",
+			Ports: []fixture.Port{{Name: "c-port", Port: 1234, Protocol: api.ProtocolUDP}},
+			Endpoints: []fixture.Endpoint{
+				{IP: "172.17.1.20", Hostname: "headless-1"},
+				{IP: "172.17.1.21"},
+				{IP: "1234:abcd::20", Hostname: "headless-3"},
+				{IP: "172.17.1.22", NotReady: true},
+			},
+		},
+		{
+			Name:      "svc-unready",
+			Ports:     []fixture.Port{{Name: "http", Port: 80}},
+			Endpoints: []fixture.Endpoint{{IP: "172.17.1.30", NotReady: true}},
+		},
+		{
+			Name:     "headless-pods",
+			Ports:    []fixture.Port{{Name: "http", Port: 80}},
+			Selector: map[string]string{"app": "headless-pods"},
+		},
+		{
+			Name:       "svc-pods",
+			ClusterIPs: []string{"10.96.0.17"},
+			Ports:      []fixture.Port{{Name: "http", Port: 80}},
+			Selector:   map[string]string{"app": "svc-pods"},
+		},
+	},
+	Pods: []fixture.Pod{
+		{Name: "pod-1", Labels: map[string]string{"app": "headless-pods"}, Hostname: "pod-1", Subdomain: "headless-pods"},
+		{Name: "pod-2", Labels: map[string]string{"app": "headless-pods"}},
+		{Name: "pod-3", Labels: map[string]string{"app": "svc-pods"}},
+		{Name: "pod-unready", Labels: map[string]string{"app": "svc-pods"}, NotReady: true},
+	},
+}
+
+func TestKubernetesFixture(t *testing.T) {
+	ns := fixtureNamespace
+	ns.Name = NewTestNamespace(t)
+	fixtures := fixture.Set{Namespaces: []fixture.Namespace{ns}}
+
+	corefile := `    .:53 {
+        health
+        ready
+        errors
+        log
+        kubernetes cluster.local 10.in-addr.arpa 172.in-addr.arpa ip6.arpa {
+            namespaces ` + ns.Name + `
+        }
+    }
+`
+	err := LoadCorefile(corefile)
+	if err != nil {
+		t.Fatalf("Could not load corefile: %s", err)
+	}
+
+	ApplyFixtures(t, &fixtures)
+
+	// the fixtures are ready, but CoreDNS may not have seen them yet
+	var cases []Case
+	for _, tc := range fixtures.Cases("cluster.local") {
+		cases = append(cases, Case{Case: tc, Retry: RetryPolicy{Transport: 2, Assertion: 5, Interval: time.Second}})
+	}
+	DoIntegrationCases(t, cases, ns.Name)
+}
@@ -0,0 +1,31 @@
+package kubernetes
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/coredns/ci/test/kubernetes/fixture"
+)
+
+// fixtureReadyTimeout is how long ApplyFixtures waits for fixtures to become ready.
+const fixtureReadyTimeout = 2 * time.Minute
+
+// ApplyFixtures creates the objects of set and waits until its pods have IPs and its services have their
+// ready endpoints, so the cases derived from set can be run. The namespaces of the set are not deleted,
+// use namespaces created with NewTestNamespace for that.
+func ApplyFixtures(t *testing.T, set *fixture.Set) {
+	client, err := NewClient()
+	if err != nil {
+		t.Fatalf("could not create kubernetes client: %s", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), fixtureReadyTimeout)
+	defer cancel()
+
+	if err := set.Apply(ctx, client); err != nil {
+		t.Fatalf("could not apply fixtures: %s", err)
+	}
+	if err := set.Wait(ctx, client); err != nil {
+		t.Fatalf("fixtures did not become ready: %s", err)
+	}
+}
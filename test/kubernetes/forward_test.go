@@ -0,0 +1,345 @@
+package kubernetes
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coredns/ci/test/kubernetes/fakeupstream"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+// forwardName is the name the fake upstreams answer, each with an address of its own.
+const forwardName = "example.net."
+
+// forwardUpstream is a fake upstream on the test host, which CoreDNS forwards forwardName to.
+type forwardUpstream struct {
+	*fakeupstream.Server
+	ip string // the address it answers forwardName with
+}
+
+func startForwardUpstream(t *testing.T, ip string) *forwardUpstream {
+	s, err := fakeupstream.Start(net.JoinHostPort(locaIP().String(), "0"), []dns.RR{test.A(forwardName + " 5 IN A " + ip)})
+	if err != nil {
+		t.Fatalf("could not start fake upstream: %s", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return &forwardUpstream{Server: s, ip: ip}
+}
+
+// queries returns the queries the upstream received, without the health checks.
+func (u *forwardUpstream) queries() []fakeupstream.Query {
+	var qs []fakeupstream.Query
+	for _, q := range u.Queries() {
+		if !q.HealthCheck() {
+			qs = append(qs, q)
+		}
+	}
+	return qs
+}
+
+// healthChecks returns the number of health checks the upstream received.
+func (u *forwardUpstream) healthChecks() int {
+	n := 0
+	for _, q := range u.Queries() {
+		if q.HealthCheck() {
+			n++
+		}
+	}
+	return n
+}
+
+// loadForwardCorefile loads CoreDNS with the forward plugin for forwardName to the upstreams, with options,
+// resets the upstreams to answer every query, and waits until CoreDNS answers on udp. CoreDNS is restarted, so
+// the upstreams are healthy and the metrics start from zero.
+func loadForwardCorefile(t *testing.T, udp, options string, upstreams ...*forwardUpstream) {
+	to := ""
+	for _, u := range upstreams {
+		u.SetScript(fakeupstream.Always(fakeupstream.Behavior{}))
+		to += " " + u.Addr
+	}
+	corefile := `    .:53 {
+        health
+        ready
+        errors
+        log
+        prometheus :9153
+        forward ` + forwardName + to + ` {` + options + `
+        }
+    }
+`
+	if err := LoadCorefile(corefile); err != nil {
+		t.Fatalf("Could not load corefile: %s", err)
+	}
+	if err := WaitForServer(udp, nil, exposeTimeout); err != nil {
+		t.Fatalf("coredns not reachable on node port %s: %s", udp, err)
+	}
+	for _, u := range upstreams {
+		u.Reset()
+	}
+}
+
+// forwardQuery queries CoreDNS on addr over net for forwardName, and returns the response and how long it took.
+func forwardQuery(t *testing.T, net, addr string) (*dns.Msg, time.Duration) {
+	t.Helper()
+	m := new(dns.Msg)
+	m.SetQuestion(forwardName, dns.TypeA)
+	r, rtt, err := (&dns.Client{Net: net, Timeout: transportTimeout}).Exchange(m, addr)
+	if err != nil {
+		t.Fatalf("could not query %s over %s: %s", forwardName, net, err)
+	}
+	return r, rtt
+}
+
+// checkForwardAnswer checks that r is the answer of upstream u, or has rcode and no answer if u is nil.
+func checkForwardAnswer(t *testing.T, r *dns.Msg, rcode int, u *forwardUpstream) {
+	t.Helper()
+	if r.Rcode != rcode {
+		t.Fatalf("expected rcode %s, got %s", dns.RcodeToString[rcode], dns.RcodeToString[r.Rcode])
+	}
+	if u == nil {
+		if len(r.Answer) != 0 {
+			t.Fatalf("expected no answer, got %v", r.Answer)
+		}
+		return
+	}
+	if len(r.Answer) != 1 {
+		t.Fatalf("expected the answer of %s, got %v", u.Addr, r.Answer)
+	}
+	if a, ok := r.Answer[0].(*dns.A); !ok || a.A.String() != u.ip {
+		t.Fatalf("expected the answer of %s, %s, got %s", u.Addr, u.ip, r.Answer[0])
+	}
+}
+
+// TestKubernetesForward forwards a name to two fake upstreams on the test host, whose behavior is scripted per
+// query, and checks the answers, latency and metrics of CoreDNS while the upstreams are down, fail, are slow,
+// or truncate their answers.
+func TestKubernetesForward(t *testing.T) {
+	udp, tcp := ExposeCoreDNS(t)
+	up1 := startForwardUpstream(t, "192.0.2.1")
+	up2 := startForwardUpstream(t, "192.0.2.2")
+	up1Labels := map[string]string{"to": up1.Addr}
+
+	t.Run("failover", func(t *testing.T) {
+		loadForwardCorefile(t, udp, "\n            policy sequential", up1, up2)
+		before := ScrapeMetricFamilies(t)
+
+		// the query to up1 times out after the 2s of the forward plugin, and is retried on up2
+		up1.SetScript(fakeupstream.Always(fakeupstream.Behavior{Drop: true}))
+		r, rtt := forwardQuery(t, "udp", udp)
+		checkForwardAnswer(t, r, dns.RcodeSuccess, up2)
+		if rtt < 2*time.Second {
+			t.Errorf("expected the answer after the timeout of the first upstream, got it after %s", rtt)
+		}
+
+		// the failed query starts the health checks of up1, which mark it down after more than max_fails failures,
+		// so up2 answers without waiting for it
+		deadline := time.Now().Add(15 * time.Second)
+		for {
+			r, rtt = forwardQuery(t, "udp", udp)
+			checkForwardAnswer(t, r, dns.RcodeSuccess, up2)
+			if rtt < time.Second {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("expected %s to be marked down, queries still take %s", up1.Addr, rtt)
+			}
+		}
+		if n := up1.healthChecks(); n < 3 {
+			t.Errorf("expected at least 3 health checks of %s, got %d", up1.Addr, n)
+		}
+		after := ScrapeMetricFamilies(t)
+		if d := after.Delta(before, "coredns_proxy_healthcheck_failures_total", up1Labels); d < 3 {
+			t.Errorf("expected at least 3 failed health checks of %s, got %v", up1.Addr, d)
+		}
+		if d := after.Delta(before, "coredns_proxy_healthcheck_failures_total", map[string]string{"to": up2.Addr}); d != 0 {
+			t.Errorf("expected no failed health checks of %s, got %v", up2.Addr, d)
+		}
+
+		// once up1 answers again, the next health check marks it up, and it answers first again
+		up1.SetScript(fakeupstream.Always(fakeupstream.Behavior{}))
+		deadline = time.Now().Add(10 * time.Second)
+		for {
+			r, _ = forwardQuery(t, "udp", udp)
+			if len(r.Answer) == 1 && r.Answer[0].(*dns.A).A.String() == up1.ip {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("expected %s to recover, got %v", up1.Addr, r.Answer)
+			}
+			time.Sleep(500 * time.Millisecond)
+		}
+	})
+
+	t.Run("all upstreams down", func(t *testing.T) {
+		loadForwardCorefile(t, udp, "", up1, up2)
+		before := ScrapeMetricFamilies(t)
+
+		up1.SetScript(fakeupstream.Always(fakeupstream.Behavior{Drop: true}))
+		up2.SetScript(fakeupstream.Always(fakeupstream.Behavior{Drop: true}))
+		r, _ := forwardQuery(t, "udp", udp)
+		checkForwardAnswer(t, r, dns.RcodeServerFailure, nil)
+		// once the health checks marked both down, the forward plugin assumes the health checks are broken, and
+		// tries one of them anyway
+		time.Sleep(5 * time.Second)
+		r, _ = forwardQuery(t, "udp", udp)
+		checkForwardAnswer(t, r, dns.RcodeServerFailure, nil)
+
+		if d := ScrapeMetricFamilies(t).Delta(before, "coredns_forward_healthcheck_broken_total", nil); d < 1 {
+			t.Errorf("expected the health checks to be considered broken, got %v", d)
+		}
+	})
+
+	t.Run("servfail", func(t *testing.T) {
+		loadForwardCorefile(t, udp, "\n            policy sequential", up1, up2)
+		before := ScrapeMetricFamilies(t)
+
+		// a SERVFAIL of an upstream is an answer, it is passed on and not retried on another upstream
+		up1.SetScript(fakeupstream.Always(fakeupstream.Behavior{Rcode: dns.RcodeServerFailure}))
+		r, _ := forwardQuery(t, "udp", udp)
+		checkForwardAnswer(t, r, dns.RcodeServerFailure, nil)
+		if n := len(up2.queries()); n != 0 {
+			t.Errorf("expected no queries to %s, got %d", up2.Addr, n)
+		}
+
+		after := ScrapeMetricFamilies(t)
+		servfail := map[string]string{"to": up1.Addr, "rcode": "SERVFAIL"}
+		if d := after.Delta(before, "coredns_proxy_request_duration_seconds", servfail); d != 1 {
+			t.Errorf("expected 1 request to %s answered with SERVFAIL, got %v", up1.Addr, d)
+		}
+		if d := after.Delta(before, "coredns_proxy_healthcheck_failures_total", up1Labels); d != 0 {
+			t.Errorf("expected no failed health checks of %s, got %v", up1.Addr, d)
+		}
+	})
+
+	t.Run("slow upstream", func(t *testing.T) {
+		loadForwardCorefile(t, udp, "\n            policy sequential", up1, up2)
+		before := ScrapeMetricFamilies(t)
+
+		// an upstream slower than usual, but within the timeout, is waited for
+		up1.SetScript(fakeupstream.Always(fakeupstream.Behavior{Delay: time.Second}))
+		r, rtt := forwardQuery(t, "udp", udp)
+		checkForwardAnswer(t, r, dns.RcodeSuccess, up1)
+		if rtt < time.Second {
+			t.Errorf("expected the answer after the delay of 1s, got it after %s", rtt)
+		}
+		if n := len(up2.queries()); n != 0 {
+			t.Errorf("expected no queries to %s, got %d", up2.Addr, n)
+		}
+
+		noerror := map[string]string{"to": up1.Addr, "rcode": "NOERROR"}
+		if d := ScrapeMetricFamilies(t).Delta(before, "coredns_proxy_request_duration_seconds", noerror); d != 1 {
+			t.Errorf("expected 1 request to %s answered with NOERROR, got %v", up1.Addr, d)
+		}
+	})
+
+	t.Run("max_concurrent", func(t *testing.T) {
+		loadForwardCorefile(t, udp, "\n            max_concurrent 2", up1)
+		before := ScrapeMetricFamilies(t)
+
+		// the queries beyond the 2 waiting for the slow upstream are refused right away
+		up1.SetScript(fakeupstream.Always(fakeupstream.Behavior{Delay: time.Second}))
+		var wg sync.WaitGroup
+		responses := make([]*dns.Msg, 6)
+		for i := range responses {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				m := new(dns.Msg)
+				m.SetQuestion(forwardName, dns.TypeA)
+				responses[i], _ = Exchange(m, udp, nil)
+			}(i)
+		}
+		wg.Wait()
+
+		rcodes := map[int]int{}
+		for _, r := range responses {
+			if r == nil {
+				t.Fatal("expected all queries to be answered")
+			}
+			rcodes[r.Rcode]++
+		}
+		if rcodes[dns.RcodeSuccess] != 2 || rcodes[dns.RcodeRefused] != 4 {
+			t.Errorf("expected 2 answers and 4 REFUSED, got %d and %d", rcodes[dns.RcodeSuccess], rcodes[dns.RcodeRefused])
+		}
+		if d := ScrapeMetricFamilies(t).Delta(before, "coredns_forward_max_concurrent_rejects_total", nil); d != 4 {
+			t.Errorf("expected 4 rejected queries, got %v", d)
+		}
+	})
+
+	t.Run("transport", func(t *testing.T) {
+		// the upstream truncates answers over UDP, and answers over TCP
+		for _, tc := range []struct {
+			name    string
+			options string
+			net     string   // of the query to CoreDNS
+			tc      bool     // whether the answer is truncated
+			nets    []string // of the queries to the upstream
+		}{
+			{"default udp", "", "udp", true, []string{"udp"}},
+			{"default tcp", "", "tcp", false, []string{"tcp"}},
+			{"force_tcp", "\n            force_tcp", "udp", false, []string{"tcp"}},
+			{"prefer_udp", "\n            prefer_udp", "tcp", false, []string{"udp", "tcp"}},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				loadForwardCorefile(t, udp, tc.options, up1)
+				up1.SetScript(fakeupstream.Always(fakeupstream.Behavior{Truncate: true}))
+
+				addr := udp
+				if tc.net == "tcp" {
+					addr = tcp
+				}
+				r, _ := forwardQuery(t, tc.net, addr)
+				if r.Truncated != tc.tc {
+					t.Errorf("expected TC %t, got %t", tc.tc, r.Truncated)
+				}
+				if tc.tc {
+					checkForwardAnswer(t, r, dns.RcodeSuccess, nil)
+				} else {
+					checkForwardAnswer(t, r, dns.RcodeSuccess, up1)
+				}
+
+				var nets []string
+				for _, q := range up1.queries() {
+					nets = append(nets, q.Net)
+				}
+				if len(nets) != len(tc.nets) {
+					t.Fatalf("expected queries to the upstream over %v, got %v", tc.nets, nets)
+				}
+				for i := range nets {
+					if nets[i] != tc.nets[i] {
+						t.Fatalf("expected queries to the upstream over %v, got %v", tc.nets, nets)
+					}
+				}
+			})
+		}
+	})
+
+	t.Run("policy", func(t *testing.T) {
+		for _, tc := range []struct {
+			policy string
+			check  func(n1, n2 int) bool
+		}{
+			{"sequential", func(n1, n2 int) bool { return n1 == 20 && n2 == 0 }},
+			{"round_robin", func(n1, n2 int) bool { return n1 == 10 && n2 == 10 }},
+			{"random", func(n1, n2 int) bool { return n1 > 0 && n2 > 0 }},
+		} {
+			t.Run(tc.policy, func(t *testing.T) {
+				loadForwardCorefile(t, udp, "\n            policy "+tc.policy, up1, up2)
+				for i := 0; i < 20; i++ {
+					r, _ := forwardQuery(t, "udp", udp)
+					if r.Rcode != dns.RcodeSuccess || len(r.Answer) != 1 {
+						t.Fatalf("expected an answer, got %s", r)
+					}
+				}
+				n1, n2 := len(up1.queries()), len(up2.queries())
+				if !tc.check(n1, n2) {
+					t.Errorf("unexpected distribution of 20 queries for policy %s: %d to %s and %d to %s", tc.policy, n1, up1.Addr, n2, up2.Addr)
+				}
+			})
+		}
+	})
+}
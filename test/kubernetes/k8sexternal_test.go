@@ -0,0 +1,163 @@
+package kubernetes
+
+import (
+	"testing"
+	"time"
+
+	"github.com/coredns/ci/test/kubernetes/fixture"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+// k8sExternalNamespace is the namespace the k8s_external cases are written for, it is replaced by a namespace
+// created for the test.
+const k8sExternalNamespace = "test-k8sext"
+
+// k8sExternalServices are services with external IPs and load balancers. The ingress of the load balancers is
+// set on their status, as no cloud provider assigns one.
+var k8sExternalServices = []fixture.Service{
+	{Name: "ext-ips", ClusterIPs: []string{"10.96.0.36"}, Ports: []fixture.Port{{Name: "http", Port: 80}},
+		ExternalIPs: []string{"192.0.2.100", "2001:db8::100"}},
+	{Name: "lb-ip", ClusterIPs: []string{"10.96.0.37"}, Ports: []fixture.Port{{Name: "http", Port: 80}},
+		LoadBalancerIngress: []string{"192.0.2.101"}},
+	{Name: "lb-host", ClusterIPs: []string{"10.96.0.38"}, Ports: []fixture.Port{{Name: "http", Port: 80}},
+		LoadBalancerIngress: []string{"example.net"}},
+	{Name: "clusterip-only", ClusterIPs: []string{"10.96.0.39"}, Ports: []fixture.Port{{Name: "http", Port: 80}}},
+}
+
+var dnsTestCasesK8sExternal = []test.Case{
+	{ // An external IP has an A record in the external zone, with the TTL of k8s_external
+		Qname: "ext-ips.test-k8sext.external.test.", Qtype: dns.TypeA,
+		Rcode: dns.RcodeSuccess,
+		Answer: []dns.RR{
+			test.A("ext-ips.test-k8sext.external.test. 60 IN A 192.0.2.100"),
+		},
+	},
+	{
+		Qname: "ext-ips.test-k8sext.external.test.", Qtype: dns.TypeAAAA,
+		Rcode: dns.RcodeSuccess,
+		Answer: []dns.RR{
+			test.AAAA("ext-ips.test-k8sext.external.test. 60 IN AAAA 2001:db8::100"),
+		},
+	},
+	{ // The SRV record of a port points to the service, for all its external IPs
+		Qname: "_http._tcp.ext-ips.test-k8sext.external.test.", Qtype: dns.TypeSRV,
+		Rcode: dns.RcodeSuccess,
+		Answer: []dns.RR{
+			test.SRV("_http._tcp.ext-ips.test-k8sext.external.test. 303 IN SRV 0 50 80 ext-ips.test-k8sext.external.test."),
+		},
+		Extra: []dns.RR{
+			test.A("ext-ips.test-k8sext.external.test. 60 IN A 192.0.2.100"),
+			test.AAAA("ext-ips.test-k8sext.external.test. 60 IN AAAA 2001:db8::100"),
+		},
+	},
+	{ // The cluster zone still has the ClusterIP
+		Qname: "ext-ips.test-k8sext.svc.cluster.local.", Qtype: dns.TypeA,
+		Rcode: dns.RcodeSuccess,
+		Answer: []dns.RR{
+			test.A("ext-ips.test-k8sext.svc.cluster.local. 303 IN A 10.96.0.36"),
+		},
+	},
+	{ // The ingress IP of a load balancer has an A record
+		Qname: "lb-ip.test-k8sext.external.test.", Qtype: dns.TypeA,
+		Rcode: dns.RcodeSuccess,
+		Answer: []dns.RR{
+			test.A("lb-ip.test-k8sext.external.test. 60 IN A 192.0.2.101"),
+		},
+	},
+	{ // but no AAAA record
+		Qname: "lb-ip.test-k8sext.external.test.", Qtype: dns.TypeAAAA,
+		Rcode: dns.RcodeSuccess,
+		Ns: []dns.RR{
+			test.SOA("external.test. 60 IN SOA ns1.apex.external.test. hostmaster.apex.external.test. 1502313310 7200 1800 86400 60"),
+		},
+	},
+	{ // The ingress hostname of a load balancer is a CNAME, resolved by the upstream
+		Qname: "lb-host.test-k8sext.external.test.", Qtype: dns.TypeA,
+		Rcode: dns.RcodeSuccess,
+		Answer: []dns.RR{
+			test.CNAME("lb-host.test-k8sext.external.test. 303 IN CNAME example.net."),
+			test.A("example.net. 303 IN A 13.14.15.16"),
+		},
+	},
+	{ // A service with only a ClusterIP does not exist in the external zone
+		Qname: "clusterip-only.test-k8sext.external.test.", Qtype: dns.TypeA,
+		Rcode: dns.RcodeNameError,
+		Ns: []dns.RR{
+			test.SOA("external.test. 60 IN SOA ns1.apex.external.test. hostmaster.apex.external.test. 1502313310 7200 1800 86400 60"),
+		},
+	},
+	{ // nor does a service that does not exist
+		Qname: "svc-none.test-k8sext.external.test.", Qtype: dns.TypeA,
+		Rcode: dns.RcodeNameError,
+		Ns: []dns.RR{
+			test.SOA("external.test. 60 IN SOA ns1.apex.external.test. hostmaster.apex.external.test. 1502313310 7200 1800 86400 60"),
+		},
+	},
+	{ // The SOA of the zone has the nameserver below the apex
+		Qname: "external.test.", Qtype: dns.TypeSOA,
+		Rcode: dns.RcodeSuccess,
+		Answer: []dns.RR{
+			test.SOA("external.test. 60 IN SOA ns1.apex.external.test. hostmaster.apex.external.test. 1502313310 7200 1800 86400 60"),
+		},
+	},
+	{ // The CoreDNS service has no external IPs, so its nameserver has no addresses
+		Qname: "external.test.", Qtype: dns.TypeNS,
+		Rcode: dns.RcodeSuccess,
+		Answer: []dns.RR{
+			test.NS("external.test. 60 IN NS ns1.apex.external.test."),
+		},
+	},
+	{
+		Qname: "ns1.apex.external.test.", Qtype: dns.TypeA,
+		Rcode: dns.RcodeSuccess,
+		Ns: []dns.RR{
+			test.SOA("external.test. 60 IN SOA ns1.apex.external.test. hostmaster.apex.external.test. 1502313310 7200 1800 86400 60"),
+		},
+	},
+	{ // Other names below the apex do not exist
+		Qname: "ns2.apex.external.test.", Qtype: dns.TypeA,
+		Rcode: dns.RcodeNameError,
+		Ns: []dns.RR{
+			test.SOA("external.test. 60 IN SOA ns1.apex.external.test. hostmaster.apex.external.test. 1502313310 7200 1800 86400 60"),
+		},
+	},
+}
+
+// TestKubernetesK8sExternal serves the external IPs and load balancer ingress of services in an external zone
+// with k8s_external, with a custom apex and TTL.
+func TestKubernetesK8sExternal(t *testing.T) {
+	rmFunc, upstream, udp := UpstreamServer(t, "example.net", ExampleNet)
+	defer upstream.Stop()
+	defer rmFunc()
+
+	namespace := NewTestNamespace(t)
+	corefile := `    .:53 {
+        health
+        ready
+        errors
+        log
+        kubernetes cluster.local {
+            namespaces ` + namespace + `
+        }
+        k8s_external external.test {
+            apex apex
+            ttl 60
+        }
+        forward . ` + udp + `
+    }
+`
+	err := LoadCorefile(corefile)
+	if err != nil {
+		t.Fatalf("Could not load corefile: %s", err)
+	}
+	ApplyFixtures(t, &fixture.Set{Namespaces: []fixture.Namespace{{Name: namespace, Services: k8sExternalServices}}})
+
+	// the fixtures are ready, but CoreDNS may not have seen them yet
+	var cases []Case
+	for _, tc := range NamespacedCases(dnsTestCasesK8sExternal, k8sExternalNamespace, namespace) {
+		cases = append(cases, Case{Case: tc, Retry: RetryPolicy{Transport: 2, Assertion: 5, Interval: time.Second}})
+	}
+	DoIntegrationCases(t, cases, namespace)
+}
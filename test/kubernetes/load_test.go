@@ -0,0 +1,58 @@
+package kubernetes
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/coredns/ci/test/kubernetes/loadgen"
+)
+
+// TestKubernetesLoad sends the query mix of the config file in $LOAD_CONFIG, e.g. testdata/load.yaml, to CoreDNS,
+// and fails if the result exceeds its thresholds. It is skipped if $LOAD_CONFIG is not set, as its latency
+// thresholds depend on the machine, and the forwarded names on the upstream resolvers.
+func TestKubernetesLoad(t *testing.T) {
+	path := os.Getenv("LOAD_CONFIG")
+	if path == "" {
+		t.Skip("skipping load test, set $LOAD_CONFIG to run it")
+	}
+	cfg, err := loadgen.LoadConfig(path)
+	if err != nil {
+		t.Fatalf("could not load config: %s", err)
+	}
+
+	corefile := `    .:53 {
+        errors
+        health
+        ready
+        kubernetes cluster.local in-addr.arpa ip6.arpa {
+            pods verified
+        }
+        forward . /etc/resolv.conf
+        cache 30
+    }
+`
+	err = LoadCorefile(corefile)
+	if err != nil {
+		t.Fatalf("Could not load corefile: %s", err)
+	}
+	udp, tcp := ExposeCoreDNS(t)
+	server := udp
+	if cfg.Net == "tcp" {
+		server = tcp
+	}
+
+	r, err := loadgen.Run(context.Background(), server, cfg)
+	if err != nil {
+		t.Fatalf("could not run load test: %s", err)
+	}
+	t.Log(r)
+	if dir := os.Getenv("REPORT_DIR"); dir != "" {
+		if err := r.Write(dir); err != nil {
+			t.Errorf("could not write result: %s", err)
+		}
+	}
+	for _, err := range r.Check(cfg.Thresholds) {
+		t.Error(err)
+	}
+}
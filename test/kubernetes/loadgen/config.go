@@ -0,0 +1,125 @@
+package loadgen
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"sigs.k8s.io/yaml"
+)
+
+// Config is a load test: the query mix, how it is sent, and the thresholds the result must stay within.
+type Config struct {
+	// Name identifies the load test in results, so results of the same test can be compared across runs.
+	Name        string     `json:"name"`
+	QPS         int        `json:"qps"`                   // target queries per second
+	Duration    Duration   `json:"duration"`              // how long queries are sent
+	Concurrency int        `json:"concurrency,omitempty"` // maximum number of outstanding queries, defaults to 100
+	Timeout     Duration   `json:"timeout"`               // query timeout, defaults to 2s
+	Net         string     `json:"net,omitempty"`         // "udp" (default) or "tcp"
+	Queries     []Query    `json:"queries"`
+	Thresholds  Thresholds `json:"thresholds"`
+}
+
+// Query is a query of the mix. Queries are picked at random in proportion to their weight.
+type Query struct {
+	Name   string `json:"name"`
+	Type   string `json:"type,omitempty"`   // defaults to A
+	Rcode  string `json:"rcode,omitempty"`  // expected rcode, defaults to NOERROR
+	Weight int    `json:"weight,omitempty"` // defaults to 1
+
+	qtype uint16
+	rcode int
+}
+
+// Thresholds a result must stay within. Zero values are not checked.
+type Thresholds struct {
+	P50 Duration `json:"p50"`
+	P95 Duration `json:"p95"`
+	P99 Duration `json:"p99"`
+	Max Duration `json:"max"`
+	// MinThroughput is the minimum fraction of the target QPS that must be answered.
+	MinThroughput float64 `json:"min_throughput,omitempty"`
+	// MaxTimeouts is the maximum fraction of the queries that may time out.
+	MaxTimeouts float64 `json:"max_timeouts,omitempty"`
+	// MaxUnexpected is the maximum fraction of the responses that may have an unexpected rcode.
+	MaxUnexpected float64 `json:"max_unexpected,omitempty"`
+}
+
+// Duration is a time.Duration written as a string such as "30s" in config files.
+type Duration struct{ time.Duration }
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	var err error
+	d.Duration, err = time.ParseDuration(s)
+	return err
+}
+
+// MarshalJSON implements json.Marshaler.
+func (d Duration) MarshalJSON() ([]byte, error) { return json.Marshal(d.String()) }
+
+// LoadConfig reads a config from a YAML or JSON file.
+func LoadConfig(path string) (Config, error) {
+	var cfg Config
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("could not parse %s: %s", path, err)
+	}
+	return cfg, cfg.init()
+}
+
+// init applies the defaults of the config and validates it.
+func (cfg *Config) init() error {
+	if cfg.QPS <= 0 {
+		return fmt.Errorf("qps must be positive")
+	}
+	if cfg.Duration.Duration <= 0 {
+		return fmt.Errorf("duration must be positive")
+	}
+	if len(cfg.Queries) == 0 {
+		return fmt.Errorf("no queries")
+	}
+	if cfg.Concurrency == 0 {
+		cfg.Concurrency = 100
+	}
+	if cfg.Timeout.Duration == 0 {
+		cfg.Timeout.Duration = 2 * time.Second
+	}
+	if cfg.Net == "" {
+		cfg.Net = "udp"
+	}
+	for i := range cfg.Queries {
+		q := &cfg.Queries[i]
+		q.Name = dns.Fqdn(q.Name)
+		if q.Type == "" {
+			q.Type = "A"
+		}
+		if q.Rcode == "" {
+			q.Rcode = "NOERROR"
+		}
+		if q.Weight == 0 {
+			q.Weight = 1
+		}
+		qtype, ok := dns.StringToType[strings.ToUpper(q.Type)]
+		if !ok {
+			return fmt.Errorf("unknown type %q of query %s", q.Type, q.Name)
+		}
+		rcode, ok := dns.StringToRcode[strings.ToUpper(q.Rcode)]
+		if !ok {
+			return fmt.Errorf("unknown rcode %q of query %s", q.Rcode, q.Name)
+		}
+		q.qtype, q.rcode = qtype, rcode
+	}
+	return nil
+}
@@ -0,0 +1,224 @@
+// Package loadgen sends a weighted mix of queries at a target rate to a DNS server, in the manner of dnsperf,
+// and reports throughput, latency percentiles, timeouts and the rcode distribution of the responses.
+package loadgen
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Result is the result of a load test.
+type Result struct {
+	Name       string         `json:"name"`
+	Timestamp  time.Time      `json:"timestamp"`
+	CoreDNS    string         `json:"coredns,omitempty"` // CoreDNS commit under test, from $COREDNS_COMMIT
+	Server     string         `json:"server"`
+	TargetQPS  int            `json:"target_qps"`
+	Duration   float64        `json:"duration_s"` // from the first query until the last response
+	Throughput float64        `json:"throughput"` // answered queries per second
+	Sent       int            `json:"sent"`
+	Answered   int            `json:"answered"`
+	Timeouts   int            `json:"timeouts"`
+	Errors     int            `json:"errors"`     // queries that failed other than by timing out
+	Unexpected int            `json:"unexpected"` // responses with an unexpected rcode
+	Rcodes     map[string]int `json:"rcodes"`
+	Latency    Latency        `json:"latency_ms"` // of the answered queries
+}
+
+// Latency is the distribution of query latencies in milliseconds.
+type Latency struct {
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P95  float64 `json:"p95"`
+	P99  float64 `json:"p99"`
+	Max  float64 `json:"max"`
+}
+
+// Run sends the queries of cfg to server until the duration of cfg has passed or ctx is done. Queries are sent
+// at the target QPS, unless the maximum number of outstanding queries is reached, which lowers the throughput.
+func Run(ctx context.Context, server string, cfg Config) (*Result, error) {
+	if err := cfg.init(); err != nil {
+		return nil, err
+	}
+	r := &Result{
+		Name:      cfg.Name,
+		Timestamp: time.Now().UTC(),
+		CoreDNS:   os.Getenv("COREDNS_COMMIT"),
+		Server:    server,
+		TargetQPS: cfg.QPS,
+		Rcodes:    make(map[string]int),
+	}
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		latencies []time.Duration
+	)
+	c := &dns.Client{Net: cfg.Net, Timeout: cfg.Timeout.Duration}
+	mix := newMix(cfg.Queries)
+	outstanding := make(chan struct{}, cfg.Concurrency)
+	interval := time.Second / time.Duration(cfg.QPS)
+	start := time.Now()
+	end := start.Add(cfg.Duration.Duration)
+
+send:
+	for i := 0; ; i++ {
+		next := start.Add(time.Duration(i) * interval)
+		if !next.Before(end) {
+			break
+		}
+		time.Sleep(time.Until(next))
+		select {
+		case outstanding <- struct{}{}:
+		case <-ctx.Done():
+			break send
+		}
+
+		q := mix.pick()
+		r.Sent++
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-outstanding }()
+			m := new(dns.Msg)
+			m.SetQuestion(q.Name, q.qtype)
+			res, rtt, err := c.Exchange(m, server)
+
+			mu.Lock()
+			defer mu.Unlock()
+			var nerr net.Error
+			switch {
+			case errors.As(err, &nerr) && nerr.Timeout():
+				r.Timeouts++
+			case err != nil:
+				r.Errors++
+			default:
+				r.Answered++
+				latencies = append(latencies, rtt)
+				r.Rcodes[dns.RcodeToString[res.Rcode]]++
+				if res.Rcode != q.rcode {
+					r.Unexpected++
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	elapsed := time.Since(start)
+	r.Duration = elapsed.Seconds()
+	r.Throughput = float64(r.Answered) / elapsed.Seconds()
+	r.Latency = Distribution(latencies)
+	return r, nil
+}
+
+// Check returns an error for every threshold the result exceeds.
+func (r *Result) Check(th Thresholds) []error {
+	var errs []error
+	for _, l := range []struct {
+		name      string
+		got       float64
+		threshold Duration
+	}{
+		{"p50", r.Latency.P50, th.P50},
+		{"p95", r.Latency.P95, th.P95},
+		{"p99", r.Latency.P99, th.P99},
+		{"max", r.Latency.Max, th.Max},
+	} {
+		if limit := milliseconds(l.threshold.Duration); limit > 0 && l.got > limit {
+			errs = append(errs, fmt.Errorf("%s latency %.2fms exceeds %s", l.name, l.got, l.threshold))
+		}
+	}
+	if limit := th.MinThroughput * float64(r.TargetQPS); th.MinThroughput > 0 && r.Throughput < limit {
+		errs = append(errs, fmt.Errorf("throughput %.0f qps is below %.0f qps", r.Throughput, limit))
+	}
+	if th.MaxTimeouts > 0 && ratio(r.Timeouts, r.Sent) > th.MaxTimeouts {
+		errs = append(errs, fmt.Errorf("%d of %d queries timed out, more than %.2f%%", r.Timeouts, r.Sent, th.MaxTimeouts*100))
+	}
+	if th.MaxUnexpected > 0 && ratio(r.Unexpected, r.Answered) > th.MaxUnexpected {
+		errs = append(errs, fmt.Errorf("%d of %d responses had an unexpected rcode, more than %.2f%%", r.Unexpected, r.Answered, th.MaxUnexpected*100))
+	}
+	return errs
+}
+
+// String returns a one line summary of the result.
+func (r *Result) String() string {
+	return fmt.Sprintf("%s: %d sent, %.0f qps, p50 %.2fms p95 %.2fms p99 %.2fms max %.2fms, %d timeouts, %d errors, rcodes %v",
+		r.Name, r.Sent, r.Throughput, r.Latency.P50, r.Latency.P95, r.Latency.P99, r.Latency.Max, r.Timeouts, r.Errors, r.Rcodes)
+}
+
+// Write writes the result as JSON to <dir>/<name>.json.
+func (r *Result) Write(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, r.Name+".json"), data, 0644)
+}
+
+// mix picks queries at random in proportion to their weight. The choice is seeded, so every run sends
+// the same sequence of queries.
+type mix struct {
+	queries []Query
+	total   []int // cumulative weights
+	rnd     *rand.Rand
+}
+
+func newMix(queries []Query) *mix {
+	m := &mix{queries: queries, rnd: rand.New(rand.NewSource(1))}
+	sum := 0
+	for _, q := range queries {
+		sum += q.Weight
+		m.total = append(m.total, sum)
+	}
+	return m
+}
+
+func (m *mix) pick() Query {
+	n := m.rnd.Intn(m.total[len(m.total)-1])
+	return m.queries[sort.SearchInts(m.total, n+1)]
+}
+
+// Distribution returns the distribution of latencies. The latencies are sorted in place.
+func Distribution(latencies []time.Duration) Latency {
+	if len(latencies) == 0 {
+		return Latency{}
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	var total time.Duration
+	for _, d := range latencies {
+		total += d
+	}
+	percentile := func(p float64) float64 {
+		return milliseconds(latencies[int(p*float64(len(latencies)-1))])
+	}
+	return Latency{
+		Mean: milliseconds(total / time.Duration(len(latencies))),
+		P50:  percentile(0.5),
+		P95:  percentile(0.95),
+		P99:  percentile(0.99),
+		Max:  milliseconds(latencies[len(latencies)-1]),
+	}
+}
+
+func ratio(n, of int) float64 {
+	if of == 0 {
+		return 0
+	}
+	return float64(n) / float64(of)
+}
+
+func milliseconds(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
@@ -0,0 +1,75 @@
+package loadgen
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/test"
+	ctest "github.com/coredns/coredns/test"
+)
+
+const exampleOrg = `$ORIGIN example.org.
+@	3600 IN	SOA sns.dns.icann.org. noc.dns.icann.org. 2017042745 7200 3600 1209600 3600
+	3600 IN NS a.iana-servers.net.
+a	3600 IN A 127.0.0.1
+`
+
+func TestRun(t *testing.T) {
+	zone, rm, err := test.TempFile(t.TempDir(), exampleOrg)
+	if err != nil {
+		t.Fatalf("could not create zone file: %s", err)
+	}
+	defer rm()
+	server, udp, _, err := ctest.CoreDNSServerAndPorts(`example.org:0 {
+    file ` + zone + `
+}`)
+	if err != nil {
+		t.Fatalf("could not start CoreDNS: %s", err)
+	}
+	defer server.Stop()
+
+	cfg, err := LoadConfig("testdata/config.yaml")
+	if err != nil {
+		t.Fatalf("could not load config: %s", err)
+	}
+	r, err := Run(context.Background(), udp, cfg)
+	if err != nil {
+		t.Fatalf("could not run load test: %s", err)
+	}
+	t.Log(r)
+
+	if r.Sent != 200 || r.Answered != 200 {
+		t.Errorf("expected 200 queries sent and answered, got %d and %d", r.Sent, r.Answered)
+	}
+	if r.Rcodes["NOERROR"] == 0 || r.Rcodes["NXDOMAIN"] == 0 || r.Rcodes["NOERROR"]+r.Rcodes["NXDOMAIN"] != r.Answered {
+		t.Errorf("expected NOERROR and NXDOMAIN responses only, got %v", r.Rcodes)
+	}
+	if r.Unexpected != 0 {
+		t.Errorf("expected no unexpected rcodes, got %d", r.Unexpected)
+	}
+	for _, err := range r.Check(cfg.Thresholds) {
+		t.Error(err)
+	}
+
+	strict := Thresholds{P50: Duration{time.Nanosecond}, MinThroughput: 2}
+	if errs := r.Check(strict); len(errs) != 2 {
+		t.Errorf("expected the latency and throughput thresholds to be exceeded, got %v", errs)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	cfg, err := LoadConfig("testdata/config.yaml")
+	if err != nil {
+		t.Fatalf("could not load config: %s", err)
+	}
+	if cfg.Duration.Duration != time.Second || cfg.Thresholds.P99.Duration != 500*time.Millisecond {
+		t.Errorf("unexpected durations %s and %s", cfg.Duration, cfg.Thresholds.P99)
+	}
+	if q := cfg.Queries[0]; q.Name != "a.example.org." || q.Type != "A" || q.Rcode != "NOERROR" || q.Weight != 3 {
+		t.Errorf("unexpected defaults of query %+v", q)
+	}
+	if cfg.Concurrency != 100 || cfg.Net != "udp" || cfg.Timeout.Duration != 2*time.Second {
+		t.Errorf("unexpected defaults %d, %s and %s", cfg.Concurrency, cfg.Net, cfg.Timeout)
+	}
+}
@@ -0,0 +1,92 @@
+package kubernetes
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// Metrics are the metric families of a scrape of CoreDNS, by name.
+type Metrics map[string]*dto.MetricFamily
+
+// ScrapeMetricFamilies scrapes the metrics of the coredns pod and parses them.
+func ScrapeMetricFamilies(t *testing.T) Metrics {
+	return parseMetrics(t, ScrapeMetrics(t))
+}
+
+// scrapeMetricFamilies is ScrapeMetricFamilies for a retried assertion, it returns the error of a scrape or parse
+// that failed instead of failing the test.
+func scrapeMetricFamilies() (Metrics, error) {
+	ips, err := CoreDNSPodIPs()
+	if err != nil {
+		return nil, fmt.Errorf("could not get coredns pod ip: %v", err)
+	}
+	if len(ips) != 1 {
+		return nil, fmt.Errorf("expected 1 pod ip, found: %v", len(ips))
+	}
+	scraped, err := scrapePodMetrics(ips[0])
+	if err != nil {
+		return nil, err
+	}
+	if len(scraped) == 0 {
+		return nil, fmt.Errorf("unable to scrape metrics from %v", ips[0])
+	}
+	families, err := parseMetricFamilies(scraped)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse scraped metrics: %v", err)
+	}
+	return families, nil
+}
+
+func parseMetrics(t *testing.T, scraped []byte) Metrics {
+	families, err := parseMetricFamilies(scraped)
+	if err != nil {
+		t.Fatalf("Could not parse scraped metrics: %v", err)
+	}
+	return families
+}
+
+func parseMetricFamilies(scraped []byte) (Metrics, error) {
+	var tp expfmt.TextParser
+	return tp.TextToMetricFamilies(strings.NewReader(string(scraped)))
+}
+
+// Value returns the sum of the counters and gauges of the family name that have labels, and any others.
+// For a histogram, it is the number of observations. It is 0 if there are none, as for a counter that has
+// not been incremented yet.
+func (m Metrics) Value(name string, labels map[string]string) float64 {
+	mf, ok := m[name]
+	if !ok {
+		return 0
+	}
+	var sum float64
+	for _, metric := range mf.Metric {
+		if !hasLabels(metric, labels) {
+			continue
+		}
+		sum += metric.GetCounter().GetValue() + metric.GetGauge().GetValue() + metric.GetUntyped().GetValue()
+		sum += float64(metric.GetHistogram().GetSampleCount())
+	}
+	return sum
+}
+
+// Delta returns how much the value of name with labels changed since before.
+func (m Metrics) Delta(before Metrics, name string, labels map[string]string) float64 {
+	return m.Value(name, labels) - before.Value(name, labels)
+}
+
+func hasLabels(metric *dto.Metric, labels map[string]string) bool {
+	found := 0
+	for _, l := range metric.Label {
+		if v, ok := labels[l.GetName()]; ok {
+			if v != l.GetValue() {
+				return false
+			}
+			found++
+		}
+	}
+	return found == len(labels)
+}
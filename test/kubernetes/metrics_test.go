@@ -2,23 +2,19 @@ package kubernetes
 
 import (
 	"context"
-	"os"
+	"errors"
+	"fmt"
 	"strconv"
 	"strings"
 	"testing"
 	"time"
 
-	"github.com/prometheus/common/expfmt"
-	"github.com/prometheus/common/model"
 	api "k8s.io/api/core/v1"
 	discovery "k8s.io/api/discovery/v1"
 	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/tools/clientcmd"
 )
 
-const namespace = "testns"
-
 func TestDNSProgrammingLatencyEndpoints(t *testing.T) {
 	corefile := `    .:53 {
         health
@@ -32,37 +28,28 @@ func TestDNSProgrammingLatencyEndpoints(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Could not load corefile: %s", err)
 	}
+	namespace := NewTestNamespace(t)
+	CollectArtifacts(t, namespace)
 
-	config, err := clientcmd.BuildConfigFromFlags("", os.Getenv("KUBECONFIG"))
-	if err != nil {
-		panic(err)
-	}
-	client, err := kubernetes.NewForConfig(config)
+	client, err := NewClient()
 	if err != nil {
-		panic(err)
-	}
-
-	defer client.CoreV1().Namespaces().Delete(context.TODO(), namespace, meta.DeleteOptions{})
-	if _, err := client.CoreV1().Namespaces().Create(context.TODO(), &api.Namespace{
-		ObjectMeta: meta.ObjectMeta{Name: namespace},
-	}, meta.CreateOptions{}); err != nil {
-		t.Fatal(err)
+		t.Fatalf("could not create kubernetes client: %s", err)
 	}
 
 	// Create Services
-	createService(t, client, "my-service", api.ClusterIPNone)
-	createService(t, client, "clusterip-service", "10.96.99.12")
-	createService(t, client, "headless-no-annotation", api.ClusterIPNone)
-	createService(t, client, "headless-wrong-annotation", api.ClusterIPNone)
+	createService(t, client, namespace, "my-service", api.ClusterIPNone)
+	createService(t, client, namespace, "clusterip-service", "10.96.99.12")
+	createService(t, client, namespace, "headless-no-annotation", api.ClusterIPNone)
+	createService(t, client, namespace, "headless-wrong-annotation", api.ClusterIPNone)
 
 	// test endpoints and endpointslice
-	t.Run("EndpointSlice", func(t *testing.T) { testEndpoints(t, client, true) })
-	t.Run("Endpoint", func(t *testing.T) { testEndpoints(t, client, false) })
+	t.Run("EndpointSlice", func(t *testing.T) { testEndpoints(t, client, namespace, true) })
+	t.Run("Endpoint", func(t *testing.T) { testEndpoints(t, client, namespace, false) })
 }
 
-func testEndpoints(t *testing.T, client *kubernetes.Clientset, slices bool) {
+func testEndpoints(t *testing.T, client kubernetes.Interface, namespace string, slices bool) {
 
-	sv, _ := client.ServerVersion()
+	sv, _ := client.Discovery().ServerVersion()
 	major, _ := strconv.Atoi(sv.Major)
 	minor, _ := strconv.Atoi(sv.Minor)
 
@@ -83,22 +70,14 @@ func testEndpoints(t *testing.T, client *kubernetes.Clientset, slices bool) {
 	}
 
 	// scrape and parse metrics to get base state
-	m := ScrapeMetrics(t)
-	tp := expfmt.NewTextParser(model.LegacyValidation)
-	base, err := tp.TextToMetricFamilies(strings.NewReader(string(m)))
-	if err != nil {
-		t.Fatalf("Could not parse scraped metrics: %v", err)
-	}
+	base := parseMetrics(t, ScrapeMetrics(t))
 
 	if slices {
-		addUpdateEndpointSlice(t, client)
+		addUpdateEndpointSlice(t, client, namespace)
 	} else {
-		addUpdateEndpoints(t, client)
+		addUpdateEndpoints(t, client, namespace)
 	}
 
-	// give time for coredns to receive and process the events
-	time.Sleep(time.Second)
-
 	// prepare expected values
 	metricName := "coredns_kubernetes_dns_programming_duration_seconds"
 	type expectBucket struct {
@@ -128,25 +107,38 @@ func testEndpoints(t *testing.T, client *kubernetes.Clientset, slices bool) {
 		}
 	}
 
-	// scrape metrics and validate results
-	m = ScrapeMetrics(t)
-	got, err := tp.TextToMetricFamilies(strings.NewReader(string(m)))
-	if err != nil {
-		t.Fatalf("Could not parse scraped metrics: %v", err)
-	}
+	// scrape metrics and validate results, giving coredns time to receive and process the events
+	retry := RetryPolicy{Assertion: 10, Interval: time.Second}
+	failed, err := retry.Do(func() error {
+		got, err := scrapeMetricFamilies()
+		if err != nil {
+			return err
+		}
 
-	if _, ok := got[metricName]; !ok {
-		t.Fatalf("Did not find '%v' in scraped metrics.", metricName)
-	}
-	for _, eb := range expectBuckets {
-		count := *got[metricName].Metric[0].Histogram.Bucket[eb.n].CumulativeCount
-		if count != eb.count {
-			t.Errorf("In bucket %v, expected %v, got %v", eb.n, eb.count, count)
+		if _, ok := got[metricName]; !ok {
+			return fmt.Errorf("did not find '%v' in scraped metrics", metricName)
+		}
+		var errs []string
+		for _, eb := range expectBuckets {
+			count := *got[metricName].Metric[0].Histogram.Bucket[eb.n].CumulativeCount
+			if count != eb.count {
+				errs = append(errs, fmt.Sprintf("in bucket %v, expected %v, got %v", eb.n, eb.count, count))
+			}
 		}
+		if len(errs) > 0 {
+			return errors.New(strings.Join(errs, "; "))
+		}
+		return nil
+	})
+	switch {
+	case err != nil:
+		t.Error(err)
+	case len(failed) > 0:
+		t.Logf("flaky: passed on attempt %d, earlier failures: %v", len(failed)+1, failed)
 	}
 }
 
-func addUpdateEndpoints(t *testing.T, client *kubernetes.Clientset) {
+func addUpdateEndpoints(t *testing.T, client kubernetes.Interface, namespace string) {
 	subset1 := []api.EndpointSubset{{
 		Addresses: []api.EndpointAddress{{IP: "1.2.3.6", Hostname: "foo"}},
 		Ports:     []api.EndpointPort{{Port: 80, Name: "http"}},
@@ -154,30 +146,30 @@ func addUpdateEndpoints(t *testing.T, client *kubernetes.Clientset) {
 	subset2 := []api.EndpointSubset{{
 		Addresses: []api.EndpointAddress{{IP: "1.2.3.7", Hostname: "foo"}},
 	}}
-	createEndpoints(t, client, "my-service", time.Now().Add(-132*time.Second), subset1)
-	updateEndpoints(t, client, "my-service", time.Now().Add(-66*time.Second), subset2)
-	createEndpoints(t, client, "endpoints-no-service", time.Now().Add(-4*time.Second), nil)
-	createEndpoints(t, client, "clusterip-service", time.Now().Add(-8*time.Second), nil)
-	createEndpoints(t, client, "headless-no-annotation", nil, nil)
-	createEndpoints(t, client, "headless-wrong-annotation", "wrong-value", nil)
+	createEndpoints(t, client, namespace, "my-service", time.Now().Add(-132*time.Second), subset1)
+	updateEndpoints(t, client, namespace, "my-service", time.Now().Add(-66*time.Second), subset2)
+	createEndpoints(t, client, namespace, "endpoints-no-service", time.Now().Add(-4*time.Second), nil)
+	createEndpoints(t, client, namespace, "clusterip-service", time.Now().Add(-8*time.Second), nil)
+	createEndpoints(t, client, namespace, "headless-no-annotation", nil, nil)
+	createEndpoints(t, client, namespace, "headless-wrong-annotation", "wrong-value", nil)
 }
 
-func addUpdateEndpointSlice(t *testing.T, client *kubernetes.Clientset) {
+func addUpdateEndpointSlice(t *testing.T, client kubernetes.Interface, namespace string) {
 	endpoints1 := []discovery.Endpoint{{
 		Addresses: []string{"1.2.3.4"},
 	}}
 	endpoints2 := []discovery.Endpoint{{
 		Addresses: []string{"1.2.3.5"},
 	}}
-	createEndpointSlice(t, client, "my-service", time.Now().Add(-132*time.Second), endpoints1)
-	updateEndpointSlice(t, client, "my-service", time.Now().Add(-66*time.Second), endpoints2)
-	createEndpointSlice(t, client, "endpoints-no-service", time.Now().Add(-4*time.Second), nil)
-	createEndpointSlice(t, client, "clusterip-service", time.Now().Add(-8*time.Second), nil)
-	createEndpointSlice(t, client, "headless-no-annotation", nil, nil)
-	createEndpointSlice(t, client, "headless-wrong-annotation", "wrong-value", nil)
+	createEndpointSlice(t, client, namespace, "my-service", time.Now().Add(-132*time.Second), endpoints1)
+	updateEndpointSlice(t, client, namespace, "my-service", time.Now().Add(-66*time.Second), endpoints2)
+	createEndpointSlice(t, client, namespace, "endpoints-no-service", time.Now().Add(-4*time.Second), nil)
+	createEndpointSlice(t, client, namespace, "clusterip-service", time.Now().Add(-8*time.Second), nil)
+	createEndpointSlice(t, client, namespace, "headless-no-annotation", nil, nil)
+	createEndpointSlice(t, client, namespace, "headless-wrong-annotation", "wrong-value", nil)
 }
 
-func buildEndpoints(name string, lastChangeTriggerTime interface{}, subsets []api.EndpointSubset) *api.Endpoints {
+func buildEndpoints(namespace, name string, lastChangeTriggerTime interface{}, subsets []api.EndpointSubset) *api.Endpoints {
 	annotations := make(map[string]string)
 	switch v := lastChangeTriggerTime.(type) {
 	case string:
@@ -191,7 +183,7 @@ func buildEndpoints(name string, lastChangeTriggerTime interface{}, subsets []ap
 	}
 }
 
-func buildEndpointSlice(name string, lastChangeTriggerTime interface{}, endpoints []discovery.Endpoint) *discovery.EndpointSlice {
+func buildEndpointSlice(namespace, name string, lastChangeTriggerTime interface{}, endpoints []discovery.Endpoint) *discovery.EndpointSlice {
 	annotations := make(map[string]string)
 	switch v := lastChangeTriggerTime.(type) {
 	case string:
@@ -210,39 +202,39 @@ func buildEndpointSlice(name string, lastChangeTriggerTime interface{}, endpoint
 	}
 }
 
-func createEndpoints(t *testing.T, client kubernetes.Interface, name string, triggerTime interface{}, subsets []api.EndpointSubset) {
+func createEndpoints(t *testing.T, client kubernetes.Interface, namespace, name string, triggerTime interface{}, subsets []api.EndpointSubset) {
 	ctx := context.TODO()
-	_, err := client.CoreV1().Endpoints(namespace).Create(ctx, buildEndpoints(name, triggerTime, subsets), meta.CreateOptions{})
+	_, err := client.CoreV1().Endpoints(namespace).Create(ctx, buildEndpoints(namespace, name, triggerTime, subsets), meta.CreateOptions{})
 	if err != nil {
 		t.Fatal(err)
 	}
 }
 
-func updateEndpoints(t *testing.T, client kubernetes.Interface, name string, triggerTime interface{}, subsets []api.EndpointSubset) {
+func updateEndpoints(t *testing.T, client kubernetes.Interface, namespace, name string, triggerTime interface{}, subsets []api.EndpointSubset) {
 	ctx := context.TODO()
-	_, err := client.CoreV1().Endpoints(namespace).Update(ctx, buildEndpoints(name, triggerTime, subsets), meta.UpdateOptions{})
+	_, err := client.CoreV1().Endpoints(namespace).Update(ctx, buildEndpoints(namespace, name, triggerTime, subsets), meta.UpdateOptions{})
 	if err != nil {
 		t.Fatal(err)
 	}
 }
 
-func createEndpointSlice(t *testing.T, client kubernetes.Interface, name string, triggerTime interface{}, endpoints []discovery.Endpoint) {
+func createEndpointSlice(t *testing.T, client kubernetes.Interface, namespace, name string, triggerTime interface{}, endpoints []discovery.Endpoint) {
 	ctx := context.TODO()
-	_, err := client.DiscoveryV1().EndpointSlices(namespace).Create(ctx, buildEndpointSlice(name, triggerTime, endpoints), meta.CreateOptions{})
+	_, err := client.DiscoveryV1().EndpointSlices(namespace).Create(ctx, buildEndpointSlice(namespace, name, triggerTime, endpoints), meta.CreateOptions{})
 	if err != nil {
 		t.Fatal(err)
 	}
 }
 
-func updateEndpointSlice(t *testing.T, client kubernetes.Interface, name string, triggerTime interface{}, endpoints []discovery.Endpoint) {
+func updateEndpointSlice(t *testing.T, client kubernetes.Interface, namespace, name string, triggerTime interface{}, endpoints []discovery.Endpoint) {
 	ctx := context.TODO()
-	_, err := client.DiscoveryV1().EndpointSlices(namespace).Update(ctx, buildEndpointSlice(name, triggerTime, endpoints), meta.UpdateOptions{})
+	_, err := client.DiscoveryV1().EndpointSlices(namespace).Update(ctx, buildEndpointSlice(namespace, name, triggerTime, endpoints), meta.UpdateOptions{})
 	if err != nil {
 		t.Fatal(err)
 	}
 }
 
-func createService(t *testing.T, client kubernetes.Interface, name string, clusterIp string) {
+func createService(t *testing.T, client kubernetes.Interface, namespace, name string, clusterIp string) {
 	ctx := context.TODO()
 	if _, err := client.CoreV1().Services(namespace).Create(ctx, &api.Service{
 		ObjectMeta: meta.ObjectMeta{Namespace: namespace, Name: name},
@@ -251,3 +243,60 @@ func createService(t *testing.T, client kubernetes.Interface, name string, clust
 		t.Fatal(err)
 	}
 }
+
+func TestMetricsValue(t *testing.T) {
+	before := parseMetrics(t, []byte(`# TYPE coredns_cache_hits_total counter
+coredns_cache_hits_total{server="dns://:53",type="success",view="",zones="."} 3
+coredns_cache_hits_total{server="dns://:53",type="denial",view="",zones="."} 1
+coredns_cache_hits_total{server="dns://:5300",type="success",view="",zones="."} 7
+# TYPE coredns_cache_entries gauge
+coredns_cache_entries{server="dns://:53",type="success",view="",zones="."} 2
+# TYPE coredns_proxy_request_duration_seconds histogram
+coredns_proxy_request_duration_seconds_bucket{proxy_name="forward",rcode="NOERROR",to="192.0.2.53:53",le="0.25"} 1
+coredns_proxy_request_duration_seconds_bucket{proxy_name="forward",rcode="NOERROR",to="192.0.2.53:53",le="+Inf"} 1
+coredns_proxy_request_duration_seconds_sum{proxy_name="forward",rcode="NOERROR",to="192.0.2.53:53"} 0.01
+coredns_proxy_request_duration_seconds_count{proxy_name="forward",rcode="NOERROR",to="192.0.2.53:53"} 1
+`))
+	after := parseMetrics(t, []byte(`# TYPE coredns_cache_hits_total counter
+coredns_cache_hits_total{server="dns://:53",type="success",view="",zones="."} 5
+coredns_cache_hits_total{server="dns://:53",type="denial",view="",zones="."} 1
+coredns_cache_hits_total{server="dns://:5300",type="success",view="",zones="."} 7
+# TYPE coredns_cache_misses_total counter
+coredns_cache_misses_total{server="dns://:53",view="",zones="."} 2
+# TYPE coredns_cache_entries gauge
+coredns_cache_entries{server="dns://:53",type="success",view="",zones="."} 4
+# TYPE coredns_proxy_request_duration_seconds histogram
+coredns_proxy_request_duration_seconds_bucket{proxy_name="forward",rcode="NOERROR",to="192.0.2.53:53",le="0.25"} 2
+coredns_proxy_request_duration_seconds_bucket{proxy_name="forward",rcode="NOERROR",to="192.0.2.53:53",le="+Inf"} 3
+coredns_proxy_request_duration_seconds_sum{proxy_name="forward",rcode="NOERROR",to="192.0.2.53:53"} 1.02
+coredns_proxy_request_duration_seconds_count{proxy_name="forward",rcode="NOERROR",to="192.0.2.53:53"} 3
+coredns_proxy_request_duration_seconds_bucket{proxy_name="forward",rcode="SERVFAIL",to="192.0.2.53:53",le="0.25"} 1
+coredns_proxy_request_duration_seconds_bucket{proxy_name="forward",rcode="SERVFAIL",to="192.0.2.53:53",le="+Inf"} 1
+coredns_proxy_request_duration_seconds_sum{proxy_name="forward",rcode="SERVFAIL",to="192.0.2.53:53"} 0.01
+coredns_proxy_request_duration_seconds_count{proxy_name="forward",rcode="SERVFAIL",to="192.0.2.53:53"} 1
+`))
+
+	tests := []struct {
+		name   string
+		labels map[string]string
+		value  float64
+		delta  float64
+	}{
+		{"coredns_cache_hits_total", nil, 13, 2},
+		{"coredns_cache_hits_total", map[string]string{"server": "dns://:53"}, 6, 2},
+		{"coredns_cache_hits_total", map[string]string{"server": "dns://:53", "type": "denial"}, 1, 0},
+		{"coredns_cache_hits_total", map[string]string{"rcode": "NOERROR"}, 0, 0},
+		{"coredns_cache_misses_total", map[string]string{"server": "dns://:53"}, 2, 2},
+		{"coredns_cache_entries", map[string]string{"type": "success"}, 4, 2},
+		{"coredns_proxy_request_duration_seconds", map[string]string{"to": "192.0.2.53:53"}, 4, 3},
+		{"coredns_proxy_request_duration_seconds", map[string]string{"rcode": "SERVFAIL"}, 1, 1},
+	}
+	for _, tc := range tests {
+		if v := after.Value(tc.name, tc.labels); v != tc.value {
+			t.Errorf("%s %v: expected value %v, got %v", tc.name, tc.labels, tc.value, v)
+		}
+		if d := after.Delta(before, tc.name, tc.labels); d != tc.delta {
+			t.Errorf("%s %v: expected delta %v, got %v", tc.name, tc.labels, tc.delta, d)
+		}
+	}
+}
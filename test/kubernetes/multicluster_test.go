@@ -0,0 +1,236 @@
+package kubernetes
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+// serviceImportCRD is the CustomResourceDefinition of the ServiceImport of the Multi-Cluster Services API.
+const serviceImportCRD = "testdata/serviceimport-crd.yaml"
+
+// multiclusterObjects are ServiceImports and the EndpointSlices of their endpoints in two clusters, as an
+// implementation of the Multi-Cluster Services API would create them. The namespace is replaced by a namespace
+// created for the test.
+const multiclusterObjects = `apiVersion: multicluster.x-k8s.io/v1alpha1
+kind: ServiceImport
+metadata:
+  name: mc-svc
+  namespace: test-mc
+spec:
+  type: ClusterSetIP
+  ips:
+  - 10.110.0.100
+  ports:
+  - name: http
+    protocol: TCP
+    port: 80
+---
+apiVersion: multicluster.x-k8s.io/v1alpha1
+kind: ServiceImport
+metadata:
+  name: mc-dual
+  namespace: test-mc
+spec:
+  type: ClusterSetIP
+  ips:
+  - 10.110.0.110
+  - fd00:110::110
+  ports:
+  - name: http
+    protocol: TCP
+    port: 80
+---
+apiVersion: multicluster.x-k8s.io/v1alpha1
+kind: ServiceImport
+metadata:
+  name: mc-headless
+  namespace: test-mc
+spec:
+  type: Headless
+  ports:
+  - name: http
+    protocol: TCP
+    port: 80
+---
+apiVersion: discovery.k8s.io/v1
+kind: EndpointSlice
+metadata:
+  name: mc-headless-cluster-a
+  namespace: test-mc
+  labels:
+    multicluster.kubernetes.io/service-name: mc-headless
+    multicluster.kubernetes.io/source-cluster: cluster-a
+addressType: IPv4
+ports:
+- name: http
+  protocol: TCP
+  port: 8080
+endpoints:
+- addresses:
+  - 172.17.5.10
+  hostname: pod-a
+  conditions:
+    ready: true
+---
+apiVersion: discovery.k8s.io/v1
+kind: EndpointSlice
+metadata:
+  name: mc-headless-cluster-b
+  namespace: test-mc
+  labels:
+    multicluster.kubernetes.io/service-name: mc-headless
+    multicluster.kubernetes.io/source-cluster: cluster-b
+addressType: IPv4
+ports:
+- name: http
+  protocol: TCP
+  port: 8080
+endpoints:
+- addresses:
+  - 172.17.5.20
+  conditions:
+    ready: true
+- addresses:
+  - 172.17.5.21
+  conditions:
+    ready: false
+`
+
+var dnsTestCasesMulticluster = []test.Case{
+	{ // A ClusterSetIP ServiceImport has an A record for its IP
+		Qname: "mc-svc.test-mc.svc.clusterset.local.", Qtype: dns.TypeA,
+		Rcode: dns.RcodeSuccess,
+		Answer: []dns.RR{
+			test.A("mc-svc.test-mc.svc.clusterset.local. 303 IN A 10.110.0.100"),
+		},
+	},
+	{ // and an SRV record for each named port, to itself
+		Qname: "_http._tcp.mc-svc.test-mc.svc.clusterset.local.", Qtype: dns.TypeSRV,
+		Rcode: dns.RcodeSuccess,
+		Answer: []dns.RR{
+			test.SRV("_http._tcp.mc-svc.test-mc.svc.clusterset.local. 303 IN SRV 0 100 80 mc-svc.test-mc.svc.clusterset.local."),
+		},
+		Extra: []dns.RR{
+			test.A("mc-svc.test-mc.svc.clusterset.local. 303 IN A 10.110.0.100"),
+		},
+	},
+	{ // A dual-stack ServiceImport has an A and an AAAA record
+		Qname: "mc-dual.test-mc.svc.clusterset.local.", Qtype: dns.TypeA,
+		Rcode: dns.RcodeSuccess,
+		Answer: []dns.RR{
+			test.A("mc-dual.test-mc.svc.clusterset.local. 303 IN A 10.110.0.110"),
+		},
+	},
+	{
+		Qname: "mc-dual.test-mc.svc.clusterset.local.", Qtype: dns.TypeAAAA,
+		Rcode: dns.RcodeSuccess,
+		Answer: []dns.RR{
+			test.AAAA("mc-dual.test-mc.svc.clusterset.local. 303 IN AAAA fd00:110::110"),
+		},
+	},
+	{ // A headless ServiceImport has the ready endpoints of all clusters
+		Qname: "mc-headless.test-mc.svc.clusterset.local.", Qtype: dns.TypeA,
+		Rcode: dns.RcodeSuccess,
+		Answer: []dns.RR{
+			test.A("mc-headless.test-mc.svc.clusterset.local. 303 IN A 172.17.5.10"),
+			test.A("mc-headless.test-mc.svc.clusterset.local. 303 IN A 172.17.5.20"),
+		},
+	},
+	{ // An endpoint is named by its hostname, prefixed to the cluster id of its EndpointSlice
+		Qname: "pod-a.cluster-a.mc-headless.test-mc.svc.clusterset.local.", Qtype: dns.TypeA,
+		Rcode: dns.RcodeSuccess,
+		Answer: []dns.RR{
+			test.A("pod-a.cluster-a.mc-headless.test-mc.svc.clusterset.local. 303 IN A 172.17.5.10"),
+		},
+	},
+	{ // or by its dashed IP, if it has no hostname
+		Qname: "172-17-5-20.cluster-b.mc-headless.test-mc.svc.clusterset.local.", Qtype: dns.TypeA,
+		Rcode: dns.RcodeSuccess,
+		Answer: []dns.RR{
+			test.A("172-17-5-20.cluster-b.mc-headless.test-mc.svc.clusterset.local. 303 IN A 172.17.5.20"),
+		},
+	},
+	{ // An endpoint is not known in another cluster
+		Qname: "pod-a.cluster-b.mc-headless.test-mc.svc.clusterset.local.", Qtype: dns.TypeA,
+		Rcode: dns.RcodeNameError,
+		Ns: []dns.RR{
+			test.SOA("clusterset.local.	303	IN	SOA	ns.dns.clusterset.local. hostmaster.clusterset.local. 1502313310 7200 1800 86400 30"),
+		},
+	},
+	{ // The SRV records of a headless ServiceImport point to the endpoints, with the ports of their EndpointSlices
+		Qname: "_http._tcp.mc-headless.test-mc.svc.clusterset.local.", Qtype: dns.TypeSRV,
+		Rcode: dns.RcodeSuccess,
+		Answer: []dns.RR{
+			test.SRV("_http._tcp.mc-headless.test-mc.svc.clusterset.local. 303 IN SRV 0 50 8080 pod-a.cluster-a.mc-headless.test-mc.svc.clusterset.local."),
+			test.SRV("_http._tcp.mc-headless.test-mc.svc.clusterset.local. 303 IN SRV 0 50 8080 172-17-5-20.cluster-b.mc-headless.test-mc.svc.clusterset.local."),
+		},
+		Extra: []dns.RR{
+			test.A("pod-a.cluster-a.mc-headless.test-mc.svc.clusterset.local. 303 IN A 172.17.5.10"),
+			test.A("172-17-5-20.cluster-b.mc-headless.test-mc.svc.clusterset.local. 303 IN A 172.17.5.20"),
+		},
+	},
+	{ // ServiceImports are only served in the multicluster zone
+		Qname: "mc-svc.test-mc.svc.cluster.local.", Qtype: dns.TypeA,
+		Rcode: dns.RcodeNameError,
+		Ns: []dns.RR{
+			test.SOA("cluster.local.	303	IN	SOA	ns.dns.cluster.local. hostmaster.cluster.local. 1502313310 7200 1800 86400 30"),
+		},
+	},
+}
+
+// TestKubernetesMulticluster installs the ServiceImport CRD, creates ServiceImports with EndpointSlices of two
+// clusters, and checks the records CoreDNS serves for them in the clusterset.local zone. The CRD is removed again
+// when the test completes.
+func TestKubernetesMulticluster(t *testing.T) {
+	if _, err := Kubectl("apply -f " + serviceImportCRD); err != nil {
+		t.Fatalf("could not install the ServiceImport CRD: %s", err)
+	}
+	// the CRD is cluster-scoped, so it is removed for the tests that follow, after the test namespace
+	t.Cleanup(func() {
+		if _, err := Kubectl("delete -f " + serviceImportCRD + " --ignore-not-found"); err != nil {
+			t.Errorf("could not remove the ServiceImport CRD: %s", err)
+		}
+	})
+	if _, err := Kubectl("wait --for=condition=Established crd/serviceimports.multicluster.x-k8s.io --timeout=60s"); err != nil {
+		t.Fatalf("ServiceImport CRD not established: %s", err)
+	}
+
+	namespace := NewTestNamespace(t)
+	corefile := `    .:53 {
+        health
+        ready
+        errors
+        log
+        kubernetes cluster.local clusterset.local {
+            namespaces ` + namespace + `
+            multicluster clusterset.local
+        }
+    }
+`
+	// CoreDNS is restarted with the CRD installed, so it can watch the ServiceImports
+	err := LoadCorefile(corefile)
+	if err != nil {
+		t.Fatalf("Could not load corefile: %s", err)
+	}
+
+	objects, rmFunc, err := test.TempFile(os.TempDir(), Namespaced(multiclusterObjects, "test-mc", namespace))
+	if err != nil {
+		t.Fatalf("could not create file for multicluster objects: %s", err)
+	}
+	defer rmFunc()
+	if _, err := Kubectl("apply -f " + objects); err != nil {
+		t.Fatalf("could not create multicluster objects: %s", err)
+	}
+
+	// the objects exist, but CoreDNS may not have seen them yet
+	var cases []Case
+	for _, tc := range NamespacedCases(dnsTestCasesMulticluster, "test-mc", namespace) {
+		cases = append(cases, Case{Case: tc, Retry: RetryPolicy{Transport: 2, Assertion: 5, Interval: time.Second}})
+	}
+	DoIntegrationCases(t, cases, namespace)
+}
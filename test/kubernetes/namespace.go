@@ -0,0 +1,102 @@
+package kubernetes
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+	api "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// namespaceDeleteTimeout is how long cleanup waits for a test namespace to be deleted.
+const namespaceDeleteTimeout = 2 * time.Minute
+
+var unsafeNamespaceChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// NewTestNamespace creates a namespace with a unique name derived from the name of t, and returns its name.
+// The namespace is deleted when t completes, and cleanup waits until it is gone, so its objects are not
+// seen by the tests that follow. A namespace isolates the objects of a test, not the Corefile of CoreDNS,
+// which every test loads into the same ConfigMap.
+func NewTestNamespace(t *testing.T) string {
+	client, err := NewClient()
+	if err != nil {
+		t.Fatalf("could not create kubernetes client: %s", err)
+	}
+
+	prefix := strings.Trim(unsafeNamespaceChars.ReplaceAllString(strings.ToLower(t.Name()), "-"), "-")
+	if len(prefix) > 40 {
+		prefix = strings.TrimRight(prefix[:40], "-")
+	}
+	ns, err := client.CoreV1().Namespaces().Create(context.TODO(), &api.Namespace{
+		ObjectMeta: meta.ObjectMeta{GenerateName: prefix + "-"},
+	}, meta.CreateOptions{})
+	if err != nil {
+		t.Fatalf("could not create namespace: %s", err)
+	}
+
+	t.Cleanup(func() {
+		ctx := context.TODO()
+		err := client.CoreV1().Namespaces().Delete(ctx, ns.Name, meta.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			t.Errorf("could not delete namespace %s: %s", ns.Name, err)
+			return
+		}
+		deadline := time.Now().Add(namespaceDeleteTimeout)
+		for {
+			_, err := client.CoreV1().Namespaces().Get(ctx, ns.Name, meta.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Errorf("timeout waiting for namespace %s to be deleted", ns.Name)
+				return
+			}
+			time.Sleep(time.Second)
+		}
+	})
+	return ns.Name
+}
+
+// Namespaced returns s with the namespace label from replaced by to, in a Corefile, manifest or domain name.
+func Namespaced(s, from, to string) string {
+	re := regexp.MustCompile(`(^|[\s.:])` + regexp.QuoteMeta(from) + `([\s.]|$)`)
+	// matches can't overlap in the separators, so replace until nothing changes
+	for {
+		r := re.ReplaceAllString(s, "${1}"+to+"${2}")
+		if r == s {
+			return r
+		}
+		s = r
+	}
+}
+
+// NamespacedCases returns copies of the test cases with the namespace from in the query and record names
+// replaced by to, for running cases written for a fixed namespace in a namespace created with NewTestNamespace.
+func NamespacedCases(cases []test.Case, from, to string) []test.Case {
+	rrs := func(rrs []dns.RR) []dns.RR {
+		var out []dns.RR
+		for _, rr := range rrs {
+			r, err := dns.NewRR(Namespaced(rr.String(), from, to))
+			if err != nil {
+				// leave records that can't be renamed as is
+				r = dns.Copy(rr)
+			}
+			out = append(out, r)
+		}
+		return out
+	}
+	out := make([]test.Case, len(cases))
+	for i, tc := range cases {
+		tc.Qname = Namespaced(tc.Qname, from, to)
+		tc.Answer, tc.Ns, tc.Extra = rrs(tc.Answer), rrs(tc.Ns), rrs(tc.Extra)
+		out[i] = tc
+	}
+	return out
+}
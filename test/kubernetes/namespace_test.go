@@ -0,0 +1,46 @@
+package kubernetes
+
+import (
+	"testing"
+
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+func TestNamespaced(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"svc-1-a.test-1.svc.cluster.local.", "svc-1-a.ns-x.svc.cluster.local."},
+		{"svc-1-a.test-1", "svc-1-a.ns-x"},
+		{"namespaces test-1 test-2", "namespaces ns-x test-2"},
+		{"  namespace: test-1\n", "  namespace: ns-x\n"},
+		{"svc.test-10.svc.cluster.local.", "svc.test-10.svc.cluster.local."},
+		{"a.test-1.test-1.svc.cluster.local.", "a.ns-x.ns-x.svc.cluster.local."},
+	}
+	for _, tc := range tests {
+		if got := Namespaced(tc.in, "test-1", "ns-x"); got != tc.want {
+			t.Errorf("expected %q, got %q", tc.want, got)
+		}
+	}
+}
+
+func TestNamespacedCases(t *testing.T) {
+	cases := NamespacedCases([]test.Case{{
+		Qname: "svc-1-a.test-1.svc.cluster.local.", Qtype: dns.TypeSRV,
+		Answer: []dns.RR{test.SRV("svc-1-a.test-1.svc.cluster.local. 303 IN SRV 0 100 80 svc-1-a.test-1.svc.cluster.local.")},
+		Extra:  []dns.RR{test.A("svc-1-a.test-1.svc.cluster.local. 303 IN A 10.96.0.100")},
+	}}, "test-1", "ns-x")
+
+	tc := cases[0]
+	if tc.Qname != "svc-1-a.ns-x.svc.cluster.local." {
+		t.Errorf("unexpected qname %q", tc.Qname)
+	}
+	if srv := tc.Answer[0].(*dns.SRV); srv.Hdr.Name != "svc-1-a.ns-x.svc.cluster.local." || srv.Target != "svc-1-a.ns-x.svc.cluster.local." {
+		t.Errorf("unexpected answer %s", srv)
+	}
+	if a := tc.Extra[0].(*dns.A); a.Hdr.Name != "svc-1-a.ns-x.svc.cluster.local." {
+		t.Errorf("unexpected extra %s", a)
+	}
+}
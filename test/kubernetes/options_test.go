@@ -0,0 +1,211 @@
+package kubernetes
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coredns/ci/test/kubernetes/fakeapi"
+	"github.com/coredns/ci/test/kubernetes/fixture"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// The namespaces the options cases are written for, they are replaced by namespaces created for the test.
+const (
+	optionsNamespace      = "test-opt"
+	optionsOtherNamespace = "test-opt-other"
+)
+
+// optionsLabel is the label selected by the labels and namespace_labels options. It is set on svc-a and on
+// the namespace test-opt.
+const optionsLabel = "ci.coredns.io/options=selected"
+
+// optionsFixtures returns the services in the cluster the options cases query: svc-a is labelled, svc-b is
+// not, empty has no endpoints, and headless only has its endpoints.
+func optionsFixtures(namespace, other string) *fixture.Set {
+	http := []fixture.Port{{Name: "http", Port: 80}}
+	return &fixture.Set{Namespaces: []fixture.Namespace{
+		{Name: namespace, Services: []fixture.Service{
+			{Name: "svc-a", ClusterIPs: []string{"10.96.0.28"}, Ports: http, Endpoints: []fixture.Endpoint{{IP: "172.17.6.10"}},
+				Labels: map[string]string{"ci.coredns.io/options": "selected"}},
+			{Name: "svc-b", ClusterIPs: []string{"10.96.0.29"}, Ports: http, Endpoints: []fixture.Endpoint{{IP: "172.17.6.11"}}},
+			{Name: "empty", ClusterIPs: []string{"10.96.0.30"}, Ports: http},
+			{Name: "headless", Ports: http, Endpoints: []fixture.Endpoint{{IP: "172.17.6.20", Hostname: "ep-1"}}},
+		}},
+		{Name: other, Services: []fixture.Service{
+			{Name: "other", ClusterIPs: []string{"10.96.0.31"}, Ports: http},
+		}},
+	}}
+}
+
+// optionsFakeFixtures returns the services only the fake API server serves.
+func optionsFakeFixtures(namespace string) *fixture.Set {
+	return &fixture.Set{Namespaces: []fixture.Namespace{{Name: namespace, Services: []fixture.Service{
+		{Name: "fake", ClusterIPs: []string{"10.96.0.32"}, Ports: []fixture.Port{{Name: "http", Port: 80}}},
+	}}}}
+}
+
+func optionsA(name string, ttl int, ip string) test.Case {
+	return test.Case{
+		Qname: name, Qtype: dns.TypeA,
+		Rcode:  dns.RcodeSuccess,
+		Answer: []dns.RR{test.A(name + " " + strconv.Itoa(ttl) + " IN A " + ip)},
+	}
+}
+
+func optionsNXDOMAIN(name string) test.Case {
+	return test.Case{
+		Qname: name, Qtype: dns.TypeA,
+		Rcode: dns.RcodeNameError,
+		Ns: []dns.RR{
+			test.SOA("cluster.local.	303	IN	SOA	ns.dns.cluster.local. hostmaster.cluster.local. 1502313310 7200 1800 86400 30"),
+		},
+	}
+}
+
+// optionsTests are the options of the kubernetes plugin, and the answers they change. The options are
+// written for the namespaces test-opt and test-opt-other; FAKEAPI is replaced by the URL of the fake API
+// server, and the zone file of CoreDNS is a kubeconfig with a context "fake" for it.
+var optionsTests = []struct {
+	name    string
+	options string
+	cases   []test.Case
+}{
+	{
+		name:    "defaults",
+		options: "namespaces test-opt test-opt-other",
+		cases: []test.Case{
+			optionsA("svc-a.test-opt.svc.cluster.local.", 5, "10.96.0.28"),
+			optionsA("svc-b.test-opt.svc.cluster.local.", 5, "10.96.0.29"),
+			optionsA("empty.test-opt.svc.cluster.local.", 5, "10.96.0.30"),
+			optionsA("headless.test-opt.svc.cluster.local.", 5, "172.17.6.20"),
+			optionsA("ep-1.headless.test-opt.svc.cluster.local.", 5, "172.17.6.20"),
+			optionsA("other.test-opt-other.svc.cluster.local.", 5, "10.96.0.31"),
+			optionsNXDOMAIN("fake.test-opt.svc.cluster.local."),
+		},
+	},
+	{ // the TTL of all records
+		name:    "ttl",
+		options: "namespaces test-opt\n            ttl 30",
+		cases: []test.Case{
+			optionsA("svc-a.test-opt.svc.cluster.local.", 30, "10.96.0.28"),
+			optionsA("headless.test-opt.svc.cluster.local.", 30, "172.17.6.20"),
+		},
+	},
+	{ // without endpoints, a headless service has no records, a service with a ClusterIP is not affected
+		name:    "noendpoints",
+		options: "namespaces test-opt\n            noendpoints",
+		cases: []test.Case{
+			optionsA("svc-a.test-opt.svc.cluster.local.", 5, "10.96.0.28"),
+			optionsNXDOMAIN("headless.test-opt.svc.cluster.local."),
+			optionsNXDOMAIN("ep-1.headless.test-opt.svc.cluster.local."),
+		},
+	},
+	{ // a service with a ClusterIP but without endpoints does not exist
+		name:    "ignore empty_service",
+		options: "namespaces test-opt\n            ignore empty_service",
+		cases: []test.Case{
+			optionsA("svc-a.test-opt.svc.cluster.local.", 5, "10.96.0.28"),
+			optionsNXDOMAIN("empty.test-opt.svc.cluster.local."),
+			optionsA("headless.test-opt.svc.cluster.local.", 5, "172.17.6.20"),
+		},
+	},
+	{ // only services with the label exist
+		name:    "labels",
+		options: "namespaces test-opt\n            labels " + optionsLabel,
+		cases: []test.Case{
+			optionsA("svc-a.test-opt.svc.cluster.local.", 5, "10.96.0.28"),
+			optionsNXDOMAIN("svc-b.test-opt.svc.cluster.local."),
+		},
+	},
+	{ // only namespaces with the label are exposed
+		name:    "namespace_labels",
+		options: "namespace_labels " + optionsLabel,
+		cases: []test.Case{
+			optionsA("svc-b.test-opt.svc.cluster.local.", 5, "10.96.0.29"),
+			optionsNXDOMAIN("other.test-opt-other.svc.cluster.local."),
+		},
+	},
+	{ // the services are those of another API server
+		name:    "endpoint",
+		options: "namespaces test-opt\n            endpoint FAKEAPI",
+		cases: []test.Case{
+			optionsA("fake.test-opt.svc.cluster.local.", 5, "10.96.0.32"),
+			optionsNXDOMAIN("svc-a.test-opt.svc.cluster.local."),
+		},
+	},
+	{ // the context selects the API server, the current context of the kubeconfig is unreachable
+		name:    "kubeconfig context",
+		options: "namespaces test-opt\n            kubeconfig /etc/coredns/Zonefile fake",
+		cases: []test.Case{
+			optionsA("fake.test-opt.svc.cluster.local.", 5, "10.96.0.32"),
+			optionsNXDOMAIN("svc-a.test-opt.svc.cluster.local."),
+		},
+	},
+}
+
+// TestKubernetesOptions loads CoreDNS with each option of the kubernetes plugin in turn, and checks the
+// answers the option changes. The endpoint and kubeconfig options point CoreDNS at an API server serving
+// objects that only exist in memory, which it reaches like an upstream server of the test.
+func TestKubernetesOptions(t *testing.T) {
+	namespace, other := NewTestNamespace(t), NewTestNamespace(t)
+	if _, err := Kubectl("label namespace " + namespace + " " + optionsLabel); err != nil {
+		t.Fatalf("could not label namespace: %s", err)
+	}
+	ApplyFixtures(t, optionsFixtures(namespace, other))
+
+	client := fake.NewSimpleClientset()
+	if err := optionsFakeFixtures(namespace).Apply(context.Background(), client); err != nil {
+		t.Fatalf("could not create fake fixtures: %s", err)
+	}
+	server, err := fakeapi.Listen(client, net.JoinHostPort(locaIP().String(), "0"))
+	if err != nil {
+		t.Fatalf("could not start fake API server: %s", err)
+	}
+	defer server.Close()
+
+	kubeconfig := server.Kubeconfig()
+	kubeconfig.Clusters["unreachable"] = &clientcmdapi.Cluster{Server: "http://127.0.0.1:1"}
+	kubeconfig.Contexts["unreachable"] = &clientcmdapi.Context{Cluster: "unreachable", AuthInfo: "fake"}
+	kubeconfig.CurrentContext = "unreachable"
+	zonefile, err := clientcmd.Write(*kubeconfig)
+	if err != nil {
+		t.Fatalf("could not write kubeconfig: %s", err)
+	}
+
+	for _, ot := range optionsTests {
+		t.Run(ot.name, func(t *testing.T) {
+			options := strings.ReplaceAll(ot.options, "FAKEAPI", server.URL())
+			corefile := `    .:53 {
+        health
+        ready
+        errors
+        log
+        kubernetes cluster.local {
+            ` + options + `
+        }
+    }
+`
+			corefile = Namespaced(Namespaced(corefile, optionsOtherNamespace, other), optionsNamespace, namespace)
+			err := LoadCorefileAndZonefile(corefile, string(zonefile), true)
+			if err != nil {
+				t.Fatalf("Could not load corefile/zonefile: %s", err)
+			}
+
+			tcs := NamespacedCases(NamespacedCases(ot.cases, optionsOtherNamespace, other), optionsNamespace, namespace)
+			var cases []Case
+			for _, tc := range tcs {
+				cases = append(cases, Case{Case: tc, Retry: RetryPolicy{Transport: 2, Assertion: 5, Interval: time.Second}})
+			}
+			DoIntegrationCases(t, cases, namespace)
+		})
+	}
+}
@@ -0,0 +1,223 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/coredns/ci/test/kubernetes/loadgen"
+
+	"github.com/miekg/dns"
+	dto "github.com/prometheus/client_model/go"
+	api "k8s.io/api/core/v1"
+	discovery "k8s.io/api/discovery/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// programmingIterations is the number of times the objects are created, updated and deleted.
+	programmingIterations = 10
+	// programmingPollInterval is the interval at which a change is polled for.
+	programmingPollInterval = 5 * time.Millisecond
+	// programmingTimeout is how long a change may take to become visible.
+	programmingTimeout = 30 * time.Second
+)
+
+// programmingResult compares the observed programming latency by operation, with the latency CoreDNS
+// reports in coredns_kubernetes_dns_programming_duration_seconds during the same time. CoreDNS only reports
+// the latency of EndpointSlices of headless services, from the time in their last change trigger annotation.
+type programmingResult struct {
+	Timestamp time.Time                  `json:"timestamp"`
+	CoreDNS   string                     `json:"coredns,omitempty"`
+	Observed  map[string]loadgen.Latency `json:"observed_ms"`
+	Reported  histogramSummary           `json:"reported"`
+}
+
+// histogramSummary summarizes the observations of a histogram. The percentiles are the upper bounds
+// of the buckets they fall in, or -1 if they are above the largest bucket.
+type histogramSummary struct {
+	Count uint64  `json:"count"`
+	Mean  float64 `json:"mean_ms"`
+	P50   float64 `json:"p50_ms"`
+	P95   float64 `json:"p95_ms"`
+	P99   float64 `json:"p99_ms"`
+}
+
+// Operations measured by TestKubernetesProgrammingLatency.
+const (
+	opServiceCreate       = "service-create"
+	opServiceDelete       = "service-delete"
+	opEndpointSliceCreate = "endpointslice-create"
+	opEndpointSliceUpdate = "endpointslice-update"
+)
+
+// TestKubernetesProgrammingLatency measures the time from creating, updating or deleting a Service or EndpointSlice
+// until CoreDNS answers accordingly, by polling it through a node port, and reports it next to the latency CoreDNS
+// reports itself.
+func TestKubernetesProgrammingLatency(t *testing.T) {
+	corefile := `    .:53 {
+        health
+        ready
+        errors
+        prometheus :9153
+        kubernetes cluster.local
+    }
+`
+	err := LoadCorefile(corefile)
+	if err != nil {
+		t.Fatalf("Could not load corefile: %s", err)
+	}
+	namespace := NewTestNamespace(t)
+	client, err := NewClient()
+	if err != nil {
+		t.Fatalf("could not create kubernetes client: %s", err)
+	}
+	server, _ := ExposeCoreDNS(t)
+
+	before := programmingHistogram(t)
+	observed := map[string][]time.Duration{}
+	measure := func(op, qname string, start time.Time, visible func(*dns.Msg) bool) {
+		if d, ok := waitForProgramming(t, server, qname, start, visible); ok {
+			observed[op] = append(observed[op], d)
+		}
+	}
+	ctx := context.TODO()
+	for i := range programmingIterations {
+		headless := fmt.Sprintf("headless-%d", i)
+		createService(t, client, namespace, headless, api.ClusterIPNone)
+
+		// the headless service is created first, so it is known once the cluster ip service is visible
+		name := fmt.Sprintf("svc-%d", i)
+		start := time.Now()
+		svc, err := client.CoreV1().Services(namespace).Create(ctx, &api.Service{
+			ObjectMeta: meta.ObjectMeta{Namespace: namespace, Name: name},
+			Spec:       api.ServiceSpec{Ports: []api.ServicePort{{Name: "http", Port: 80}}},
+		}, meta.CreateOptions{})
+		if err != nil {
+			t.Fatalf("could not create service: %s", err)
+		}
+		qname := name + "." + namespace + ".svc.cluster.local."
+		measure(opServiceCreate, qname, start, hasAddress(svc.Spec.ClusterIP))
+
+		qname = headless + "." + namespace + ".svc.cluster.local."
+		ip := fmt.Sprintf("192.0.2.%d", 2*i+1)
+		start = time.Now()
+		createEndpointSlice(t, client, namespace, headless, start, []discovery.Endpoint{{Addresses: []string{ip}}})
+		measure(opEndpointSliceCreate, qname, start, hasAddress(ip))
+
+		ip = fmt.Sprintf("192.0.2.%d", 2*i+2)
+		start = time.Now()
+		updateEndpointSlice(t, client, namespace, headless, start, []discovery.Endpoint{{Addresses: []string{ip}}})
+		measure(opEndpointSliceUpdate, qname, start, hasAddress(ip))
+
+		qname = name + "." + namespace + ".svc.cluster.local."
+		start = time.Now()
+		if err := client.CoreV1().Services(namespace).Delete(ctx, name, meta.DeleteOptions{}); err != nil {
+			t.Fatalf("could not delete service: %s", err)
+		}
+		measure(opServiceDelete, qname, start, func(m *dns.Msg) bool {
+			return m.Rcode == dns.RcodeNameError
+		})
+	}
+	after := programmingHistogram(t)
+
+	result := programmingResult{
+		Timestamp: time.Now().UTC(),
+		CoreDNS:   os.Getenv("COREDNS_COMMIT"),
+		Observed:  map[string]loadgen.Latency{},
+		Reported:  summarizeHistogram(before, after),
+	}
+	for op, durations := range observed {
+		result.Observed[op] = loadgen.Distribution(durations)
+		t.Logf("observed %s: %+v", op, result.Observed[op])
+	}
+	t.Logf("reported: %+v", result.Reported)
+	if n := len(observed[opEndpointSliceCreate]) + len(observed[opEndpointSliceUpdate]); result.Reported.Count < uint64(n) {
+		t.Errorf("expected at least %d programming latency observations to be reported, got %d", n, result.Reported.Count)
+	}
+
+	if dir := os.Getenv("REPORT_DIR"); dir != "" {
+		data, err := json.MarshalIndent(result, "", "  ")
+		if err == nil {
+			err = os.WriteFile(filepath.Join(dir, "programming-latency.json"), data, 0644)
+		}
+		if err != nil {
+			t.Errorf("could not write result: %s", err)
+		}
+	}
+}
+
+// waitForProgramming queries name at a high rate until visible returns true for a response, and returns the time
+// since start. It fails t if the change does not become visible.
+func waitForProgramming(t *testing.T, server, name string, start time.Time, visible func(*dns.Msg) bool) (time.Duration, bool) {
+	m := new(dns.Msg)
+	m.SetQuestion(name, dns.TypeA)
+	c := &dns.Client{Timeout: time.Second}
+	for {
+		res, _, err := c.Exchange(m, server)
+		if err == nil && visible(res) {
+			return time.Since(start), true
+		}
+		if time.Since(start) > programmingTimeout {
+			t.Errorf("change of %s not visible after %s", name, programmingTimeout)
+			return 0, false
+		}
+		time.Sleep(programmingPollInterval)
+	}
+}
+
+// hasAddress returns a function that reports whether a response has ip as its only A record.
+func hasAddress(ip string) func(*dns.Msg) bool {
+	return func(m *dns.Msg) bool {
+		if m.Rcode != dns.RcodeSuccess || len(m.Answer) != 1 {
+			return false
+		}
+		a, ok := m.Answer[0].(*dns.A)
+		return ok && a.A.String() == ip
+	}
+}
+
+// programmingHistogram returns the programming latency histogram of headless services, as scraped from CoreDNS.
+func programmingHistogram(t *testing.T) *dto.Histogram {
+	if mf, ok := ScrapeMetricFamilies(t)["coredns_kubernetes_dns_programming_duration_seconds"]; ok {
+		for _, m := range mf.Metric {
+			for _, l := range m.Label {
+				if l.GetName() == "service_kind" && l.GetValue() == "headless_with_selector" {
+					return m.Histogram
+				}
+			}
+		}
+	}
+	// nothing has been observed yet
+	return &dto.Histogram{}
+}
+
+// summarizeHistogram summarizes the observations made between two scrapes of a histogram.
+func summarizeHistogram(before, after *dto.Histogram) histogramSummary {
+	s := histogramSummary{Count: after.GetSampleCount() - before.GetSampleCount()}
+	if s.Count == 0 {
+		return s
+	}
+	s.Mean = (after.GetSampleSum() - before.GetSampleSum()) / float64(s.Count) * 1000
+
+	prev := map[float64]uint64{}
+	for _, b := range before.Bucket {
+		prev[b.GetUpperBound()] = b.GetCumulativeCount()
+	}
+	percentile := func(p float64) float64 {
+		rank := uint64(math.Ceil(p * float64(s.Count)))
+		for _, b := range after.Bucket {
+			if b.GetCumulativeCount()-prev[b.GetUpperBound()] >= rank {
+				return b.GetUpperBound() * 1000
+			}
+		}
+		return -1
+	}
+	s.P50, s.P95, s.P99 = percentile(0.5), percentile(0.95), percentile(0.99)
+	return s
+}
@@ -0,0 +1,111 @@
+package kubernetes
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+	api "k8s.io/api/core/v1"
+)
+
+// TestKubernetesDoQ serves the cluster zone and its reverse zones over DNS over QUIC, with a certificate of a
+// CA generated for the test, and runs the address, SRV and PTR cases over it. The cases are then sent again
+// concurrently on a single connection, each on a stream of its own.
+func TestKubernetesDoQ(t *testing.T) {
+	ca, err := NewCA()
+	if err != nil {
+		t.Fatalf("could not create CA: %s", err)
+	}
+	now := time.Now()
+	files := map[string]string{}
+	files["tls.crt"], files["tls.key"], err = ca.Issue([]string{transportServerName}, now.Add(-time.Hour), now.Add(24*time.Hour))
+	if err != nil {
+		t.Fatalf("could not issue certificate: %s", err)
+	}
+
+	corefile := `    .:53 {
+        health
+        ready
+        errors
+        log
+        kubernetes cluster.local
+    }
+    quic://.:853 {
+        tls /etc/coredns/tls.crt /etc/coredns/tls.key
+        errors
+        log
+        kubernetes cluster.local 10.96.0.0/24 172.17.0.0/24 1234:abcd::0/64 {
+            namespaces test-1
+        }
+    }
+`
+	err = LoadCorefileAndFiles(corefile, files)
+	if err != nil {
+		t.Fatalf("Could not load corefile and certificate: %s", err)
+	}
+
+	addr := ExposeCoreDNSPorts(t, api.ServicePort{Name: "quic", Port: 853, Protocol: api.ProtocolUDP})["quic"]
+	config := ca.ClientConfig(transportServerName)
+	if err := WaitForServer("quic://"+addr, config, exposeTimeout); err != nil {
+		t.Fatalf("coredns not reachable on quic://%s: %s", addr, err)
+	}
+
+	var tcs []test.Case
+	tcs = append(tcs, dnsTestCasesA...)
+	tcs = append(tcs, dnsTestCasesSRV...)
+	tcs = append(tcs, dnsTestCasesPTR...)
+	var cases []Case
+	for _, tc := range tcs {
+		cases = append(cases, Case{Case: tc, Retry: DefaultRetryPolicy, Server: "quic://" + addr, TLS: config})
+	}
+	DoIntegrationCases(t, cases, "test-1")
+
+	t.Run("concurrent queries", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), transportTimeout)
+		defer cancel()
+		conn, err := DialQUIC(ctx, addr, config)
+		if err != nil {
+			t.Fatalf("could not connect to quic://%s: %s", addr, err)
+		}
+		defer conn.Close()
+
+		// every case twice, so queries for the same name are in flight at the same time
+		var msgs []*dns.Msg
+		for i := 0; i < 2; i++ {
+			for _, tc := range tcs {
+				msgs = append(msgs, tc.Msg())
+			}
+		}
+		responses, errs := exchangeConcurrently(ctx, conn, msgs)
+		if len(errs) > 0 {
+			t.Fatalf("expected all queries to be answered, got %v", errs)
+		}
+		for i, r := range responses {
+			// sort copies, the records of the cases are shared with the other tests
+			tc := tcs[i%len(tcs)]
+			tc.Answer, tc.Ns, tc.Extra = slices.Clone(tc.Answer), slices.Clone(tc.Ns), slices.Clone(tc.Extra)
+			sort.Sort(test.RRSet(tc.Answer))
+			sort.Sort(test.RRSet(tc.Ns))
+			sort.Sort(test.RRSet(tc.Extra))
+			if err := test.SortAndCheck(r, tc); err != nil {
+				t.Errorf("%s %s: %s", tc.Qname, dns.TypeToString[tc.Qtype], err)
+			}
+		}
+
+		// one stream per query, all on the connection, which remains open for more
+		if err := checkStreams(conn.Streams(), len(msgs)); err != nil {
+			t.Error(err)
+		}
+		if err := conn.Err(); err != nil {
+			t.Fatalf("expected the connection to remain open, got %s", err)
+		}
+		if _, err := conn.Exchange(ctx, tcs[0].Msg()); err != nil {
+			t.Errorf("could not reuse the connection: %s", err)
+		}
+	})
+}
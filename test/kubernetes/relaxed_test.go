@@ -0,0 +1,33 @@
+package kubernetes
+
+import "testing"
+
+func TestZoneToRelaxedRegex(t *testing.T) {
+	tests := []struct {
+		expected, result string
+		match            bool
+	}{
+		{"svc-1-a.test-1.svc.cluster.local.", "svc-1-a.test-1.svc.cluster.local.", true},
+		{"svc-1-a.test-1.svc.cluster.local.", "svc-1-b.test-1.svc.cluster.local.", false},
+		{"172-17-0-254.headless-svc.test-1.svc.cluster.local.", "10-244-0-5.headless-svc.test-1.svc.cluster.local.", true},
+		{"fd00-10-244-1--30.headless-v6.test-1.svc.cluster.local.", "fd00-10-244--5.headless-v6.test-1.svc.cluster.local.", true},
+		{"fd00-10-244-1--30.headless-v6.test-1.svc.cluster.local.", "fd00-10-244--5.headless-v4.test-1.svc.cluster.local.", false},
+		{"1234-abcd--1.headless-svc.test-1.svc.cluster.local.", "headless-svc-3.headless-svc.test-1.svc.cluster.local.", false},
+		{"fd00-0-0-0-0-0-0-30.headless-v6.test-1.svc.cluster.local.", "fd00--5.headless-v6.test-1.svc.cluster.local.", true},
+		{"--1.headless-v6.test-1.svc.cluster.local.", "fd00-10-244-0-0-0-0-5.headless-v6.test-1.svc.cluster.local.", true},
+		// hex-only labels are names, not addresses
+		{"de-1-b.test-1.svc.cluster.local.", "de-1-c.test-1.svc.cluster.local.", false},
+		{"ad-be-ef.test-1.svc.cluster.local.", "ad-be-ee.test-1.svc.cluster.local.", false},
+		{"fd00-10-244-1--30.headless-v6.test-1.svc.cluster.local.", "de-1-b.headless-v6.test-1.svc.cluster.local.", false},
+		{"fd00-10-244-1--30.headless-v6.test-1.svc.cluster.local.", "fd00--10--5.headless-v6.test-1.svc.cluster.local.", false},
+	}
+	for _, tc := range tests {
+		re, err := zoneToRelaxedRegex(tc.expected)
+		if err != nil {
+			t.Fatalf("could not convert %s: %s", tc.expected, err)
+		}
+		if got := re.MatchString(tc.result); got != tc.match {
+			t.Errorf("expected match of %s with %s to be %t", tc.expected, tc.result, tc.match)
+		}
+	}
+}
@@ -22,6 +22,8 @@ func TestReload(t *testing.T) {
 		t.Fatalf("Could not load corefile: %s", err)
 	}
 
+	namespace := NewTestNamespace(t)
+	CollectArtifacts(t, namespace)
 	err = StartClientPod(namespace)
 	if err != nil {
 		t.Fatalf("failed to start client pod: %s", err)
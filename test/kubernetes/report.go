@@ -0,0 +1,272 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+// Report is a machine-readable report of the query test cases executed by a top level test.
+// When $REPORT_DIR is set, reports are written there as <test>.json and <test>.xml (JUnit).
+type Report struct {
+	Suite     string       `json:"suite"`
+	Timestamp time.Time    `json:"timestamp"`
+	CoreDNS   string       `json:"coredns,omitempty"` // CoreDNS commit under test, from $COREDNS_COMMIT
+	Cases     []ReportCase `json:"cases"`
+}
+
+// ReportCase is the result of a single query test case.
+type ReportCase struct {
+	Name     string         `json:"name"`
+	Qname    string         `json:"qname"`
+	Qtype    string         `json:"qtype"`
+	Status   string         `json:"status"` // "passed", "flaky" or "failed"
+	Failure  string         `json:"failure,omitempty"`
+	Expected ReportMessage  `json:"expected"`
+	Actual   *ReportMessage `json:"actual,omitempty"`
+	Latency  float64        `json:"latency_ms"`
+	Attempts int            `json:"attempts"`                  // number of times the response was checked
+	Failed   []string       `json:"failed_attempts,omitempty"` // failures of the attempts before the last one
+	Retries  int            `json:"retries"`                   // transport retries of the last attempt
+	Server   string         `json:"server,omitempty"`
+	Pod      string         `json:"pod,omitempty"` // CoreDNS pod that answered, if it could be determined
+}
+
+// ReportMessage is the rcode and sections of an expected or actual response.
+type ReportMessage struct {
+	Rcode  string   `json:"rcode"`
+	Answer []string `json:"answer,omitempty"`
+	Ns     []string `json:"ns,omitempty"`
+	Extra  []string `json:"extra,omitempty"`
+}
+
+// Report case statuses.
+const (
+	StatusPassed = "passed"
+	StatusFlaky  = "flaky" // passed, but only after a failed attempt
+	StatusFailed = "failed"
+)
+
+// caseResult is the outcome of a test case with its retries.
+type caseResult struct {
+	res      *dns.Msg
+	info     queryInfo // details of the last query
+	attempts int
+	failed   []error // failures of the attempts before the last one
+	failure  error   // failure of the last attempt
+}
+
+var (
+	reports   = make(map[string]*Report)
+	reportsMu sync.Mutex
+)
+
+// reportCase records the result of a test case in the report of the top level test of t,
+// and rewrites the report files. It does nothing if $REPORT_DIR is not set.
+func reportCase(t *testing.T, tc test.Case, result caseResult) {
+	dir := os.Getenv("REPORT_DIR")
+	if dir == "" {
+		return
+	}
+
+	rc := ReportCase{
+		Name:     t.Name(),
+		Qname:    tc.Qname,
+		Qtype:    dns.TypeToString[tc.Qtype],
+		Status:   StatusPassed,
+		Expected: reportMessage(tc.Rcode, tc.Answer, tc.Ns, tc.Extra),
+		Latency:  float64(result.info.latency) / float64(time.Millisecond),
+		Attempts: result.attempts,
+		Server:   result.info.server,
+		Pod:      corednsPodForServer(result.info.server),
+	}
+	if result.info.attempts > 0 {
+		rc.Retries = result.info.attempts - 1
+	}
+	for _, err := range result.failed {
+		rc.Failed = append(rc.Failed, err.Error())
+	}
+	if result.res != nil {
+		actual := reportMessage(result.res.Rcode, result.res.Answer, result.res.Ns, result.res.Extra)
+		rc.Actual = &actual
+	}
+	switch {
+	case result.failure != nil:
+		rc.Status = StatusFailed
+		rc.Failure = result.failure.Error()
+	case len(result.failed) > 0:
+		rc.Status = StatusFlaky
+	}
+
+	suite := strings.SplitN(t.Name(), "/", 2)[0]
+
+	reportsMu.Lock()
+	defer reportsMu.Unlock()
+	r, ok := reports[suite]
+	if !ok {
+		r = &Report{Suite: suite, Timestamp: time.Now(), CoreDNS: os.Getenv("COREDNS_COMMIT")}
+		reports[suite] = r
+	}
+	r.Cases = append(r.Cases, rc)
+	if err := r.Write(dir); err != nil {
+		t.Logf("could not write report: %s", err)
+	}
+}
+
+func reportMessage(rcode int, answer, ns, extra []dns.RR) ReportMessage {
+	rrs := func(rrs []dns.RR) []string {
+		var s []string
+		for _, rr := range rrs {
+			s = append(s, rr.String())
+		}
+		return s
+	}
+	return ReportMessage{Rcode: dns.RcodeToString[rcode], Answer: rrs(answer), Ns: rrs(ns), Extra: rrs(extra)}
+}
+
+// corednsPodForServer returns the name of the coredns pod with the given ip, or the only coredns pod
+// if there is just one (server is then usually the kube-dns service address).
+func corednsPodForServer(server string) string {
+	out, err := Kubectl("-n kube-system get pods -l " + CoreDNSLabel + ` -o jsonpath='{range .items[*]}{.metadata.name} {.status.podIP}{"\n"}{end}'`)
+	if err != nil {
+		return ""
+	}
+	var pods []string
+	for _, l := range strings.Split(strings.TrimSpace(out), "\n") {
+		f := strings.Fields(l)
+		if len(f) == 0 {
+			continue
+		}
+		if len(f) == 2 && f[1] == server {
+			return f[0]
+		}
+		pods = append(pods, f[0])
+	}
+	if len(pods) == 1 {
+		return pods[0]
+	}
+	return ""
+}
+
+// Write writes the report as JSON and JUnit XML files to dir.
+func (r *Report) Write(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	base := filepath.Join(dir, artifactDirName(r.Suite))
+
+	j, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(base+".json", j, 0644); err != nil {
+		return err
+	}
+
+	x, err := xml.MarshalIndent(r.junit(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(base+".xml", append([]byte(xml.Header), x...), 0644)
+}
+
+type junitTestSuite struct {
+	XMLName    xml.Name        `xml:"testsuite"`
+	Name       string          `xml:"name,attr"`
+	Tests      int             `xml:"tests,attr"`
+	Failures   int             `xml:"failures,attr"`
+	Time       string          `xml:"time,attr"`
+	Timestamp  string          `xml:"timestamp,attr"`
+	Properties []junitProperty `xml:"properties>property,omitempty"`
+	TestCases  []junitTestCase `xml:"testcase"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitTestCase struct {
+	Name      string         `xml:"name,attr"`
+	Classname string         `xml:"classname,attr"`
+	Time      string         `xml:"time,attr"`
+	Failure   *junitFailure  `xml:"failure,omitempty"`
+	Flaky     []junitFailure `xml:"flakyFailure,omitempty"` // failed attempts of a case that passed on retry
+	SystemOut string         `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message  string `xml:"message,attr"`
+	Contents string `xml:",chardata"`
+}
+
+// junit converts the report to a JUnit test suite.
+func (r *Report) junit() junitTestSuite {
+	s := junitTestSuite{
+		Name:      r.Suite,
+		Tests:     len(r.Cases),
+		Timestamp: r.Timestamp.Format(time.RFC3339),
+	}
+	if r.CoreDNS != "" {
+		s.Properties = append(s.Properties, junitProperty{Name: "coredns", Value: r.CoreDNS})
+	}
+	var total float64
+	for _, c := range r.Cases {
+		total += c.Latency
+		tc := junitTestCase{
+			Name:      c.Name,
+			Classname: r.Suite,
+			Time:      fmt.Sprintf("%.3f", c.Latency/1000),
+			SystemOut: c.details(),
+		}
+		switch c.Status {
+		case StatusFailed:
+			s.Failures++
+			tc.Failure = &junitFailure{Message: c.Failure, Contents: c.Failure}
+		case StatusFlaky:
+			for _, f := range c.Failed {
+				tc.Flaky = append(tc.Flaky, junitFailure{Message: f, Contents: f})
+			}
+		}
+		s.TestCases = append(s.TestCases, tc)
+	}
+	s.Time = fmt.Sprintf("%.3f", total/1000)
+	return s
+}
+
+// details returns the DNS level details of a case for inclusion in the JUnit output.
+func (c ReportCase) details() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "query: %s %s\n", c.Qname, c.Qtype)
+	fmt.Fprintf(&b, "server: %s pod: %s attempts: %d retries: %d latency: %.0fms\n", c.Server, c.Pod, c.Attempts, c.Retries, c.Latency)
+	fmt.Fprintf(&b, "expected:\n%s", c.Expected)
+	if c.Actual != nil {
+		fmt.Fprintf(&b, "actual:\n%s", *c.Actual)
+	}
+	return b.String()
+}
+
+// String returns the message in a dig like format.
+func (m ReportMessage) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "  rcode: %s\n", m.Rcode)
+	for _, s := range []struct {
+		name string
+		rrs  []string
+	}{{"ANSWER", m.Answer}, {"AUTHORITY", m.Ns}, {"ADDITIONAL", m.Extra}} {
+		for _, rr := range s.rrs {
+			fmt.Fprintf(&b, "  %s: %s\n", s.name, rr)
+		}
+	}
+	return b.String()
+}
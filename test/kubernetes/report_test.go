@@ -0,0 +1,67 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReportWrite(t *testing.T) {
+	r := &Report{
+		Suite:     "TestKubernetesA",
+		Timestamp: time.Now(),
+		CoreDNS:   "0123abcd",
+		Cases: []ReportCase{
+			{
+				Name: "TestKubernetesA/svc-1-a.test-1.svc.cluster.local._A", Qname: "svc-1-a.test-1.svc.cluster.local.", Qtype: "A",
+				Status:   StatusPassed,
+				Expected: ReportMessage{Rcode: "NOERROR", Answer: []string{"svc-1-a.test-1.svc.cluster.local.\t5\tIN\tA\t10.96.0.100"}},
+				Latency:  2,
+			},
+			{
+				Name: "TestKubernetesA/bogusservice.test-1.svc.cluster.local._A", Qname: "bogusservice.test-1.svc.cluster.local.", Qtype: "A",
+				Status:   StatusFailed,
+				Failure:  "rcode is \"NOERROR\", expected \"NXDOMAIN\"",
+				Expected: ReportMessage{Rcode: "NXDOMAIN"},
+				Actual:   &ReportMessage{Rcode: "NOERROR"},
+				Latency:  3,
+				Retries:  1,
+			},
+		},
+	}
+
+	dir := t.TempDir()
+	if err := r.Write(dir); err != nil {
+		t.Fatalf("could not write report: %s", err)
+	}
+
+	j, err := os.ReadFile(filepath.Join(dir, "TestKubernetesA.json"))
+	if err != nil {
+		t.Fatalf("could not read json report: %s", err)
+	}
+	var got Report
+	if err := json.Unmarshal(j, &got); err != nil {
+		t.Fatalf("could not parse json report: %s", err)
+	}
+	if len(got.Cases) != 2 || got.Cases[1].Actual == nil || got.Cases[1].Actual.Rcode != "NOERROR" {
+		t.Errorf("unexpected json report: %s", j)
+	}
+
+	x, err := os.ReadFile(filepath.Join(dir, "TestKubernetesA.xml"))
+	if err != nil {
+		t.Fatalf("could not read junit report: %s", err)
+	}
+	for _, want := range []string{
+		`<testsuite name="TestKubernetesA" tests="2" failures="1"`,
+		`<property name="coredns" value="0123abcd">`,
+		`<failure message="rcode is &#34;NOERROR&#34;, expected &#34;NXDOMAIN&#34;">`,
+		"retries: 1",
+	} {
+		if !strings.Contains(string(x), want) {
+			t.Errorf("expected junit report to contain %q, got:\n%s", want, x)
+		}
+	}
+}
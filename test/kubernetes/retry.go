@@ -0,0 +1,64 @@
+package kubernetes
+
+import (
+	"crypto/tls"
+	"errors"
+	"time"
+
+	"github.com/coredns/coredns/plugin/test"
+)
+
+// RetryPolicy controls how often a test case is attempted before it is considered failed.
+// Transport failures, where the query could not be executed or its output not be parsed, are retried
+// separately from assertion failures, where a response was received but did not match the expected one.
+type RetryPolicy struct {
+	Transport int           // number of retries after a transport failure
+	Assertion int           // number of retries after an assertion failure
+	Interval  time.Duration // time to wait before retrying after an assertion failure
+}
+
+// DefaultRetryPolicy retries transport failures, but fails a case on the first unexpected response.
+var DefaultRetryPolicy = RetryPolicy{Transport: 2}
+
+// Case is a test case with a retry policy, for cases that are expected to be timing sensitive,
+// such as a query for an object that was just created.
+type Case struct {
+	test.Case
+	Retry RetryPolicy
+	// Server is the address the query is sent to, instead of the nameserver of the client pod.
+	// An IPv6 address makes the query go over IPv6. A server with a tls://, https://, grpc:// or quic://
+	// scheme is queried over that transport from the test, see Exchange.
+	Server string
+	// TLS is the TLS configuration of the client for a server with an encrypted transport.
+	TLS *tls.Config
+}
+
+// Do calls f until it returns nil, or the assertion retries of the policy are exhausted. Transport errors
+// returned by f are not retried, since they have already been retried according to the policy.
+// It returns the errors of the failed attempts before the last one, and the error of the last attempt.
+// A call that succeeds after failed attempts is flaky.
+func (p RetryPolicy) Do(f func() error) (failed []error, err error) {
+	for {
+		err = f()
+		var te transportError
+		if err == nil || errors.As(err, &te) || len(failed) >= p.Assertion {
+			return failed, err
+		}
+		failed = append(failed, err)
+		time.Sleep(p.Interval)
+	}
+}
+
+// transportError is an error executing a query, as opposed to an unexpected response.
+type transportError struct{ error }
+
+func (e transportError) Unwrap() error { return e.error }
+
+// Cases wraps test cases in Cases with the default retry policy.
+func Cases(tcs []test.Case) []Case {
+	cases := make([]Case, len(tcs))
+	for i := range tcs {
+		cases[i] = Case{Case: tcs[i], Retry: DefaultRetryPolicy}
+	}
+	return cases
+}
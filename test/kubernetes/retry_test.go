@@ -0,0 +1,44 @@
+package kubernetes
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRetryPolicyDo(t *testing.T) {
+	tests := []struct {
+		name       string
+		policy     RetryPolicy
+		errs       []error // errors returned by consecutive calls, nil once exhausted
+		wantCalls  int
+		wantFailed int
+		wantErr    bool
+	}{
+		{"pass", RetryPolicy{Assertion: 2}, nil, 1, 0, false},
+		{"flaky", RetryPolicy{Assertion: 2}, []error{errors.New("mismatch")}, 2, 1, false},
+		{"fail", RetryPolicy{Assertion: 1}, []error{errors.New("mismatch"), errors.New("mismatch"), errors.New("mismatch")}, 2, 1, true},
+		{"no assertion retries", RetryPolicy{}, []error{errors.New("mismatch")}, 1, 0, true},
+		{"transport", RetryPolicy{Assertion: 2}, []error{transportError{errors.New("exec failed")}}, 1, 0, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			calls := 0
+			failed, err := tc.policy.Do(func() error {
+				calls++
+				if calls <= len(tc.errs) {
+					return tc.errs[calls-1]
+				}
+				return nil
+			})
+			if calls != tc.wantCalls {
+				t.Errorf("expected %d calls, got %d", tc.wantCalls, calls)
+			}
+			if len(failed) != tc.wantFailed {
+				t.Errorf("expected %d failed attempts, got %d", tc.wantFailed, len(failed))
+			}
+			if (err != nil) != tc.wantErr {
+				t.Errorf("expected error %t, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,327 @@
+// Package scale measures how the kubernetes plugin of CoreDNS behaves with a large number of objects. A run
+// applies a set generated with fixture.Generate to a cluster or a fake clientset, starts CoreDNS in-process
+// watching it, and measures how long the informers take to sync, how much the heap grows, and query latency.
+package scale
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/coredns/ci/test/kubernetes/fakeapi"
+	"github.com/coredns/ci/test/kubernetes/fixture"
+
+	"github.com/coredns/caddy"
+	ctest "github.com/coredns/coredns/test"
+	"github.com/miekg/dns"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// Modes of a run.
+const (
+	ModeFake    = "fake"    // objects are served from a fake clientset
+	ModeCluster = "cluster" // objects are created in the cluster of the kubeconfig
+)
+
+const (
+	// syncTimeout is how long a run waits for CoreDNS to be ready.
+	syncTimeout = 10 * time.Minute
+	// applyWorkers is the number of namespaces applied to a cluster concurrently.
+	applyWorkers = 8
+)
+
+// Options configures a run.
+type Options struct {
+	Mode       string
+	Kubeconfig string // kubeconfig of the cluster in ModeCluster
+	Prefix     string // prefix of the generated namespaces, defaults to "scale"
+	Namespaces int
+	Services   int // per namespace
+	Endpoints  int // per service
+	Queries    int // number of queries for the latency measurement
+}
+
+// Result is the result of a run. Results of runs with the same mode and size can be compared across runs.
+type Result struct {
+	Name       string    `json:"name"`
+	Timestamp  time.Time `json:"timestamp"`
+	CoreDNS    string    `json:"coredns,omitempty"` // CoreDNS commit under test, from $COREDNS_COMMIT
+	Mode       string    `json:"mode"`
+	Namespaces int       `json:"namespaces"`
+	Services   int       `json:"services"`  // in total
+	Endpoints  int       `json:"endpoints"` // in total
+	Slices     int       `json:"endpointslices"`
+	Apply      float64   `json:"apply_ms"` // time to create the objects
+	Sync       float64   `json:"sync_ms"`  // time from starting CoreDNS until its informers have synced
+	// HeapBefore and HeapAfter are the live heap before CoreDNS starts and once it has synced. In ModeFake
+	// the heap includes the fake clientset, which is allocated before.
+	HeapBefore uint64  `json:"heap_before_bytes"`
+	HeapAfter  uint64  `json:"heap_after_bytes"`
+	HeapGrowth int64   `json:"heap_growth_bytes"`
+	Queries    int     `json:"queries"`
+	Errors     int     `json:"errors"` // queries that failed or had no answer
+	Latency    Latency `json:"latency_ms"`
+}
+
+// Latency is the distribution of query latencies in milliseconds.
+type Latency struct {
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P99  float64 `json:"p99"`
+	Max  float64 `json:"max"`
+}
+
+// Run generates the objects of opts, applies them and measures CoreDNS. In ModeCluster the generated
+// namespaces are deleted afterwards.
+func Run(ctx context.Context, opts Options) (*Result, error) {
+	if opts.Prefix == "" {
+		opts.Prefix = "scale"
+	}
+	set, err := fixture.Generate(opts.Prefix, opts.Namespaces, opts.Services, opts.Endpoints)
+	if err != nil {
+		return nil, err
+	}
+	r := &Result{
+		Name:       fmt.Sprintf("scale-%s-%dx%dx%d", opts.Mode, opts.Namespaces, opts.Services, opts.Endpoints),
+		Timestamp:  time.Now().UTC(),
+		CoreDNS:    os.Getenv("COREDNS_COMMIT"),
+		Mode:       opts.Mode,
+		Namespaces: opts.Namespaces,
+		Services:   opts.Namespaces * opts.Services,
+		Endpoints:  opts.Namespaces * opts.Services * opts.Endpoints,
+	}
+	if opts.Endpoints > 0 {
+		r.Slices = r.Services
+	}
+
+	kubeconfig := opts.Kubeconfig
+	switch opts.Mode {
+	case ModeFake:
+		client := fake.NewSimpleClientset()
+		start := time.Now()
+		if err := set.Apply(ctx, client); err != nil {
+			return nil, fmt.Errorf("could not apply fixtures: %s", err)
+		}
+		r.Apply = milliseconds(time.Since(start))
+
+		srv := fakeapi.New(client)
+		defer srv.Close()
+		dir, err := os.MkdirTemp("", "scale")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(dir)
+		kubeconfig = filepath.Join(dir, "kubeconfig")
+		if err := srv.WriteKubeconfig(kubeconfig); err != nil {
+			return nil, err
+		}
+	case ModeCluster:
+		client, err := newClient(kubeconfig)
+		if err != nil {
+			return nil, err
+		}
+		defer set.Delete(context.Background(), client)
+		set.AllocateClusterIPs()
+		start := time.Now()
+		if err := apply(ctx, set, client); err != nil {
+			return nil, fmt.Errorf("could not apply fixtures: %s", err)
+		}
+		r.Apply = milliseconds(time.Since(start))
+		if err := set.LoadClusterIPs(ctx, client); err != nil {
+			return nil, fmt.Errorf("could not load cluster IPs: %s", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown mode %q", opts.Mode)
+	}
+
+	r.HeapBefore = heapInuse()
+	start := time.Now()
+	server, udp, ready, err := startCoreDNS(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	defer server.Stop()
+	if err := waitReady(ctx, ready); err != nil {
+		return nil, err
+	}
+	r.Sync = milliseconds(time.Since(start))
+	r.HeapAfter = heapInuse()
+	r.HeapGrowth = int64(r.HeapAfter) - int64(r.HeapBefore)
+
+	r.Queries = opts.Queries
+	r.Errors, r.Latency = query(udp, set, opts.Queries)
+	return r, nil
+}
+
+// Write writes the result as JSON to <dir>/<name>.json.
+func (r *Result) Write(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, r.Name+".json"), data, 0644)
+}
+
+// newClient returns a client for the cluster of kubeconfig, without the default client side rate limit,
+// which would make creating the objects take hours.
+func newClient(kubeconfig string) (kubernetes.Interface, error) {
+	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	config.QPS, config.Burst = 500, 1000
+	return kubernetes.NewForConfig(config)
+}
+
+// apply applies the namespaces of set concurrently.
+func apply(ctx context.Context, set *fixture.Set, client kubernetes.Interface) error {
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		first error
+	)
+	work := make(chan fixture.Namespace)
+	for range applyWorkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ns := range work {
+				s := fixture.Set{Namespaces: []fixture.Namespace{ns}}
+				if err := s.Apply(ctx, client); err != nil {
+					mu.Lock()
+					if first == nil {
+						first = err
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, ns := range set.Namespaces {
+		work <- ns
+	}
+	close(work)
+	wg.Wait()
+	return first
+}
+
+// startCoreDNS starts CoreDNS serving cluster.local from the cluster of kubeconfig, and returns the address
+// it serves on and the URL of its readiness endpoint.
+func startCoreDNS(kubeconfig string) (server *caddy.Instance, udp, ready string, err error) {
+	// the ready plugin needs a known port, so take a free one
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, "", "", err
+	}
+	readyAddr := l.Addr().String()
+	l.Close()
+
+	corefile := `.:0 {
+    bind 127.0.0.1
+    ready ` + readyAddr + `
+    kubernetes cluster.local {
+        kubeconfig ` + kubeconfig + `
+    }
+}`
+	i, udp, _, err := ctest.CoreDNSServerAndPorts(corefile)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("could not start CoreDNS: %s", err)
+	}
+	return i, udp, "http://" + readyAddr + "/ready", nil
+}
+
+// waitReady polls the readiness endpoint of CoreDNS until it reports ready.
+func waitReady(ctx context.Context, url string) error {
+	ctx, cancel := context.WithTimeout(ctx, syncTimeout)
+	defer cancel()
+	for {
+		resp, err := http.Get(url)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("CoreDNS not ready after %s", syncTimeout)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// query sends n A queries for randomly chosen services of set to addr, and returns the number of queries
+// that failed or were not answered and the distribution of the latencies.
+func query(addr string, set *fixture.Set, n int) (errors int, latency Latency) {
+	var names []string
+	for _, ns := range set.Namespaces {
+		for _, svc := range ns.Services {
+			// headless services without endpoints don't have A records
+			if len(svc.ClusterIPs) > 0 || len(svc.Endpoints) > 0 {
+				names = append(names, svc.Name+"."+ns.Name+".svc.cluster.local.")
+			}
+		}
+	}
+	if len(names) == 0 || n == 0 {
+		return 0, latency
+	}
+
+	c := new(dns.Client)
+	// seed the choice, so runs query the same names
+	rnd := rand.New(rand.NewSource(1))
+	durations := make([]time.Duration, 0, n)
+	for range n {
+		m := new(dns.Msg)
+		m.SetQuestion(names[rnd.Intn(len(names))], dns.TypeA)
+		start := time.Now()
+		res, _, err := c.Exchange(m, addr)
+		durations = append(durations, time.Since(start))
+		if err != nil || res.Rcode != dns.RcodeSuccess || len(res.Answer) == 0 {
+			errors++
+		}
+	}
+	return errors, distribution(durations)
+}
+
+func distribution(durations []time.Duration) Latency {
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	var total time.Duration
+	for _, d := range durations {
+		total += d
+	}
+	percentile := func(p float64) float64 {
+		return milliseconds(durations[int(p*float64(len(durations)-1))])
+	}
+	return Latency{
+		Mean: milliseconds(total / time.Duration(len(durations))),
+		P50:  percentile(0.5),
+		P90:  percentile(0.9),
+		P99:  percentile(0.99),
+		Max:  milliseconds(durations[len(durations)-1]),
+	}
+}
+
+// heapInuse returns the bytes in use by the heap after a garbage collection.
+func heapInuse() uint64 {
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return m.HeapInuse
+}
+
+func milliseconds(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
@@ -0,0 +1,44 @@
+package scale
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+)
+
+// TestScale runs a small scale measurement against a fake clientset. The size is set with
+// $SCALE=<namespaces>x<services>x<endpoints>, and $SCALE_MODE=cluster runs it against the cluster in
+// $KUBECONFIG. Results are written to $REPORT_DIR when it is set.
+func TestScale(t *testing.T) {
+	opts := Options{Mode: ModeFake, Namespaces: 2, Services: 10, Endpoints: 3, Queries: 100}
+	if s := os.Getenv("SCALE"); s != "" {
+		if _, err := fmt.Sscanf(s, "%dx%dx%d", &opts.Namespaces, &opts.Services, &opts.Endpoints); err != nil {
+			t.Fatalf("could not parse $SCALE %q: %s", s, err)
+		}
+		opts.Queries = 1000
+	}
+	if mode := os.Getenv("SCALE_MODE"); mode != "" {
+		opts.Mode = mode
+		opts.Kubeconfig = os.Getenv("KUBECONFIG")
+	}
+
+	r, err := Run(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("scale run failed: %s", err)
+	}
+	t.Logf("%s: sync %.0fms, heap growth %d bytes, latency p50 %.2fms p99 %.2fms",
+		r.Name, r.Sync, r.HeapGrowth, r.Latency.P50, r.Latency.P99)
+	if r.Errors > 0 {
+		t.Errorf("%d of %d queries failed", r.Errors, r.Queries)
+	}
+	if r.Services != opts.Namespaces*opts.Services {
+		t.Errorf("expected %d services, got %d", opts.Namespaces*opts.Services, r.Services)
+	}
+
+	if dir := os.Getenv("REPORT_DIR"); dir != "" {
+		if err := r.Write(dir); err != nil {
+			t.Errorf("could not write result: %s", err)
+		}
+	}
+}
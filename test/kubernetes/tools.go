@@ -19,12 +19,39 @@ import (
 	ctest "github.com/coredns/coredns/test"
 
 	"github.com/miekg/dns"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
 	// Load all managed plugins in github.com/coredns/coredns
 	_ "github.com/coredns/coredns/core/plugin"
 )
 
-// DoIntegrationTest executes a test case
-func DoIntegrationTest(tc test.Case, namespace string) (*dns.Msg, error) {
+// DoIntegrationTest executes a test case for t, and records it in the artifacts of t
+func DoIntegrationTest(t *testing.T, tc test.Case, namespace string) (*dns.Msg, error) {
+	res, _, err := doIntegrationTest(Case{Case: tc, Retry: DefaultRetryPolicy}, namespace)
+	recordQuery(t, tc, res, err)
+	return res, err
+}
+
+// queryInfo holds details about how a query executed in the client pod was answered
+type queryInfo struct {
+	attempts int           // number of times the query was executed in the client pod
+	latency  time.Duration // query time reported by dig
+	server   string        // address of the server that answered, as reported by dig
+}
+
+var (
+	digQueryTime = regexp.MustCompile(`(?m)^;; Query time: (\d+) msec`)
+	digServer    = regexp.MustCompile(`(?m)^;; SERVER: ([^#\s]+)#`)
+)
+
+// doIntegrationTest executes a query in the client pod, retrying as often as the transport retries of the
+// case allow if the query could not be executed. A query for a server with an encrypted transport is
+// executed from the test instead.
+func doIntegrationTest(c Case, namespace string) (*dns.Msg, queryInfo, error) {
+	if encryptedTransport(c.Server) {
+		return exchangeIntegrationTest(c)
+	}
+	tc := c.Case
 	var digCmd string
 	var dp DigParser
 	switch tc.Qtype {
@@ -35,69 +62,136 @@ func DoIntegrationTest(tc test.Case, namespace string) (*dns.Msg, error) {
 		digCmd = "dig -t " + dns.TypeToString[tc.Qtype] + " " + tc.Qname + " +search +showsearch +time=10 +tries=6"
 		dp = parseDig
 	}
+	if c.Server != "" {
+		_, addr := splitScheme(c.Server)
+		digCmd += " @" + addr
+	}
 
 	// attach to client and execute query.
+	var info queryInfo
 	var cmdout string
 	var err error
-	tries := 3
 	for {
+		info.attempts++
 		cmdout, err = Kubectl("-n " + namespace + " exec " + clientName + " -- " + digCmd)
 		if err == nil {
 			break
 		}
-		tries = tries - 1
-		if tries == 0 {
-			return nil, errors.New("failed to execute query '" + digCmd + "' got error: '" + err.Error() + "'")
+		if info.attempts > c.Retry.Transport {
+			return nil, info, errors.New("failed to execute query '" + digCmd + "' got error: '" + err.Error() + "'")
 		}
 		time.Sleep(500 * time.Millisecond)
 	}
+	if m := digQueryTime.FindAllStringSubmatch(cmdout, -1); len(m) > 0 {
+		msec, _ := strconv.Atoi(m[len(m)-1][1])
+		info.latency = time.Duration(msec) * time.Millisecond
+	}
+	if m := digServer.FindAllStringSubmatch(cmdout, -1); len(m) > 0 {
+		info.server = m[len(m)-1][1]
+	}
 	results, err := ParseDigResponse(cmdout, dp)
 
 	if err != nil {
-		return nil, errors.New("failed to parse result: (" + err.Error() + ")" + cmdout)
+		return nil, info, errors.New("failed to parse result: (" + err.Error() + ")" + cmdout)
 	}
 	if len(results) != 1 {
 		resultStr := ""
 		for i, r := range results {
 			resultStr += fmt.Sprintf("\nResponse %v\n", i) + r.String()
 		}
-		return nil, errors.New("expected 1 query attempt, observed " + strconv.Itoa(len(results)) + resultStr)
+		return nil, info, errors.New("expected 1 query attempt, observed " + strconv.Itoa(len(results)) + resultStr)
+	}
+	return results[0], info, nil
+}
+
+// exchangeIntegrationTest sends the query of a case to its server with Exchange, retrying as often as the
+// transport retries of the case allow if no response was received.
+func exchangeIntegrationTest(c Case) (*dns.Msg, queryInfo, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(c.Qname, c.Qtype)
+	_, addr := splitScheme(c.Server)
+	info := queryInfo{server: addr}
+	for {
+		info.attempts++
+		start := time.Now()
+		res, err := Exchange(m, c.Server, c.TLS)
+		if err == nil {
+			info.latency = time.Since(start)
+			return res, info, nil
+		}
+		if info.attempts > c.Retry.Transport {
+			return nil, info, fmt.Errorf("failed to query %s over %s: %s", c.Qname, c.Server, err)
+		}
+		time.Sleep(500 * time.Millisecond)
 	}
-	return results[0], nil
 }
 
 // DoIntegrationTests executes test cases
 func DoIntegrationTests(t *testing.T, testCases []test.Case, namespace string) {
+	DoIntegrationCases(t, Cases(testCases), namespace)
+}
+
+// DoIntegrationCases executes test cases with their retry policy
+func DoIntegrationCases(t *testing.T, cases []Case, namespace string) {
+	CollectArtifacts(t, namespace)
 	err := StartClientPod(namespace)
 	if err != nil {
 		t.Fatalf("failed to start client pod: %s", err)
 	}
-	for _, tc := range testCases {
-		t.Run(fmt.Sprintf("%s %s", tc.Qname, dns.TypeToString[tc.Qtype]), func(t *testing.T) {
-			res, err := DoIntegrationTest(tc, namespace)
-			if err != nil {
-				t.Error(err.Error())
-			}
-			test.CNAMEOrder(res)
-			sort.Sort(test.RRSet(tc.Answer))
-			sort.Sort(test.RRSet(tc.Ns))
-			sort.Sort(test.RRSet(tc.Extra))
-			if err := test.SortAndCheck(res, tc); err != nil {
-				t.Error(err)
-			}
-			if t.Failed() {
-				t.Errorf("coredns log: %s", CorednsLogs())
-			}
+	for _, c := range cases {
+		t.Run(fmt.Sprintf("%s %s", c.Qname, dns.TypeToString[c.Qtype]), func(t *testing.T) {
+			DoIntegrationCase(t, c, namespace)
 		})
 	}
 }
 
+// DoIntegrationTestCase executes a test case from the client pod in namespace and checks the response,
+// using the default retry policy.
+func DoIntegrationTestCase(t *testing.T, tc test.Case, namespace string) {
+	DoIntegrationCase(t, Case{Case: tc, Retry: DefaultRetryPolicy}, namespace)
+}
+
+// DoIntegrationCase executes a test case from the client pod in namespace and checks the response,
+// retrying as allowed by the retry policy of the case. A case that only passes on retry is logged
+// and reported as flaky. The result is recorded in the test report if $REPORT_DIR is set.
+func DoIntegrationCase(t *testing.T, c Case, namespace string) {
+	tc := c.Case
+	sort.Sort(test.RRSet(tc.Answer))
+	sort.Sort(test.RRSet(tc.Ns))
+	sort.Sort(test.RRSet(tc.Extra))
+
+	var (
+		res  *dns.Msg
+		info queryInfo
+	)
+	failed, err := c.Retry.Do(func() error {
+		var err error
+		res, info, err = doIntegrationTest(Case{Case: tc, Retry: c.Retry, Server: c.Server, TLS: c.TLS}, namespace)
+		recordQuery(t, tc, res, err)
+		if err != nil {
+			return transportError{err}
+		}
+		test.CNAMEOrder(res)
+		return test.SortAndCheck(res, tc)
+	})
+	result := caseResult{res: res, info: info, attempts: len(failed) + 1, failed: failed, failure: err}
+	switch {
+	case err != nil:
+		t.Error(err)
+	case len(failed) > 0:
+		t.Logf("flaky: passed on attempt %d, earlier failures: %v", result.attempts, failed)
+	}
+	reportCase(t, tc, result)
+	if t.Failed() {
+		t.Errorf("coredns log: %s", CorednsLogs())
+	}
+}
+
 // StartClientPod starts a dns client pod in the namespace
 func StartClientPod(namespace string) error {
 	_, err := Kubectl("-n " + namespace + " run " + clientName + " --image=infoblox/dnstools --restart=Never -- -c 'while [ 1 ]; do sleep 100; done'")
-	if err != nil {
-		// ignore error (pod already running)
-		return nil
+	if err != nil && !strings.Contains(err.Error(), "AlreadyExists") {
+		return fmt.Errorf("failed to create %s: %s", clientName, err)
 	}
 	maxWait := 60 // 60 seconds
 	for {
@@ -180,11 +274,28 @@ func LoadCorefile(corefile string) error {
 // LoadCorefileAndZonefile constructs a configmap defining files for the corefile and zone,
 // If restart is true, restarts the coredns pod to load the new configmap, and waits for the coredns pod to be ready.
 func LoadCorefileAndZonefile(corefile, zonefile string, restart bool) error {
+	return loadConfigmap(corefile, map[string]string{"Zonefile": zonefile}, restart)
+}
+
+// LoadCorefileAndFiles constructs a configmap defining the corefile and files by name, which CoreDNS finds
+// next to the Corefile in /etc/coredns, restarts the coredns pod, and waits for it to be ready.
+func LoadCorefileAndFiles(corefile string, files map[string]string) error {
+	return loadConfigmap(corefile, files, true)
+}
+
+func loadConfigmap(corefile string, files map[string]string, restart bool) error {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
 
 	// apply configmap yaml
 	yamlString := configmap + "\n"
 	yamlString += "  Corefile: |\n" + prepForConfigMap(corefile)
-	yamlString += "  Zonefile: |\n" + prepForConfigMap(zonefile)
+	for _, name := range names {
+		yamlString += "  " + name + ": |\n" + prepForConfigMap(files[name])
+	}
 
 	file, rmFunc, err := test.TempFile(os.TempDir(), yamlString)
 	if err != nil {
@@ -267,7 +378,7 @@ func prepForConfigMap(config string) string {
 	return configOut
 }
 
-// CoreDNSPodIPs return the ips of all coredns pods
+// CoreDNSPodIPs returns the primary IPs of the coredns pods
 func CoreDNSPodIPs() ([]string, error) {
 	lines, err := Kubectl("-n kube-system get pods -l k8s-app=kube-dns  -o wide | awk '{print $6}' | tail -n+2")
 	if err != nil {
@@ -330,11 +441,6 @@ func FetchDockerContainerID(containerName string) (string, error) {
 }
 
 func ScrapeMetrics(t *testing.T) []byte {
-	containerID, err := FetchDockerContainerID("kind-control-plane")
-	if err != nil {
-		t.Fatalf("docker container ID not found, err: %s", err)
-	}
-
 	ips, err := CoreDNSPodIPs()
 	if err != nil {
 		t.Errorf("could not get coredns pod ip: %v", err)
@@ -344,10 +450,9 @@ func ScrapeMetrics(t *testing.T) []byte {
 	}
 
 	ip := ips[0]
-	cmd := fmt.Sprintf("docker exec -i %s /bin/sh -c \"curl -s http://%s:9153/metrics\"", containerID, ip)
-	mf, err := exec.Command("sh", "-c", cmd).CombinedOutput()
+	mf, err := scrapePodMetrics(ip)
 	if err != nil {
-		t.Errorf("error while trying to run command in docker container: %s %v", err, mf)
+		t.Error(err)
 	}
 	if len(mf) == 0 {
 		t.Errorf("unable to scrape metrics from %v", ip)
@@ -355,6 +460,45 @@ func ScrapeMetrics(t *testing.T) []byte {
 	return mf
 }
 
+// scrapePodMetrics fetches the metrics of the coredns pod with the given ip from within the kind node
+func scrapePodMetrics(ip string) ([]byte, error) {
+	containerID, err := FetchDockerContainerID("kind-control-plane")
+	if err != nil {
+		return nil, fmt.Errorf("docker container ID not found, err: %s", err)
+	}
+
+	cmd := fmt.Sprintf("docker exec -i %s /bin/sh -c \"curl -s http://%s:9153/metrics\"", containerID, ip)
+	mf, err := exec.Command("sh", "-c", cmd).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("error while trying to run command in docker container: %s %v", err, mf)
+	}
+	return mf, nil
+}
+
+// NewClient returns a client for the cluster configured in $KUBECONFIG
+func NewClient() (kubernetes.Interface, error) {
+	config, err := clientcmd.BuildConfigFromFlags("", os.Getenv("KUBECONFIG"))
+	if err != nil {
+		return nil, err
+	}
+	return kubernetes.NewForConfig(config)
+}
+
+// CoreDNSPodIPv6s returns the IPv6 addresses of the coredns pods, which have them in a dual-stack cluster
+func CoreDNSPodIPv6s() ([]string, error) {
+	out, err := Kubectl("-n kube-system get pods -l " + CoreDNSLabel + " -o jsonpath='{.items[*].status.podIPs[*].ip}'")
+	if err != nil {
+		return nil, err
+	}
+	var ips []string
+	for _, f := range strings.Fields(out) {
+		if p := net.ParseIP(f); p != nil && p.To4() == nil {
+			ips = append(ips, p.String())
+		}
+	}
+	return ips, nil
+}
+
 // Kubectl executes the kubectl command with the given arguments
 func Kubectl(args string) (result string, err error) {
 	kctl := os.Getenv("KUBECTL")
@@ -678,13 +822,23 @@ func matchSRVResponse(expectedSRV, resultSRV *dns.SRV) (bool, error) {
 	return true, nil
 }
 
-var ipPartMatcher = regexp.MustCompile(`^\d+-\d+-\d+-\d+\.`)
+// ipv6Part matches a dashed IPv6 address label: either 8 groups, or groups around a single "--" where zeros are
+// compressed. Groups are non-empty, so hex-only labels such as "de-1-b" are not mistaken for an address.
+const ipv6Part = `^([0-9a-f]{1,4}(-[0-9a-f]{1,4}){7}|([0-9a-f]{1,4}(-[0-9a-f]{1,4}){0,6})?--([0-9a-f]{1,4}(-[0-9a-f]{1,4}){0,6})?)\.`
+
+var (
+	ipPartMatcher   = regexp.MustCompile(`^\d+-\d+-\d+-\d+\.`)
+	ipv6PartMatcher = regexp.MustCompile(ipv6Part)
+)
 
-// zoneToRelaxedRegex creates a regular expression from a domain name, replacing ipv4 dashed addresses with a
-// more generalised matcher that will match any address.
+// zoneToRelaxedRegex creates a regular expression from a domain name, replacing ipv4 and ipv6 dashed addresses
+// with a more generalised matcher that will match any address.
 func zoneToRelaxedRegex(source string) (*regexp.Regexp, error) {
-	if !ipPartMatcher.MatchString(source) {
-		return regexp.Compile(`^` + source + `$`)
+	switch {
+	case ipPartMatcher.MatchString(source):
+		return regexp.Compile(ipPartMatcher.ReplaceAllString(source, `^\d+-\d+-\d+-\d+\.`) + `$`)
+	case ipv6PartMatcher.MatchString(source):
+		return regexp.Compile(ipv6PartMatcher.ReplaceAllLiteralString(source, ipv6Part) + `$`)
 	}
-	return regexp.Compile(ipPartMatcher.ReplaceAllString(source, `^\d+-\d+-\d+-\d+\.`) + `$`)
+	return regexp.Compile(`^` + source + `$`)
 }
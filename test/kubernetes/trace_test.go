@@ -0,0 +1,122 @@
+package kubernetes
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+func TestKubernetesTrace(t *testing.T) {
+
+	rmFunc, upstream, udp := UpstreamServer(t, "example.net", ExampleNet)
+	defer upstream.Stop()
+	defer rmFunc()
+
+	collector, endpoint := ZipkinServer(t)
+	defer collector.Stop()
+
+	corefile := `    .:53 {
+        health
+        ready
+        errors
+        log
+        trace zipkin ` + endpoint + ` {
+            every 1
+            service coredns
+            zipkin_max_batch_interval 500ms
+        }
+        kubernetes cluster.local {
+            namespaces test-1
+        }
+        forward . ` + udp + `
+    }
+`
+	err := LoadCorefile(corefile)
+	if err != nil {
+		t.Fatalf("Could not load corefile: %s", err)
+	}
+	namespace := "test-1"
+	CollectArtifacts(t, namespace)
+	err = StartClientPod(namespace)
+	if err != nil {
+		t.Fatalf("failed to start client pod: %s", err)
+	}
+
+	tests := []struct {
+		test.Case
+		rcode string
+		spans []string // spans expected below servedns, each a descendant of the previous one
+		not   []string // spans not expected in the trace
+	}{
+		{
+			Case:  dnsTestCasesA[0],
+			rcode: "NOERROR",
+			spans: []string{"kubernetes"},
+			not:   []string{"forward"},
+		},
+		{
+			Case:  dnsTestCasesA[2],
+			rcode: "NXDOMAIN",
+			spans: []string{"kubernetes"},
+			not:   []string{"forward"},
+		},
+		{
+			Case: test.Case{
+				Qname: "example.net.", Qtype: dns.TypeA,
+				Rcode: dns.RcodeSuccess,
+				Answer: []dns.RR{
+					test.A("example.net.	303	IN	A	13.14.15.16"),
+				},
+			},
+			rcode: "NOERROR",
+			spans: []string{"kubernetes", "forward"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(fmt.Sprintf("%s %s", tc.Qname, dns.TypeToString[tc.Qtype]), func(t *testing.T) {
+			res, err := DoIntegrationTest(t, tc.Case, namespace)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			test.CNAMEOrder(res)
+			if err := test.SortAndCheck(res, tc.Case); err != nil {
+				t.Error(err)
+			}
+
+			trace, err := collector.WaitForTrace("servedns", map[string]string{
+				"coredns.io/name":  tc.Qname,
+				"coredns.io/type":  dns.TypeToString[tc.Qtype],
+				"coredns.io/rcode": tc.rcode,
+			}, 10*time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			parent, _ := SpanByName(trace, "servedns")
+			for _, name := range tc.spans {
+				span, ok := SpanByName(trace, name)
+				if !ok {
+					t.Errorf("expected a %q span in trace", name)
+					break
+				}
+				if !IsDescendant(trace, span, parent) {
+					t.Errorf("expected %q span to be a descendant of %q", span.Name, parent.Name)
+				}
+				parent = span
+			}
+			for _, name := range tc.not {
+				if _, ok := SpanByName(trace, name); ok {
+					t.Errorf("did not expect a %q span in trace", name)
+				}
+			}
+			if t.Failed() {
+				t.Errorf("coredns log: %s", CorednsLogs())
+			}
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package kubernetes
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coredns/coredns/pb"
+	"github.com/coredns/coredns/plugin/pkg/doh"
+
+	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// transportTimeout is how long Exchange waits for a response.
+const transportTimeout = 10 * time.Second
+
+// Exchange sends m to server from the test, and returns the response. The scheme of server selects the
+// transport, as in the server blocks of a Corefile: dns:// or no scheme for UDP, tls:// for DNS over TLS,
+// https:// for DNS over HTTPS, grpc:// for gRPC and quic:// for DNS over QUIC, over a connection of its own.
+// config is the TLS configuration of the client for the encrypted transports.
+func Exchange(m *dns.Msg, server string, config *tls.Config) (*dns.Msg, error) {
+	scheme, addr := splitScheme(server)
+	ctx, cancel := context.WithTimeout(context.Background(), transportTimeout)
+	defer cancel()
+
+	switch scheme {
+	case "dns":
+		r, _, err := (&dns.Client{Timeout: transportTimeout}).ExchangeContext(ctx, m, addr)
+		return r, err
+	case "tls":
+		r, _, err := (&dns.Client{Net: "tcp-tls", TLSConfig: config, Timeout: transportTimeout}).ExchangeContext(ctx, m, addr)
+		return r, err
+	case "https":
+		return exchangeHTTPS(ctx, m, addr, config)
+	case "grpc":
+		return exchangeGRPC(ctx, m, addr, config)
+	case "quic":
+		conn, err := DialQUIC(ctx, addr, config)
+		if err != nil {
+			return nil, err
+		}
+		defer conn.Close()
+		return conn.Exchange(ctx, m)
+	}
+	return nil, fmt.Errorf("unsupported transport %q", scheme)
+}
+
+// splitScheme returns the scheme and the address of server, the scheme is dns if server has none.
+func splitScheme(server string) (scheme, addr string) {
+	scheme, addr, ok := strings.Cut(server, "://")
+	if !ok {
+		return "dns", server
+	}
+	return scheme, addr
+}
+
+// encryptedTransport returns whether server is queried with Exchange from the test, instead of with dig
+// from the client pod.
+func encryptedTransport(server string) bool {
+	scheme, _ := splitScheme(server)
+	return scheme != "dns"
+}
+
+func exchangeHTTPS(ctx context.Context, m *dns.Msg, addr string, config *tls.Config) (*dns.Msg, error) {
+	req, err := doh.NewRequest(http.MethodPost, "https://"+addr, m)
+	if err != nil {
+		return nil, err
+	}
+	transport := &http.Transport{TLSClientConfig: config}
+	defer transport.CloseIdleConnections()
+	resp, err := (&http.Client{Transport: transport}).Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return doh.ResponseToMsg(resp)
+}
+
+func exchangeGRPC(ctx context.Context, m *dns.Msg, addr string, config *tls.Config) (*dns.Msg, error) {
+	buf, err := m.Pack()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(credentials.NewTLS(config)))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	reply, err := pb.NewDnsServiceClient(conn).Query(ctx, &pb.DnsPacket{Msg: buf})
+	if err != nil {
+		return nil, err
+	}
+	r := new(dns.Msg)
+	return r, r.Unpack(reply.Msg)
+}
+
+// QUICConn is a DNS over QUIC connection, which sends every query on a stream of its own, as RFC 9250
+// requires. It can be used by concurrent goroutines.
+type QUICConn struct {
+	conn quic.Connection
+
+	mu      sync.Mutex
+	streams []quic.StreamID
+}
+
+// DialQUIC opens a DNS over QUIC connection to addr.
+func DialQUIC(ctx context.Context, addr string, config *tls.Config) (*QUICConn, error) {
+	if config == nil {
+		config = &tls.Config{}
+	}
+	config = config.Clone()
+	config.NextProtos = []string{"doq"}
+	conn, err := quic.DialAddr(ctx, addr, config, &quic.Config{})
+	if err != nil {
+		return nil, err
+	}
+	return &QUICConn{conn: conn}, nil
+}
+
+// Exchange sends m on a new stream of the connection, and returns the response. The message ID is 0 on the
+// wire, as DoQ requires, and set to the ID of m in the response.
+func (c *QUICConn) Exchange(ctx context.Context, m *dns.Msg) (*dns.Msg, error) {
+	q := m.Copy()
+	q.Id = 0
+	buf, err := q.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := c.conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.streams = append(c.streams, stream.StreamID())
+	c.mu.Unlock()
+	if deadline, ok := ctx.Deadline(); ok {
+		stream.SetDeadline(deadline)
+	}
+
+	// the message is prefixed with its length, and the stream closed for writing after it
+	if _, err := stream.Write(binary.BigEndian.AppendUint16(nil, uint16(len(buf)))); err != nil {
+		return nil, err
+	}
+	if _, err := stream.Write(buf); err != nil {
+		return nil, err
+	}
+	if err := stream.Close(); err != nil {
+		return nil, err
+	}
+
+	var size uint16
+	if err := binary.Read(stream, binary.BigEndian, &size); err != nil {
+		return nil, err
+	}
+	buf = make([]byte, size)
+	if _, err := io.ReadFull(stream, buf); err != nil {
+		return nil, err
+	}
+	r := new(dns.Msg)
+	if err := r.Unpack(buf); err != nil {
+		return nil, err
+	}
+	r.Id = m.Id
+	return r, nil
+}
+
+// Streams returns the IDs of the streams the queries of the connection were sent on.
+func (c *QUICConn) Streams() []quic.StreamID {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]quic.StreamID(nil), c.streams...)
+}
+
+// Err returns the error the connection was closed with, or nil while it is open.
+func (c *QUICConn) Err() error {
+	if c.conn.Context().Err() == nil {
+		return nil
+	}
+	return context.Cause(c.conn.Context())
+}
+
+// Close closes the connection with the DoQ error code DOQ_NO_ERROR.
+func (c *QUICConn) Close() error {
+	return c.conn.CloseWithError(0, "")
+}
+
+// WaitForServer waits until server answers a query with Exchange, or times out after timeout with the
+// error of the last query.
+func WaitForServer(server string, config *tls.Config, timeout time.Duration) error {
+	m := new(dns.Msg)
+	m.SetQuestion("kubernetes.default.svc.cluster.local.", dns.TypeA)
+	deadline := time.Now().Add(timeout)
+	for {
+		_, err := Exchange(m, server, config)
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(time.Second)
+	}
+}
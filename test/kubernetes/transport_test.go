@@ -0,0 +1,440 @@
+package kubernetes
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/pb"
+	"github.com/coredns/coredns/plugin/pkg/doh"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	api "k8s.io/api/core/v1"
+)
+
+// transportServerName is the name the certificates of the encrypted transports are issued for.
+const transportServerName = "coredns.test"
+
+// reply answers every query with an A record.
+func reply(r *dns.Msg) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	m.Answer = []dns.RR{test.A(r.Question[0].Name + " 5 IN A 10.96.0.1")}
+	return m
+}
+
+type grpcServer struct {
+	pb.UnimplementedDnsServiceServer
+}
+
+func (grpcServer) Query(_ context.Context, p *pb.DnsPacket) (*pb.DnsPacket, error) {
+	r := new(dns.Msg)
+	if err := r.Unpack(p.Msg); err != nil {
+		return nil, err
+	}
+	buf, err := reply(r).Pack()
+	if err != nil {
+		return nil, err
+	}
+	return &pb.DnsPacket{Msg: buf}, nil
+}
+
+// serve starts a server for scheme on localhost with cert, and returns its address with the scheme.
+func serve(t *testing.T, scheme string, cert tls.Certificate) string {
+	config := &tls.Config{Certificates: []tls.Certificate{cert}}
+	switch scheme {
+	case "tls":
+		l, err := tls.Listen("tcp", "127.0.0.1:0", config)
+		if err != nil {
+			t.Fatalf("could not listen: %s", err)
+		}
+		server := &dns.Server{Listener: l, Net: "tcp-tls", Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+			w.WriteMsg(reply(r))
+		})}
+		go server.ActivateAndServe()
+		t.Cleanup(func() { server.Shutdown() })
+		return "tls://" + l.Addr().String()
+	case "https":
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			r, err := doh.RequestToMsg(req)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			buf, _ := reply(r).Pack()
+			w.Header().Set("Content-Type", doh.MimeType)
+			w.Write(buf)
+		}))
+		server.TLS = config
+		server.StartTLS()
+		t.Cleanup(server.Close)
+		return "https://" + server.Listener.Addr().String()
+	case "grpc":
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("could not listen: %s", err)
+		}
+		server := grpc.NewServer(grpc.Creds(credentials.NewTLS(config)))
+		pb.RegisterDnsServiceServer(server, grpcServer{})
+		go server.Serve(l)
+		t.Cleanup(server.Stop)
+		return "grpc://" + l.Addr().String()
+	case "quic":
+		config.NextProtos = []string{"doq"}
+		l, err := quic.ListenAddr("127.0.0.1:0", config, &quic.Config{})
+		if err != nil {
+			t.Fatalf("could not listen: %s", err)
+		}
+		go serveQUIC(l)
+		t.Cleanup(func() { l.Close() })
+		return "quic://" + l.Addr().String()
+	}
+	t.Fatalf("unsupported transport %q", scheme)
+	return ""
+}
+
+// serveQUIC answers the queries on every stream of the connections accepted by l.
+func serveQUIC(l *quic.Listener) {
+	for {
+		conn, err := l.Accept(context.Background())
+		if err != nil {
+			return
+		}
+		go func() {
+			for {
+				stream, err := conn.AcceptStream(context.Background())
+				if err != nil {
+					return
+				}
+				go func() {
+					defer stream.Close()
+					var size uint16
+					if err := binary.Read(stream, binary.BigEndian, &size); err != nil {
+						return
+					}
+					buf := make([]byte, size)
+					if _, err := io.ReadFull(stream, buf); err != nil {
+						return
+					}
+					r := new(dns.Msg)
+					if err := r.Unpack(buf); err != nil || r.Id != 0 {
+						conn.CloseWithError(0x2, "protocol error")
+						return
+					}
+					buf, _ = reply(r).Pack()
+					stream.Write(binary.BigEndian.AppendUint16(nil, uint16(len(buf))))
+					stream.Write(buf)
+				}()
+			}
+		}()
+	}
+}
+
+func issue(t *testing.T, ca *CA, notBefore, notAfter time.Time) tls.Certificate {
+	certPEM, keyPEM, err := ca.Issue([]string{transportServerName}, notBefore, notAfter)
+	if err != nil {
+		t.Fatalf("could not issue certificate: %s", err)
+	}
+	cert, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+	if err != nil {
+		t.Fatalf("could not load certificate: %s", err)
+	}
+	return cert
+}
+
+func TestExchange(t *testing.T) {
+	ca, err := NewCA()
+	if err != nil {
+		t.Fatalf("could not create CA: %s", err)
+	}
+	other, err := NewCA()
+	if err != nil {
+		t.Fatalf("could not create CA: %s", err)
+	}
+	now := time.Now()
+	valid := issue(t, ca, now.Add(-time.Hour), now.Add(time.Hour))
+	expired := issue(t, ca, now.Add(-2*time.Hour), now.Add(-time.Hour))
+
+	m := new(dns.Msg)
+	m.SetQuestion("svc.ns.svc.cluster.local.", dns.TypeA)
+	for _, scheme := range []string{"tls", "https", "grpc", "quic"} {
+		t.Run(scheme, func(t *testing.T) {
+			server := serve(t, scheme, valid)
+			r, err := Exchange(m, server, ca.ClientConfig(transportServerName))
+			if err != nil {
+				t.Fatalf("expected a response, got %s", err)
+			}
+			if len(r.Answer) != 1 || r.Answer[0].String() != reply(m).Answer[0].String() {
+				t.Errorf("unexpected response %s", r)
+			}
+
+			tests := []struct {
+				name   string
+				server string
+				config *tls.Config
+				err    string
+			}{
+				{"wrong SNI", server, ca.ClientConfig("wrong.test"), "x509: certificate is valid for coredns.test, not wrong.test"},
+				{"unknown CA", server, other.ClientConfig(transportServerName), "x509: certificate signed by unknown authority"},
+				{"expired", serve(t, scheme, expired), ca.ClientConfig(transportServerName), "x509: certificate has expired"},
+			}
+			for _, tc := range tests {
+				_, err := Exchange(m, tc.server, tc.config)
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("%s: expected error %q, got %v", tc.name, tc.err, err)
+				}
+			}
+		})
+	}
+
+	if _, err := Exchange(m, "sctp://127.0.0.1:853", nil); err == nil || !strings.Contains(err.Error(), "unsupported transport") {
+		t.Errorf("expected an unsupported transport, got %v", err)
+	}
+}
+
+func TestQUICConn(t *testing.T) {
+	ca, err := NewCA()
+	if err != nil {
+		t.Fatalf("could not create CA: %s", err)
+	}
+	now := time.Now()
+	_, addr := splitScheme(serve(t, "quic", issue(t, ca, now.Add(-time.Hour), now.Add(time.Hour))))
+
+	ctx, cancel := context.WithTimeout(context.Background(), transportTimeout)
+	defer cancel()
+	conn, err := DialQUIC(ctx, addr, ca.ClientConfig(transportServerName))
+	if err != nil {
+		t.Fatalf("could not connect: %s", err)
+	}
+	defer conn.Close()
+
+	var msgs []*dns.Msg
+	for i := 0; i < 20; i++ {
+		m := new(dns.Msg)
+		m.SetQuestion(fmt.Sprintf("svc-%d.ns.svc.cluster.local.", i), dns.TypeA)
+		msgs = append(msgs, m)
+	}
+	responses, errs := exchangeConcurrently(ctx, conn, msgs)
+	if len(errs) > 0 {
+		t.Fatalf("expected all queries to be answered, got %v", errs)
+	}
+	for i, r := range responses {
+		if r.Id != msgs[i].Id || len(r.Answer) != 1 || r.Answer[0].Header().Name != msgs[i].Question[0].Name {
+			t.Errorf("unexpected response to %s: %s", msgs[i].Question[0].Name, r)
+		}
+	}
+	if err := checkStreams(conn.Streams(), len(msgs)); err != nil {
+		t.Error(err)
+	}
+	if err := conn.Err(); err != nil {
+		t.Errorf("expected the connection to remain open, got %s", err)
+	}
+}
+
+// exchangeConcurrently sends msgs concurrently on conn, and returns their responses, in the order of msgs,
+// and the errors of the queries that failed.
+func exchangeConcurrently(ctx context.Context, conn *QUICConn, msgs []*dns.Msg) ([]*dns.Msg, []error) {
+	responses := make([]*dns.Msg, len(msgs))
+	errs := make([]error, len(msgs))
+	var wg sync.WaitGroup
+	for i, m := range msgs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			responses[i], errs[i] = conn.Exchange(ctx, m)
+		}()
+	}
+	wg.Wait()
+
+	var failed []error
+	for i, err := range errs {
+		if err != nil {
+			failed = append(failed, fmt.Errorf("%s: %s", msgs[i].Question[0].Name, err))
+		}
+	}
+	return responses, failed
+}
+
+// checkStreams checks that n queries were sent on streams of their own, the first n client-initiated
+// bidirectional streams of the connection.
+func checkStreams(streams []quic.StreamID, n int) error {
+	if len(streams) != n {
+		return fmt.Errorf("expected %d streams, got %d", n, len(streams))
+	}
+	ids := append([]quic.StreamID(nil), streams...)
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for i, id := range ids {
+		if id != quic.StreamID(4*i) {
+			return fmt.Errorf("expected the client-initiated bidirectional streams 0 to %d, got %v", 4*(n-1), ids)
+		}
+	}
+	return nil
+}
+
+// encryptedTransports are the server blocks CoreDNS serves the cluster zone on in
+// TestKubernetesEncryptedTransports besides plain DNS, with the name of their node port and their certificate.
+var encryptedTransports = []struct {
+	name   string
+	scheme string
+	port   int32
+	cert   string
+}{
+	{"tls", "tls", 853, "tls"},
+	{"https", "https", 443, "tls"},
+	{"grpc", "grpc", 5553, "tls"},
+	{"tls-expired", "tls", 8853, "expired"},
+}
+
+// responseDiff returns an error describing how b differs from a, ignoring the order of the records.
+func responseDiff(a, b *dns.Msg) error {
+	if a.Rcode != b.Rcode {
+		return fmt.Errorf("rcode %s, expected %s", dns.RcodeToString[b.Rcode], dns.RcodeToString[a.Rcode])
+	}
+	for _, s := range []struct {
+		name string
+		a, b []dns.RR
+	}{{"answer", a.Answer, b.Answer}, {"authority", a.Ns, b.Ns}, {"additional", a.Extra, b.Extra}} {
+		sa, sb := rrStrings(s.a), rrStrings(s.b)
+		if strings.Join(sa, "\n") != strings.Join(sb, "\n") {
+			return fmt.Errorf("%s section %v, expected %v", s.name, sb, sa)
+		}
+	}
+	return nil
+}
+
+func rrStrings(rrs []dns.RR) []string {
+	s := make([]string, len(rrs))
+	for i, rr := range rrs {
+		s[i] = rr.String()
+	}
+	sort.Strings(s)
+	return s
+}
+
+// TestKubernetesEncryptedTransports serves the cluster zone over DNS over TLS, DNS over HTTPS and gRPC, with
+// certificates of a CA generated for the test, and checks that the answers are those of plain DNS. Clients
+// that do not trust the certificate of a server fail to connect, without affecting the server.
+func TestKubernetesEncryptedTransports(t *testing.T) {
+	ca, err := NewCA()
+	if err != nil {
+		t.Fatalf("could not create CA: %s", err)
+	}
+	now := time.Now()
+	files := map[string]string{}
+	files["tls.crt"], files["tls.key"], err = ca.Issue([]string{transportServerName}, now.Add(-time.Hour), now.Add(24*time.Hour))
+	if err != nil {
+		t.Fatalf("could not issue certificate: %s", err)
+	}
+	files["expired.crt"], files["expired.key"], err = ca.Issue([]string{transportServerName}, now.Add(-2*time.Hour), now.Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("could not issue certificate: %s", err)
+	}
+
+	zone := `
+        errors
+        log
+        kubernetes cluster.local 10.in-addr.arpa {
+            namespaces test-1
+        }
+    }
+`
+	corefile := `    .:53 {
+        health
+        ready` + zone
+	for _, tr := range encryptedTransports {
+		corefile += fmt.Sprintf("    %s://.:%d {\n        tls /etc/coredns/%s.crt /etc/coredns/%s.key", tr.scheme, tr.port, tr.cert, tr.cert) + zone
+	}
+	err = LoadCorefileAndFiles(corefile, files)
+	if err != nil {
+		t.Fatalf("Could not load corefile and certificates: %s", err)
+	}
+
+	udp, _ := ExposeCoreDNS(t)
+	ports := []api.ServicePort{}
+	for _, tr := range encryptedTransports {
+		ports = append(ports, api.ServicePort{Name: tr.name, Port: tr.port, Protocol: api.ProtocolTCP})
+	}
+	addrs := ExposeCoreDNSPorts(t, ports...)
+	servers := map[string]string{}
+	for _, tr := range encryptedTransports {
+		servers[tr.name] = tr.scheme + "://" + addrs[tr.name]
+	}
+	config := ca.ClientConfig(transportServerName)
+	for _, name := range []string{"tls", "https", "grpc"} {
+		if err := WaitForServer(servers[name], config, exposeTimeout); err != nil {
+			t.Fatalf("coredns not reachable on %s: %s", servers[name], err)
+		}
+	}
+
+	for _, name := range []string{"tls", "https", "grpc"} {
+		t.Run(name, func(t *testing.T) {
+			var cases []Case
+			for _, tc := range dnsTestCasesA {
+				cases = append(cases, Case{Case: tc, Retry: DefaultRetryPolicy, Server: servers[name], TLS: config})
+			}
+			DoIntegrationCases(t, cases, "test-1")
+
+			// the answers are those of plain DNS, record for record
+			for _, tc := range dnsTestCasesA {
+				m := new(dns.Msg)
+				m.SetQuestion(tc.Qname, tc.Qtype)
+				plain, err := Exchange(m, udp, nil)
+				if err != nil {
+					t.Fatalf("could not query %s over dns: %s", tc.Qname, err)
+				}
+				r, err := Exchange(m, servers[name], config)
+				if err != nil {
+					t.Errorf("could not query %s over %s: %s", tc.Qname, name, err)
+					continue
+				}
+				if err := responseDiff(plain, r); err != nil {
+					t.Errorf("%s %s over %s: %s", tc.Qname, dns.TypeToString[tc.Qtype], name, err)
+				}
+			}
+		})
+	}
+
+	// certificates that cannot be verified fail the handshake of the client
+	m := new(dns.Msg)
+	m.SetQuestion("kubernetes.default.svc.cluster.local.", dns.TypeA)
+	failures := []struct {
+		name   string
+		server string
+		config *tls.Config
+		err    string
+	}{
+		{"tls wrong SNI", servers["tls"], ca.ClientConfig("wrong.test"), "x509: certificate is valid for coredns.test, not wrong.test"},
+		{"https wrong SNI", servers["https"], ca.ClientConfig("wrong.test"), "x509: certificate is valid for coredns.test, not wrong.test"},
+		{"grpc wrong SNI", servers["grpc"], ca.ClientConfig("wrong.test"), "x509: certificate is valid for coredns.test, not wrong.test"},
+		{"tls expired", servers["tls-expired"], config, "x509: certificate has expired"},
+	}
+	for _, f := range failures {
+		t.Run(f.name, func(t *testing.T) {
+			_, err := Exchange(m, f.server, f.config)
+			if err == nil || !strings.Contains(err.Error(), f.err) {
+				t.Errorf("expected error %q, got %v", f.err, err)
+			}
+		})
+	}
+
+	// and the servers still answer clients that trust them
+	for _, name := range []string{"tls", "https", "grpc"} {
+		if _, err := Exchange(m, servers[name], config); err != nil {
+			t.Errorf("coredns stopped answering over %s after failed handshakes: %s", name, err)
+		}
+	}
+}
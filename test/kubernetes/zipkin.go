@@ -0,0 +1,156 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/openzipkin/zipkin-go/model"
+)
+
+// ZipkinCollector is a local stand-in for a Zipkin server, collecting the spans reported by the CoreDNS trace plugin.
+type ZipkinCollector struct {
+	server *http.Server
+	ln     net.Listener
+	spans  []model.SpanModel
+	mu     sync.Mutex
+}
+
+// ZipkinServer starts a Zipkin collector on the local system's ip address, so it can be reached from the
+// coredns pods. It returns the collector and the endpoint to use in the trace plugin configuration.
+func ZipkinServer(t *testing.T) (*ZipkinCollector, string) {
+	ln, err := net.Listen("tcp", net.JoinHostPort(locaIP().String(), "0"))
+	if err != nil {
+		t.Fatalf("could not listen for zipkin spans: %s", err)
+	}
+	z := NewZipkinCollector(ln)
+	return z, z.Endpoint()
+}
+
+// NewZipkinCollector returns a ZipkinCollector serving the Zipkin v2 span API on ln.
+func NewZipkinCollector(ln net.Listener) *ZipkinCollector {
+	z := &ZipkinCollector{ln: ln}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v2/spans", z.handleSpans)
+	z.server = &http.Server{Handler: mux}
+	go z.server.Serve(ln)
+	return z
+}
+
+// Endpoint returns the http endpoint spans should be reported to.
+func (z *ZipkinCollector) Endpoint() string {
+	return "http://" + z.ln.Addr().String() + "/api/v2/spans"
+}
+
+func (z *ZipkinCollector) handleSpans(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var spans []model.SpanModel
+	if err := json.NewDecoder(r.Body).Decode(&spans); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	z.mu.Lock()
+	z.spans = append(z.spans, spans...)
+	z.mu.Unlock()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// Spans returns all spans received so far.
+func (z *ZipkinCollector) Spans() []model.SpanModel {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	spans := make([]model.SpanModel, len(z.spans))
+	copy(spans, z.spans)
+	return spans
+}
+
+// Trace returns all spans of the trace whose top level span has the given name and carries all the given tags.
+// It returns nil if no such trace has been received.
+func (z *ZipkinCollector) Trace(name string, tags map[string]string) []model.SpanModel {
+	spans := z.Spans()
+	for _, root := range spans {
+		if root.ParentID != nil || root.Name != name || !hasTags(root, tags) {
+			continue
+		}
+		var trace []model.SpanModel
+		for _, s := range spans {
+			if s.TraceID == root.TraceID {
+				trace = append(trace, s)
+			}
+		}
+		return trace
+	}
+	return nil
+}
+
+// WaitForTrace waits until a trace matching name and tags has been received, or times out after maxWait.
+// The trace plugin reports spans in batches, so tests should wait before asserting on the spans.
+func (z *ZipkinCollector) WaitForTrace(name string, tags map[string]string, maxWait time.Duration) ([]model.SpanModel, error) {
+	deadline := time.Now().Add(maxWait)
+	for {
+		if trace := z.Trace(name, tags); trace != nil {
+			return trace, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timeout waiting for trace %q with tags %v, received %d spans", name, tags, len(z.Spans()))
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// Reset discards all spans received so far.
+func (z *ZipkinCollector) Reset() {
+	z.mu.Lock()
+	z.spans = nil
+	z.mu.Unlock()
+}
+
+// Stop shuts down the collector.
+func (z *ZipkinCollector) Stop() {
+	z.server.Close()
+}
+
+func hasTags(s model.SpanModel, tags map[string]string) bool {
+	for k, v := range tags {
+		if s.Tags[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// SpanByName returns the first span in trace with the given name.
+func SpanByName(trace []model.SpanModel, name string) (model.SpanModel, bool) {
+	for _, s := range trace {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return model.SpanModel{}, false
+}
+
+// IsDescendant reports whether span is a descendant of ancestor within trace.
+func IsDescendant(trace []model.SpanModel, span, ancestor model.SpanModel) bool {
+	byID := make(map[model.ID]model.SpanModel, len(trace))
+	for _, s := range trace {
+		byID[s.ID] = s
+	}
+	for span.ParentID != nil {
+		if *span.ParentID == ancestor.ID {
+			return true
+		}
+		parent, ok := byID[*span.ParentID]
+		if !ok {
+			return false
+		}
+		span = parent
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+package kubernetes
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/openzipkin/zipkin-go"
+	zipkinhttp "github.com/openzipkin/zipkin-go/reporter/http"
+)
+
+func TestZipkinCollector(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %s", err)
+	}
+	z := NewZipkinCollector(ln)
+	defer z.Stop()
+
+	reporter := zipkinhttp.NewReporter(z.Endpoint())
+	tracer, err := zipkin.NewTracer(reporter)
+	if err != nil {
+		t.Fatalf("could not create tracer: %s", err)
+	}
+
+	root := tracer.StartSpan("servedns", zipkin.Tags(map[string]string{
+		"coredns.io/name":  "example.net.",
+		"coredns.io/type":  "A",
+		"coredns.io/rcode": "NOERROR",
+	}))
+	k8s := tracer.StartSpan("kubernetes", zipkin.Parent(root.Context()))
+	fwd := tracer.StartSpan("forward", zipkin.Parent(k8s.Context()))
+	other := tracer.StartSpan("servedns", zipkin.Tags(map[string]string{"coredns.io/name": "other.net."}))
+	fwd.Finish()
+	k8s.Finish()
+	root.Finish()
+	other.Finish()
+	reporter.Close() // flushes the pending spans
+
+	trace, err := z.WaitForTrace("servedns", map[string]string{"coredns.io/name": "example.net.", "coredns.io/rcode": "NOERROR"}, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trace) != 3 {
+		t.Fatalf("expected 3 spans in trace, got %d", len(trace))
+	}
+
+	rs, ok := SpanByName(trace, "servedns")
+	if !ok {
+		t.Fatal("expected a servedns span")
+	}
+	ks, ok := SpanByName(trace, "kubernetes")
+	if !ok {
+		t.Fatal("expected a kubernetes span")
+	}
+	fs, ok := SpanByName(trace, "forward")
+	if !ok {
+		t.Fatal("expected a forward span")
+	}
+	if !IsDescendant(trace, fs, ks) || !IsDescendant(trace, fs, rs) || !IsDescendant(trace, ks, rs) {
+		t.Error("expected servedns -> kubernetes -> forward span structure")
+	}
+	if IsDescendant(trace, rs, fs) {
+		t.Error("did not expect servedns to be a descendant of forward")
+	}
+
+	if z.Trace("servedns", map[string]string{"coredns.io/name": "missing.net."}) != nil {
+		t.Error("did not expect a trace for missing.net.")
+	}
+
+	z.Reset()
+	if n := len(z.Spans()); n != 0 {
+		t.Errorf("expected no spans after reset, got %d", n)
+	}
+}
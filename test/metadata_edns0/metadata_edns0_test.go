@@ -1,18 +1,26 @@
 package metadataEdns0
 
 import (
+	"encoding/hex"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/coredns/ci/test/kubernetes"
+
+	tap "github.com/dnstap/golang-dnstap"
+	"github.com/miekg/dns"
 )
 
 func TestMetadata(t *testing.T) {
 
+	receiver, tapAddr := kubernetes.DnstapServer(t)
+	defer receiver.Stop()
+
 	corefileMeta := `.:53 {
        ready
        health
+       dnstap ` + tapAddr + ` full
 	   metadata
        metadata_edns0 {
           test 0xffee hex
@@ -26,6 +34,7 @@ func TestMetadata(t *testing.T) {
 		t.Fatalf("Could not load corefile: %s", err)
 	}
 
+	kubernetes.CollectArtifacts(t, "default")
 	err = kubernetes.StartClientPod("default")
 	if err != nil {
 		t.Fatalf("failed to start client pod: %s", err)
@@ -61,4 +70,25 @@ func TestMetadata(t *testing.T) {
 	if !strings.Contains(logged, "Meta: abcdef0123") {
 		t.Errorf("Expected it to contain: Meta: abcdef0123, got %v", logged)
 	}
+
+	// Ensure the EDNS0 option was received by CoreDNS as sent by the client.
+	queries, err := receiver.WaitFor(1, 5*time.Second, tap.Message_CLIENT_QUERY)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, q := range queries {
+		if q.Msg == nil || q.Msg.IsEdns0() == nil {
+			continue
+		}
+		for _, o := range q.Msg.IsEdns0().Option {
+			local, ok := o.(*dns.EDNS0_LOCAL)
+			if ok && local.Code == 65518 && hex.EncodeToString(local.Data) == "abcdef0123" {
+				found = true
+			}
+		}
+	}
+	if !found {
+		t.Errorf("Expected a client query with EDNS0 option 65518:abcdef0123, got %v", queries)
+	}
 }